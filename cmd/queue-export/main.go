@@ -0,0 +1,111 @@
+// Exports the history and current contents of persisted queues, e.g., to
+// analyze attendance in a spreadsheet.
+//
+//	queue-export -stateFilename=state -format=csv -since=2021-03-01 -channel=C123 > visits.csv
+package main
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/server"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+
+	"flag"
+	"os"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Flags
+var (
+	stateFilename string // Root filename of the bot's persistent state.
+	format        string // Export format.
+	since         string // First day exported.
+	until         string // Last day exported.
+	channels      string // Channels exported.
+	team          string // Team exported.
+	waiting       bool   // Whether users waiting are exported.
+	output        string // File written.
+)
+
+func parseDay(s string, end bool) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(dateLayout, s, time.Local)
+	if err != nil {
+		glog.Fatalf("Invalid date %q, expected yyyy-mm-dd: %v", s, err)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func main() {
+	flag.StringVar(&stateFilename, "stateFilename", "", "Root filename of the bot's persistent state.")
+	flag.StringVar(&format, "format", string(service.ExportCSV), "Export format: 'csv' or 'json' (JSON lines).")
+	flag.StringVar(&since, "since", "", "First day exported (yyyy-mm-dd), empty means all history.")
+	flag.StringVar(&until, "until", "", "Last day exported (yyyy-mm-dd), empty means today.")
+	flag.StringVar(&channels, "channel", "", "Comma-separated IDs of the channels exported, empty means all.")
+	flag.StringVar(&team, "team", "", "ID of the team exported, empty means all.")
+	flag.BoolVar(&waiting, "waiting", true, "Whether to export the users waiting in each queue, unless -until is given.")
+	flag.StringVar(&output, "o", "", "File to write, empty means standard output.")
+
+	flag.Parse()
+
+	if stateFilename == "" {
+		glog.Fatalf("Must supply the state filename.")
+	}
+	f, ok := service.ParseExportFormat(format)
+	if !ok {
+		glog.Fatalf("Unknown format %v.", format)
+	}
+	include := make(map[string]bool)
+	for _, c := range strings.Split(channels, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			include[c] = true
+		}
+	}
+	exported := func(t string, c string) bool {
+		return (team == "" || t == team) && (len(include) == 0 || include[c])
+	}
+
+	history := service.MakeHistoryStore(persister.FileLog{Fn: stateFilename + "-history"}, 0)
+	history.Recover()
+	entries, err := history.Query(service.HistoryQuery{TeamID: team, Since: parseDay(since, false), Until: parseDay(until, true)})
+	if err != nil {
+		glog.Fatalf("Error reading history: %v", err)
+	}
+	var records []service.ExportRecord
+	for _, r := range service.HistoryRecords(entries) {
+		if exported(r.TeamID, r.ChannelID) {
+			records = append(records, r)
+		}
+	}
+	if waiting && until == "" {
+		now := time.Now()
+		for _, q := range server.ReadQueues(persister.FilePersister{Fn: stateFilename}) {
+			if exported(q.TeamID, q.ChannelID) {
+				records = append(records, service.WaitingRecords(q.TeamID, q.ChannelID, q.Elements, now)...)
+			}
+		}
+	}
+
+	w := os.Stdout
+	if output != "" {
+		if w, err = os.Create(output); err != nil {
+			glog.Fatalf("Error creating %v: %v", output, err)
+		}
+	}
+	if err = service.WriteExport(w, f, records); err != nil {
+		glog.Fatalf("Error writing export: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		glog.Fatalf("Error writing %v: %v", output, err)
+	}
+	glog.Infof("Exported %d records.", len(records))
+}
@@ -1,40 +1,76 @@
 package main
 
 import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/metrics"
 	"github.com/ml8/slack-queue/pkg/persister"
 	"github.com/ml8/slack-queue/pkg/server"
 	"github.com/ml8/slack-queue/pkg/service"
+	"github.com/ml8/slack-queue/pkg/socket"
 	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
-var api *slack.Client
+var teams *server.TeamStore
 var servers *server.ServerGroup
+var dedupe *server.Dedupe
 
 // Flags
 var (
 	oauth             string // OAuth token
 	signingSecret     string // Application signing secret
+	clientID          string // Application client ID
 	clientSecret      string // Application client secret
 	port              string // Port to listen on
 	cmdUrl            string // URL to receive slash commands
 	actionUrl         string // URL to receive interactions
+	eventsUrl         string // URL to receive Events API requests
+	metricsUrl        string // URL to serve metrics
+	debugToken        string // Bearer token for debug endpoints
+	logBodies         bool   // Whether text entered by users is logged
+	installUrl        string // URL to start an OAuth installation
+	oauthUrl          string // URL to receive OAuth redirects
+	redirectUri       string // Full redirect URI registered with Slack
+	scopes            string // Bot scopes requested on installation
 	authChannel       string // Channel of members permitted to create queues.
 	managementCommand string // Command to manage queues.
 	stateFilename     string // File to store persistent state.
 	listCommand       string // Slash command for list
 	putCommand        string // Slash command for put
 	takeCommand       string // Slash command for take
+	transport         string // How requests are received from Slack.
+	appToken          string // App-level token for Socket Mode
+
+	escalationInterval time.Duration // How often queues are checked for escalation.
+	historyRetention   time.Duration // How long queue history is kept.
+	metricsChannels    int           // Channels labeled by ID in metrics.
+	shutdownTimeout    time.Duration // How long in-flight requests may run on shutdown.
+)
+
+const (
+	httpTransport   = "http"
+	socketTransport = "socket"
 )
 
 func forwardCmd(w http.ResponseWriter, r *http.Request) {
@@ -44,32 +80,45 @@ func forwardCmd(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	r.Body = ioutil.NopCloser(io.TeeReader(r.Body, &verifier))
+	var body bytes.Buffer
+	r.Body = ioutil.NopCloser(io.TeeReader(r.Body, io.MultiWriter(&verifier, &body)))
 	s, err := slack.SlashCommandParse(r)
 	if err != nil {
 		glog.Infof("Unauthorized: %v", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	glog.V(1).Infof("Command parsed as %v for %v", s.Command, s)
+	if err = verifier.Ensure(); err != nil {
+		glog.Infof("Unauthorized: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ctx := server.CommandContext(context.Background(), &s)
+	log := logging.FromContext(ctx)
+	log.Infof("Received command %v", s.Command)
+	log.Debugf("Command text %v", logging.Body(s.Text))
+
+	if num, reason := server.RetryInfo(r.Header); num != "" {
+		log.Infof("Slack retry %s (%s) of command %v", num, reason, s.Command)
+	}
+	dedupe.Do(server.RequestKey(s.TriggerID, body.Bytes()), w, func(w http.ResponseWriter) {
+		handleCmd(ctx, &s, w)
+	})
+}
 
+func handleCmd(ctx context.Context, s *slack.SlashCommand, w http.ResponseWriter) {
 	if s.Command == managementCommand {
-		servers.Manage(&s, w)
+		servers.Manage(ctx, s, w)
 		return
 	}
 
-	srv, ok := servers.Lookup(s.ChannelID)
+	srv, ok := servers.Lookup(s.TeamID, s.ChannelID)
 	if !ok {
-		glog.Infof("No server for channel %s (%s)", s.ChannelID, s.ChannelName)
-		_, _, _ = api.PostMessage(s.ChannelID,
-			slack.MsgOptionText(
-				fmt.Sprintf("No queue exists for channel %s, use %s to create one.", s.ChannelName, managementCommand), false),
-			slack.MsgOptionPostEphemeral(s.UserID))
-		w.WriteHeader(http.StatusOK)
+		logging.FromContext(ctx).Infof("No server for channel %s", s.ChannelName)
+		servers.ReplyNoQueue(s, w)
 		return
 	}
-	srv.ForwardCommand(&s, w)
-
+	srv.ForwardCommand(ctx, s, w)
 }
 
 func forwardAction(w http.ResponseWriter, r *http.Request) {
@@ -86,13 +135,17 @@ func forwardAction(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if err = verifier.Ensure(); err != nil {
+		glog.Infof("Unauthorized: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 	js, err := url.QueryUnescape(string(buff))
 	if err != nil {
 		glog.Errorf("Error unescaping body: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	glog.V(2).Infof("Action callback:\n%v", js)
 	js = strings.TrimPrefix(js, "payload=")
 	var cb slack.InteractionCallback
 	if err := json.Unmarshal([]byte(js), &cb); err != nil {
@@ -101,17 +154,102 @@ func forwardAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	glog.V(2).Infof("Action callback:\n%v", js)
+	ctx := server.ActionContext(context.Background(), &cb)
+	log := logging.FromContext(ctx)
+	log.Infof("Received interaction %v", cb.Type)
+	log.Debugf("Interaction payload %v", logging.Body(js))
 
+	if num, reason := server.RetryInfo(r.Header); num != "" {
+		log.Infof("Slack retry %s (%s) of interaction", num, reason)
+	}
+	dedupe.Do(server.RequestKey(cb.TriggerID, buff), w, func(w http.ResponseWriter) {
+		handleAction(ctx, &cb, w)
+	})
+}
+
+func handleAction(ctx context.Context, cb *slack.InteractionCallback, w http.ResponseWriter) {
 	// TODO: is this the correct channel, when is cb.Channel and
 	// cb.Container.Channel different?
-	srv, ok := servers.Lookup(cb.Channel.ID)
+	// Actions outside of a queue's channel (e.g., in the App Home or the admin
+	// channel's control panel) identify the queue's channel in the block ID.
+	channel := service.ActionChannel(cb)
+	if channel == "" {
+		channel = cb.Channel.ID
+	}
+	if service.IsMeetingAction(cb) {
+		// Link buttons open the link themselves; just acknowledge.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	srv, ok := servers.Lookup(cb.Team.ID, channel)
 	if !ok {
-		glog.Errorf("Received interaction for unserved channel %s (%s)", cb.Channel.ID, cb.Channel.Name)
-		w.WriteHeader(http.StatusNotFound)
+		err := service.Errorf(service.ErrNotFound, "interaction for unserved channel %s (%s)", channel, cb.Channel.Name)
+		api, ok := servers.Client(cb.Team.ID)
+		if !ok {
+			logging.FromContext(ctx).Errorf("No installation for team %v: %v", cb.Team.ID, err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		service.ReplyActionError(ctx, w, api, cb, service.DefaultLocale, err)
+		return
+	}
+	srv.ForwardAction(ctx, cb, w)
+}
+
+// Dispatches Socket Mode requests to the same handlers as HTTP requests.
+type dispatcher struct{}
+
+func (d dispatcher) Command(ctx context.Context, cmd *slack.SlashCommand, w http.ResponseWriter) {
+	handleCmd(ctx, cmd, w)
+}
+
+func (d dispatcher) Action(ctx context.Context, cb *slack.InteractionCallback, w http.ResponseWriter) {
+	handleAction(ctx, cb, w)
+}
+
+func (d dispatcher) Event(ctx context.Context, ev *server.Event) {
+	servers.HandleEvent(ctx, ev)
+}
+
+func forwardEvent(w http.ResponseWriter, r *http.Request) {
+	verifier, err := slack.NewSecretsVerifier(r.Header, signingSecret)
+	if err != nil {
+		glog.Infof("Could not create verifier: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(io.TeeReader(r.Body, &verifier))
+	if err != nil {
+		glog.Errorf("Error reading request body: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err = verifier.Ensure(); err != nil {
+		glog.Infof("Unauthorized: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	srv.ForwardAction(&cb, w)
+	ev, err := server.ParseEvent(body)
+	if err != nil {
+		glog.Errorf("Error unmarshalling event: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if ev.Type == slackevents.URLVerification {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(ev.Challenge))
+		return
+	}
+
+	ctx := server.EventContext(context.Background(), ev)
+	if num, reason := server.RetryInfo(r.Header); num != "" {
+		logging.FromContext(ctx).Infof("Slack retry %s (%s) of event", num, reason)
+	}
+	dedupe.Do(server.RequestKey(ev.EventID, body), w, func(w http.ResponseWriter) {
+		servers.HandleEvent(ctx, ev)
+		w.WriteHeader(http.StatusOK)
+	})
 }
 
 func slashify(s string) string {
@@ -124,24 +262,45 @@ func slashify(s string) string {
 func main() {
 	flag.StringVar(&oauth, "oauth", "", "OAuth Token")
 	flag.StringVar(&signingSecret, "ssecret", "", "Application signing secret")
+	flag.StringVar(&clientID, "cid", "", "Application client ID")
 	flag.StringVar(&clientSecret, "csecret", "", "Application client secret")
 	flag.StringVar(&port, "p", ":1000", "Port to listen on")
 	flag.StringVar(&cmdUrl, "cmdUrl", "/slash", "URL to receive slash commands (e.g., '/slash' or '/receive', etc.)")
 	flag.StringVar(&actionUrl, "actionUrl", "/action", "URL to receive actions")
-	flag.StringVar(&authChannel, "authChannel", "", "Channel authorized to create queues, empty means anyone can create a queue.")
+	flag.StringVar(&eventsUrl, "eventsUrl", "/events", "URL to receive Events API requests")
+	flag.StringVar(&metricsUrl, "metricsUrl", "/metrics", "URL to serve Prometheus metrics, empty to disable")
+	flag.BoolVar(&logBodies, "logBodies", false, "Log text entered by users, such as command arguments and topics, instead of redacting it")
+	flag.StringVar(&debugToken, "debugToken", "", "Bearer token required by /debug/queues, empty to disable")
+	flag.StringVar(&installUrl, "installUrl", "/install", "URL to start installation into a workspace")
+	flag.StringVar(&oauthUrl, "oauthUrl", "/oauth", "URL to receive OAuth redirects")
+	flag.StringVar(&redirectUri, "redirectUri", "", "Full OAuth redirect URI, if more than one is registered with Slack")
+	flag.StringVar(&scopes, "scopes", defaultScopes, "Bot scopes requested when installing into a workspace")
+	flag.StringVar(&authChannel, "authChannel", "", "Channel (name or ID) or user group authorized to create queues, empty means anyone can create a queue.")
 	flag.StringVar(&managementCommand, "managementCommand", "queue", "Command used to manage queues.")
 	flag.StringVar(&stateFilename, "stateFilename", "", "Root filename for persistent state.")
 	flag.StringVar(&listCommand, "listCommand", "list", "Name of list slash command.")
 	flag.StringVar(&putCommand, "putCommand", "enqueue", "Name of list slash command.")
 	flag.StringVar(&takeCommand, "takeCommand", "dequeue", "Name of take slash command.")
+	flag.StringVar(&transport, "transport", httpTransport, "How to receive requests from Slack: 'http' (public endpoints) or 'socket' (Socket Mode).")
+	flag.StringVar(&appToken, "appToken", "", "App-level token, required for Socket Mode.")
+	flag.DurationVar(&escalationInterval, "escalationInterval", server.DefaultEscalationInterval, "How often queues are checked against their escalation thresholds.")
+	flag.DurationVar(&historyRetention, "historyRetention", service.DefaultHistoryRetention, "How long queue history is kept.")
+	flag.DurationVar(&shutdownTimeout, "shutdownTimeout", server.DefaultShutdownTimeout, "How long in-flight requests may run once a shutdown signal is received.")
+	flag.IntVar(&metricsChannels, "metricsChannels", metrics.DefaultMaxChannels, "Channels labeled by ID in metrics; others are labeled 'other'. Negative labels all.")
 
 	flag.Parse()
 
-	glog.Infof("Starting on port %v ...", port)
+	glog.Infof("Starting with %v transport...", transport)
 
 	if managementCommand == "" {
 		glog.Fatalf("Must supply a management command.")
 	}
+	if transport != httpTransport && transport != socketTransport {
+		glog.Fatalf("Unknown transport %v.", transport)
+	}
+	if transport == socketTransport && appToken == "" {
+		glog.Fatalf("Must supply an app-level token for Socket Mode.")
+	}
 
 	managementCommand = slashify(managementCommand)
 	listCommand = slashify(listCommand)
@@ -149,29 +308,159 @@ func main() {
 	takeCommand = slashify(takeCommand)
 
 	glog.Infof("Using %s for management commands.", managementCommand)
+	logging.SetLogBodies(logBodies)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	serviceMetrics := service.NewMetrics(reg, metrics.MakeChannelLabeler(metricsChannels))
+	writeLatency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "slackqueue_persister_write_duration_seconds",
+		Help:    "Latency of writing persistent state.",
+		Buckets: metrics.DefaultBuckets,
+	})
+	reg.MustRegister(writeLatency)
 
-	api = slack.New(oauth)
+	var fallback *service.SlackClient
+	var fallbackTeam string
+	if oauth != "" {
+		fallback = service.NewSlackClient(oauth, service.DefaultRetryPolicy, serviceMetrics)
+		auth, err := fallback.Client.AuthTest()
+		if err != nil {
+			glog.Fatalf("Error identifying the workspace of the OAuth token: %v", err)
+		}
+		fallbackTeam = auth.TeamID
+		glog.Infof("Using the OAuth token for team %v.", fallbackTeam)
+	}
 
 	var persist persister.Persister
+	var teamPersist persister.Persister
+	var profilePersist persister.Persister
+	var historyLog persister.Log
 	if stateFilename != "" {
 		glog.Infof("Using %v for persistence.", stateFilename)
-		persist = persister.FilePersister{Fn: stateFilename}
+		persist = persister.FilePersister{Fn: stateFilename, Latency: writeLatency}
+		teamPersist = persister.FilePersister{Fn: stateFilename + "-teams", Latency: writeLatency}
+		profilePersist = persister.FilePersister{Fn: stateFilename + "-profiles", Latency: writeLatency}
+		historyLog = persister.FileLog{Fn: stateFilename + "-history"}
 	} else {
 		glog.Infof("Using in-memory state.")
 	}
 
+	teams = server.MakeTeamStore(fallback, fallbackTeam, serviceMetrics, teamPersist)
+	teams.Recover()
+
+	profiles := service.MakeProfileStore(profilePersist)
+	profiles.Recover()
+
+	history := service.MakeHistoryStore(historyLog, historyRetention)
+	history.Recover()
+	if err := history.Compact(); err != nil {
+		glog.Errorf("Error compacting history: %v", err)
+	}
+
 	servers = server.CreateServerGroup(
-		api,
-		service.AdminInterfaceFromChannel(api, authChannel),
+		teams,
+		profiles,
+		history,
+		authChannel,
 		managementCommand,
 		service.CommandNames{List: listCommand, Put: putCommand, Take: takeCommand},
 		persist)
+	servers.RegisterMetrics(reg, serviceMetrics)
 
 	servers.Recover()
 
-	http.HandleFunc(cmdUrl, forwardCmd)
-	http.HandleFunc(actionUrl, forwardAction)
+	stop := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		glog.Infof("Received %v, shutting down...", <-signals)
+		close(stop)
+	}()
+	go servers.WatchEscalations(escalationInterval, stop)
+
+	dedupe = server.MakeDedupe(server.DefaultDedupeTTL)
+
+	if transport == httpTransport {
+		// Over Socket Mode, Slack delivers these through the runner.
+		http.HandleFunc(cmdUrl, forwardCmd)
+		http.HandleFunc(actionUrl, forwardAction)
+		http.HandleFunc(eventsUrl, forwardEvent)
+	}
+	if metricsUrl != "" {
+		http.Handle(metricsUrl, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+	http.HandleFunc("/healthz", servers.HandleHealthz)
+	http.HandleFunc("/readyz", servers.HandleReadyz)
+	if debugToken != "" {
+		http.Handle("/debug/queues", servers.DebugHandler(debugToken))
+	}
+	if clientID != "" {
+		http.HandleFunc(installUrl, install)
+		http.HandleFunc(oauthUrl, oauthRedirect)
+	}
+
+	l, err := net.Listen("tcp", port)
+	if err != nil {
+		glog.Fatalf("Error listening on %v: %v", port, err)
+	}
+	glog.Infof("Listening on port %v...", port)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(&http.Server{}, l, stop, shutdownTimeout)
+	}()
 
-	glog.Infof("Listening...")
-	http.ListenAndServe(port, nil)
+	var runErr error
+	if transport == socketTransport {
+		runner := socket.MakeRunner(slack.APIURL, appToken, dispatcher{}, dedupe, shutdownTimeout)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-stop
+			cancel()
+		}()
+		glog.Infof("Connecting via Socket Mode...")
+		// Returns once requests being handled finish, or the deadline passes.
+		if runErr = runner.Run(ctx); runErr == context.Canceled {
+			runErr = nil
+		}
+	}
+
+	err = <-served
+	if err == nil {
+		err = runErr
+	}
+	shutdown(err)
+}
+
+// Persists state and sends queued admin messages once requests and background
+// work are drained. Exits with an error if they weren't, or if serving failed.
+func shutdown(err error) {
+	if drain, ok := err.(server.DrainError); ok {
+		glog.Warningf("Forcing flush with %d requests still in flight.", drain.InFlight)
+	} else if err != nil {
+		glog.Errorf("Error serving: %v", err)
+	}
+	// Panel and home refreshes, and escalation checks, may still change state
+	// or queue admin messages.
+	if !servers.DrainBackground(shutdownTimeout) {
+		err = fmt.Errorf("background work still running")
+	}
+	flushed := make(chan struct{})
+	go func() {
+		servers.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(shutdownTimeout):
+		glog.Errorf("Timed out flushing state after %v.", shutdownTimeout)
+		err = fmt.Errorf("flush timed out")
+	}
+	teams.Close(shutdownTimeout)
+	glog.Flush()
+	if err != nil {
+		os.Exit(1)
+	}
+	glog.Infof("Shutdown complete.")
+	glog.Flush()
 }
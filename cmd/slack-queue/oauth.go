@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/ml8/slack-queue/pkg/server"
+	"github.com/slack-go/slack"
+
+	"github.com/golang/glog"
+
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	authorizeUrl    = "https://slack.com/oauth/v2/authorize"
+	stateCookieName = "slack-queue-oauth-state"
+	defaultScopes   = "commands,chat:write,channels:read,groups:read,users:read,im:write,mpim:write,channels:manage,groups:write,pins:write,files:write,usergroups:read"
+)
+
+// Redirects to Slack's authorization page to install the app into a
+// workspace.
+func install(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		glog.Errorf("Error generating OAuth state: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true})
+
+	params := url.Values{
+		"client_id": {clientID},
+		"scope":     {scopes},
+		"state":     {state}}
+	if redirectUri != "" {
+		params.Set("redirect_uri", redirectUri)
+	}
+	http.Redirect(w, r, authorizeUrl+"?"+params.Encode(), http.StatusFound)
+}
+
+// Completes an installation by exchanging the authorization code for a bot
+// token, which is stored for the installing team.
+func oauthRedirect(w http.ResponseWriter, r *http.Request) {
+	// Replies are plain text, never markup, as they may include the team's name.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		glog.Infof("Installation was not authorized: %q", e)
+		fmt.Fprint(w, "Installation cancelled.")
+		return
+	}
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(q.Get("state"))) != 1 {
+		glog.Infof("OAuth state mismatch")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := slack.GetOAuthV2Response(http.DefaultClient, clientID, clientSecret, q.Get("code"), redirectUri)
+	if err != nil {
+		glog.Errorf("Error exchanging OAuth code: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	teams.Install(server.Team{
+		ID:          resp.Team.ID,
+		Name:        resp.Team.Name,
+		BotUserID:   resp.BotUserID,
+		AccessToken: resp.AccessToken})
+	fmt.Fprintf(w, "Installed into %s. Use %s in a channel to create a queue.", resp.Team.Name, managementCommand)
+}
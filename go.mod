@@ -5,5 +5,7 @@ go 1.15
 require (
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/prometheus/client_golang v1.12.2
 	github.com/slack-go/slack v0.7.3
 )
@@ -0,0 +1,123 @@
+// Package logging writes leveled log lines with consistent key=value fields
+// through glog, and carries a request's fields in its context so that every
+// line logged while handling it can be correlated.
+package logging
+
+import (
+	"github.com/golang/glog"
+
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Field keys, used consistently across packages.
+const (
+	Request = "request" // ID of the incoming request
+	Kind    = "kind"    // command, action or event
+	Team    = "team"
+	Channel = "channel"
+	User    = "user"
+	Action  = "action" // command, action or event name
+	Event   = "event"  // ID of an Events API event
+	Seq     = "seq"    // queue version
+	Text    = "text"   // user-entered text, redacted unless enabled
+)
+
+// Appends fields to log lines.
+//
+// The zero value logs without fields. Loggers are values; With returns a copy.
+type Logger struct {
+	fields string
+}
+
+// Returns a logger with the key, value pairs added to its fields.
+func (l Logger) With(kv ...interface{}) Logger {
+	var b strings.Builder
+	b.WriteString(l.fields)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%s", kv[i], formatValue(kv[i+1]))
+	}
+	return Logger{b.String()}
+}
+
+func formatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+func (l Logger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, fmt.Sprintf(format, args...)+l.fields)
+}
+
+func (l Logger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, fmt.Sprintf(format, args...)+l.fields)
+}
+
+func (l Logger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...)+l.fields)
+}
+
+// Logs at Info level when verbosity is at least 1 (-v=1).
+func (l Logger) Debugf(format string, args ...interface{}) {
+	if glog.V(1) {
+		glog.InfoDepth(1, fmt.Sprintf(format, args...)+l.fields)
+	}
+}
+
+type loggerKey struct{}
+
+// Returns a context carrying l.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Returns the logger carried by ctx, or one without fields.
+func FromContext(ctx context.Context) Logger {
+	if ctx == nil {
+		return Logger{}
+	}
+	l, _ := ctx.Value(loggerKey{}).(Logger)
+	return l
+}
+
+// Returns a context carrying a logger with a new request ID and the given
+// fields, e.g., on receiving a command from Slack.
+func StartRequest(ctx context.Context, kind string, kv ...interface{}) context.Context {
+	l := FromContext(ctx).With(append([]interface{}{Request, NewRequestID(), Kind, kind}, kv...)...)
+	return NewContext(ctx, l)
+}
+
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		glog.Errorf("Error generating request ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var logBodies int32
+
+// Sets whether message bodies and other text entered by users are logged.
+// Off by default.
+func SetLogBodies(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&logBodies, v)
+}
+
+// Returns s if bodies are logged, otherwise only its length.
+func Body(s string) string {
+	if atomic.LoadInt32(&logBodies) == 1 {
+		return s
+	}
+	return fmt.Sprintf("<redacted %d bytes>", len(s))
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWith(t *testing.T) {
+	l := Logger{}.With(Channel, "C1", User, "U1").With(Seq, int64(3), Text, "two words")
+	if want := ` channel=C1 user=U1 seq=3 text="two words"`; l.fields != want {
+		t.Fatalf("Expected fields %q, got %q", want, l.fields)
+	}
+	if (Logger{}).With(Channel).fields != "" {
+		t.Fatalf("Expected an unpaired key to be ignored")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()).fields != "" {
+		t.Fatalf("Expected no fields without a logger")
+	}
+	a := StartRequest(context.Background(), "command", Channel, "C1")
+	b := StartRequest(context.Background(), "command", Channel, "C1")
+	fa, fb := FromContext(a).fields, FromContext(b).fields
+	if !strings.HasPrefix(fa, " request=") || !strings.HasSuffix(fa, " kind=command channel=C1") {
+		t.Fatalf("Unexpected fields %q", fa)
+	}
+	if fa == fb {
+		t.Fatalf("Expected distinct request IDs, got %q twice", fa)
+	}
+}
+
+func TestBody(t *testing.T) {
+	defer SetLogBodies(false)
+	if got := Body("secret"); got != "<redacted 6 bytes>" {
+		t.Fatalf("Expected the body redacted, got %q", got)
+	}
+	SetLogBodies(true)
+	if got := Body("secret"); got != "secret" {
+		t.Fatalf("Expected the body, got %q", got)
+	}
+}
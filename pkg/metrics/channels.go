@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+)
+
+const (
+	// Channels labeled by ID, by default.
+	DefaultMaxChannels = 100
+	// Label of channels beyond the maximum.
+	OtherChannel = "other"
+)
+
+// Limits the number of distinct channel labels: the first channels seen are
+// labeled by ID, later ones as OtherChannel. A negative maximum labels every
+// channel by ID; zero labels none.
+//
+// Thread safe.
+type ChannelLabeler struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]bool
+}
+
+func MakeChannelLabeler(max int) *ChannelLabeler {
+	return &ChannelLabeler{max: max, seen: make(map[string]bool)}
+}
+
+func (l *ChannelLabeler) Label(channel string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max < 0 || l.seen[channel] {
+		return channel
+	}
+	if len(l.seen) >= l.max {
+		return OtherChannel
+	}
+	l.seen[channel] = true
+	return channel
+}
@@ -0,0 +1,58 @@
+// Package metrics helps expose Prometheus metrics: latency buckets, bounded
+// channel labels and metrics computed when scraped.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"strings"
+)
+
+// Buckets, in seconds, for latencies of calls to Slack and the disk.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Sets a sample of a metric computed when scraped. Values set more than once
+// for the same labels are summed.
+type SetFunc func(v float64, values ...string)
+
+// A metric computed when scraped, e.g., from the state of the queues or the
+// stats of a cache.
+type funcCollector struct {
+	desc    *prometheus.Desc
+	kind    prometheus.ValueType
+	collect func(set SetFunc)
+}
+
+// A gauge computed by collect when scraped.
+func NewGaugeFunc(name string, help string, labels []string, collect func(set SetFunc)) prometheus.Collector {
+	return &funcCollector{prometheus.NewDesc(name, help, labels, nil), prometheus.GaugeValue, collect}
+}
+
+// A counter computed by collect when scraped, e.g., from a component's own
+// counters.
+func NewCounterFunc(name string, help string, labels []string, collect func(set SetFunc)) prometheus.Collector {
+	return &funcCollector{prometheus.NewDesc(name, help, labels, nil), prometheus.CounterValue, collect}
+}
+
+func (c *funcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *funcCollector) Collect(ch chan<- prometheus.Metric) {
+	samples := make(map[string]float64)
+	values := make(map[string][]string)
+	c.collect(func(v float64, vals ...string) {
+		k := key(vals)
+		if _, ok := values[k]; !ok {
+			values[k] = append([]string(nil), vals...)
+		}
+		samples[k] += v
+	})
+	for k, v := range samples {
+		ch <- prometheus.MustNewConstMetric(c.desc, c.kind, v, values[k]...)
+	}
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
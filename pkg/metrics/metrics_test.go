@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"strings"
+	"testing"
+)
+
+func TestFuncCollector(t *testing.T) {
+	c := NewGaugeFunc("test_length", "Length.", []string{"channel"}, func(set SetFunc) {
+		set(1, "C1")
+		set(2, "C1")
+		set(4, `a"b`)
+	})
+	expected := `
+# HELP test_length Length.
+# TYPE test_length gauge
+test_length{channel="C1"} 3
+test_length{channel="a\"b"} 4
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannelLabeler(t *testing.T) {
+	l := MakeChannelLabeler(2)
+	for _, c := range []string{"C1", "C2", "C1"} {
+		if l.Label(c) != c {
+			t.Fatalf("Expected %v labeled by ID", c)
+		}
+	}
+	if l.Label("C3") != OtherChannel {
+		t.Fatalf("Expected C3 labeled %v", OtherChannel)
+	}
+	if l := MakeChannelLabeler(-1); l.Label("C3") != "C3" {
+		t.Fatalf("Expected unlimited labels")
+	}
+}
@@ -0,0 +1,93 @@
+package persister
+
+import (
+	"github.com/golang/glog"
+
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// An append-only log of records, e.g., events that are only ever added to.
+// Unlike a Persister, appending doesn't rewrite earlier records.
+type Log interface {
+	Append(record interface{}) error
+	// Calls fn with each record, oldest first.
+	Read(fn func(record json.RawMessage) error) error
+	// Replaces the log's records, e.g., to compact it.
+	Rewrite(records []interface{}) error
+}
+
+// A Log in a file of JSON values, one per line.
+type FileLog struct {
+	Fn string
+}
+
+func (fl FileLog) Append(record interface{}) (err error) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding record for %v: %w", fl.Fn, err)
+	}
+	f, err := os.OpenFile(fl.Fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (fl FileLog) Read(fn func(record json.RawMessage) error) (err error) {
+	f, err := os.Open(fl.Fn)
+	if os.IsNotExist(err) {
+		glog.Infof("Nothing to recover from %v...", fl.Fn)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var record json.RawMessage
+		if err = dec.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading %v: %w", fl.Fn, err)
+		}
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Writes the records to a temporary file, then replaces the log with it.
+func (fl FileLog) Rewrite(records []interface{}) (err error) {
+	tmp := fl.Fn + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rewriting %v: %w", fl.Fn, err)
+	}
+	return os.Rename(tmp, fl.Fn)
+}
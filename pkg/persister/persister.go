@@ -4,7 +4,10 @@ import (
 	"github.com/golang/glog"
 
 	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type Persister interface {
@@ -15,6 +18,13 @@ type Persister interface {
 
 type FilePersister struct {
 	Fn string
+	// Observes the seconds each write takes, if not nil.
+	Latency Observer
+}
+
+// E.g., a Prometheus histogram.
+type Observer interface {
+	Observe(v float64)
 }
 
 func (fp FilePersister) Id() string {
@@ -22,28 +32,45 @@ func (fp FilePersister) Id() string {
 }
 
 func (fp FilePersister) Write(state interface{}) (err error) {
+	if fp.Latency != nil {
+		defer func(start time.Time) { fp.Latency.Observe(time.Since(start).Seconds()) }(time.Now())
+	}
 	glog.V(3).Infof("Writing to %v", fp.Fn)
 	glog.V(3).Infof("Backing up %v", fp.Fn)
 	err = os.Rename(fp.Fn, fp.Fn+".bak")
 	if err != nil && !os.IsNotExist(err) {
-		glog.Fatalln("Could not rename %v: %v", fp.Fn, err)
+		glog.Fatalf("Could not rename %v: %v", fp.Fn, err)
 	}
 	f, err := os.Create(fp.Fn)
 	if err != nil {
-		glog.Fatalln("Error opening %v: %v", fp.Fn, err)
+		glog.Fatalf("Error opening %v: %v", fp.Fn, err)
 	}
 
-	glog.Infof("%+v", state)
-
 	writer := json.NewEncoder(f)
 	err = writer.Encode(state)
 
 	if err != nil {
-		glog.Fatalf("Error encoding %v", state)
+		glog.Fatalf("Error encoding state for %v: %v", fp.Fn, err)
 	}
+	glog.V(1).Infof("Wrote %v", fp.Fn)
 	return
 }
 
+// Returns an error if state can't be written with p, e.g., because its
+// directory is read-only. Checks only FilePersisters; nil is in-memory.
+func CheckWritable(p Persister) error {
+	fp, ok := p.(FilePersister)
+	if !ok {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fp.Fn), filepath.Base(fp.Fn)+".check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 func (fp FilePersister) Read(state interface{}) {
 	glog.V(3).Infof("Reading from %v", fp.Fn)
 	f, err := os.Open(fp.Fn)
@@ -55,12 +82,12 @@ func (fp FilePersister) Read(state interface{}) {
 			glog.Fatalln(err)
 		}
 	}
-	glog.Infof("%+v", state)
 
 	reader := json.NewDecoder(f)
 	err = reader.Decode(state)
 	if err != nil {
 		glog.Fatalln(err)
 	}
+	glog.V(1).Infof("Read %v", fp.Fn)
 	return
 }
@@ -12,9 +12,16 @@ import (
 type Element struct {
 	Id       string    `json:"Id"`
 	Metadata string    `json:"Metadata"`
+	Fields   []Field   `json:"Fields,omitempty"`
 	QTime    time.Time `json:"QTime"`
 }
 
+// Structured metadata, e.g., an answer to an intake form.
+type Field struct {
+	Label string `json:"Label"`
+	Value string `json:"Value"`
+}
+
 type Queue interface {
 	Put(el Element) (pos int, err error)
 	TakeFront() (el Element, err error)
@@ -62,7 +69,7 @@ func (q *queueImpl) Recover() {
 	state := QueueState{}
 	q.persist.Read(&state)
 	q.els = state.Elements
-	glog.Infof("Recovered queue: %v", q.els)
+	glog.Infof("Recovered queue of %d: %v", len(q.els), q.dlist())
 }
 
 func (q *queueImpl) Persist() {
@@ -128,7 +135,7 @@ func (q *queueImpl) TakeFront() (el Element, err error) {
 
 func (q *queueImpl) takeInternal(i int) (el Element, err error) {
 	if i < 0 || i >= len(q.els) {
-		glog.Errorf("Fail to take element %d for queue length %d (%v)", i, len(q.els), q.els)
+		glog.Errorf("Fail to take element %d for queue length %d (%v)", i, len(q.els), q.dlist())
 		err = errors.New("No such element")
 		return
 	}
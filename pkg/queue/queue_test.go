@@ -1,7 +1,7 @@
 package queue
 
 import (
-	"github.com/ml8/slack-queue/persister"
+	"github.com/ml8/slack-queue/pkg/persister"
 
 	"bytes"
 	"io"
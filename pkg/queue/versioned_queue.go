@@ -17,13 +17,23 @@ import (
 //
 // Version mismatches return a VersionError.
 //
+// Subscribers are notified with the contents of the queue after every
+// modification.
+//
 // Thread safe.
 type VersionedQueue struct {
-	q   Queue // wrapped queue
-	seq int64 // sequence number
-	mu  sync.Mutex
+	q    Queue // wrapped queue
+	seq  int64 // sequence number
+	mu   sync.Mutex
+	subs []Subscriber
 }
 
+// Called with a snapshot of the queue and its version after a modification.
+// Called without the queue's lock held, so notifications for concurrent
+// modifications may arrive out of order; subscribers should discard versions
+// older than one already seen.
+type Subscriber func(els []Element, seq int64)
+
 func VQ(persist persister.Persister) (vq *VersionedQueue) {
 	vq = &VersionedQueue{}
 	vq.q = MakeQueue(persist)
@@ -39,6 +49,27 @@ func (ve VersionError) Error() string {
 	return fmt.Sprintf("Version mismatch, attempted %d for current version %d\n", ve.Attempted, ve.Current)
 }
 
+func (vq *VersionedQueue) Subscribe(sub Subscriber) {
+	vq.mu.Lock()
+	defer vq.mu.Unlock()
+	vq.subs = append(vq.subs, sub)
+}
+
+// Releases the lock, then notifies subscribers if the queue was modified.
+func (vq *VersionedQueue) unlock(modified *bool) {
+	if !*modified || len(vq.subs) == 0 {
+		vq.mu.Unlock()
+		return
+	}
+	els := vq.q.List()
+	seq := vq.seq
+	subs := vq.subs
+	vq.mu.Unlock()
+	for _, sub := range subs {
+		sub(els, seq)
+	}
+}
+
 func (vq *VersionedQueue) checkSeq(seq int64) (err error) {
 	if seq != vq.seq {
 		glog.Errorf("Sequence number mismatch %d for current gen %d", seq, vq.seq)
@@ -48,30 +79,35 @@ func (vq *VersionedQueue) checkSeq(seq int64) (err error) {
 }
 
 func (vq *VersionedQueue) Put(el Element) (pos int, seq int64, err error) {
+	modified := false
 	vq.mu.Lock()
-	defer vq.mu.Unlock()
+	defer vq.unlock(&modified)
 	pos, err = vq.q.Put(el)
 	if err == nil {
 		vq.seq += 1
+		modified = true
 	}
 	seq = vq.seq
 	return
 }
 
 func (vq *VersionedQueue) TakeFront() (el Element, seq int64, err error) {
+	modified := false
 	vq.mu.Lock()
-	defer vq.mu.Unlock()
+	defer vq.unlock(&modified)
 	el, err = vq.q.TakeFront()
 	if err == nil {
 		vq.seq += 1
+		modified = true
 	}
 	seq = vq.seq
 	return
 }
 
 func (vq *VersionedQueue) Take(i int, seq int64) (el Element, nseq int64, err error) {
+	modified := false
 	vq.mu.Lock()
-	defer vq.mu.Unlock()
+	defer vq.unlock(&modified)
 	err = vq.checkSeq(seq)
 	if err != nil {
 		nseq = vq.seq
@@ -80,6 +116,7 @@ func (vq *VersionedQueue) Take(i int, seq int64) (el Element, nseq int64, err er
 	el, err = vq.q.Take(i)
 	if err == nil {
 		vq.seq += 1
+		modified = true
 	}
 	nseq = vq.seq
 	return
@@ -98,8 +135,9 @@ func (vq *VersionedQueue) Get(i int, seq int64) (el Element, nseq int64, err err
 }
 
 func (vq *VersionedQueue) Remove(i int, seq int64) (nseq int64, err error) {
+	modified := false
 	vq.mu.Lock()
-	defer vq.mu.Unlock()
+	defer vq.unlock(&modified)
 	err = vq.checkSeq(seq)
 	if err != nil {
 		nseq = vq.seq
@@ -108,14 +146,16 @@ func (vq *VersionedQueue) Remove(i int, seq int64) (nseq int64, err error) {
 	err = vq.q.Remove(i)
 	if err == nil {
 		vq.seq += 1
+		modified = true
 	}
 	nseq = vq.seq
 	return
 }
 
 func (vq *VersionedQueue) Move(i int, npos int, seq int64) (nseq int64, err error) {
+	modified := false
 	vq.mu.Lock()
-	defer vq.mu.Unlock()
+	defer vq.unlock(&modified)
 	err = vq.checkSeq(seq)
 	if err != nil {
 		return
@@ -123,11 +163,29 @@ func (vq *VersionedQueue) Move(i int, npos int, seq int64) (nseq int64, err erro
 	err = vq.q.Move(i, npos)
 	if err == nil {
 		vq.seq += 1
+		modified = true
 	}
 	nseq = vq.seq
 	return
 }
 
+// Blind removal of the element with the given id, if present, returning it.
+func (vq *VersionedQueue) TakeId(id string) (el Element, pos int, seq int64, err error) {
+	modified := false
+	vq.mu.Lock()
+	defer vq.unlock(&modified)
+	pos, err = vq.q.Find(id)
+	if err == nil {
+		el, err = vq.q.Take(pos)
+	}
+	if err == nil {
+		vq.seq += 1
+		modified = true
+	}
+	seq = vq.seq
+	return
+}
+
 func (vq *VersionedQueue) Find(id string) (pos int, seq int64, err error) {
 	vq.mu.Lock()
 	defer vq.mu.Unlock()
@@ -153,6 +153,33 @@ func TestMove(t *testing.T) {
 	t.Run("MoveIncreases", testMoveIncreases)
 }
 
+func TestTakeId(t *testing.T) {
+	vq = VQ(nil)
+	populate(vq, 10)
+
+	oseq := vq.seq
+	el, pos, nseq, err := vq.TakeId("3")
+	if err != nil {
+		t.Fatalf("TakeId failed for existing element: %v", err)
+	}
+	if el.Id != "3" {
+		t.Fatalf("Incorrect element %v returned for removed element", el.Id)
+	}
+	if pos != 3 {
+		t.Fatalf("Incorrect position %d returned for removed element", pos)
+	}
+	if nseq <= oseq || nseq != vq.seq {
+		t.Fatal("Failed to increase sequence number on removal.")
+	}
+	_, _, nnseq, err := vq.TakeId("3")
+	if err == nil {
+		t.Fatal("TakeId succeeded for missing element.")
+	}
+	if nnseq != nseq {
+		t.Fatal("Failed removal modified the sequence number.")
+	}
+}
+
 func TestFind(t *testing.T) {
 	vq = VQ(nil)
 	populate(vq, 10)
@@ -191,3 +218,29 @@ func TestRecoverResets(t *testing.T) {
 		t.Fatal("Sequence number not resest upon recovery")
 	}
 }
+
+func TestSubscribe(t *testing.T) {
+	vq = VQ(nil)
+	var notified [][]Element
+	var seqs []int64
+	vq.Subscribe(func(els []Element, seq int64) {
+		notified = append(notified, els)
+		seqs = append(seqs, seq)
+	})
+
+	populate(vq, 3)
+	_, seq, _ = vq.Find("0")
+	vq.Remove(0, seq)
+	vq.Remove(0, seq) // stale
+	vq.Size()
+
+	if len(notified) != 4 {
+		t.Fatalf("Expected 4 notifications, got %d", len(notified))
+	}
+	if len(notified[3]) != 2 || notified[3][0].Id != "1" {
+		t.Fatalf("Incorrect snapshot after remove: %v", notified[3])
+	}
+	if seqs[3] != vq.seq {
+		t.Fatalf("Incorrect sequence number %d in notification, current %d", seqs[3], vq.seq)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"github.com/golang/glog"
+
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	RetryNumHeader    = "X-Slack-Retry-Num"
+	RetryReasonHeader = "X-Slack-Retry-Reason"
+)
+
+// Slack rejects requests with timestamps older than five minutes, so retries
+// can't arrive after this.
+const DefaultDedupeTTL = 5 * time.Minute
+
+// Short-lived cache of responses to Slack requests.
+//
+// Slack retries slash commands and interactions when a response is slow. A
+// retried delivery carries the same trigger ID (and body) as the original, so
+// duplicates are answered by replaying the original response instead of
+// handling the request again. Duplicates that arrive while the original is
+// still being handled wait for it to finish.
+//
+// Thread safe.
+type Dedupe struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*dedupeEntry
+}
+
+type dedupeEntry struct {
+	done    chan struct{}
+	created time.Time
+	status  int
+	header  http.Header
+	body    bytes.Buffer
+}
+
+func MakeDedupe(ttl time.Duration) *Dedupe {
+	return &Dedupe{ttl: ttl, entries: make(map[string]*dedupeEntry)}
+}
+
+// Key for a request: the trigger ID if there is one, otherwise a hash of the
+// request body.
+func RequestKey(triggerID string, body []byte) string {
+	if triggerID != "" {
+		return triggerID
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the retry attempt number of a request, or the empty string for the
+// first delivery.
+func RetryInfo(header http.Header) (num string, reason string) {
+	return header.Get(RetryNumHeader), header.Get(RetryReasonHeader)
+}
+
+// Calls handle with w unless a request with the same key was handled within
+// the TTL, in which case the original response is written to w. Returns
+// whether the request was a duplicate.
+func (d *Dedupe) Do(key string, w http.ResponseWriter, handle func(w http.ResponseWriter)) (dup bool) {
+	d.mu.Lock()
+	d.evict()
+	e, dup := d.entries[key]
+	if !dup {
+		e = &dedupeEntry{done: make(chan struct{}), created: time.Now(), status: http.StatusOK, header: make(http.Header)}
+		d.entries[key] = e
+	}
+	d.mu.Unlock()
+
+	if dup {
+		glog.Infof("Duplicate delivery of request %v, replaying response", key)
+		<-e.done
+		e.replay(w)
+		return
+	}
+
+	defer close(e.done)
+	handle(&recordingWriter{w: w, e: e})
+	return
+}
+
+// Must hold lock.
+func (d *Dedupe) evict() {
+	now := time.Now()
+	for k, e := range d.entries {
+		if now.Sub(e.created) > d.ttl {
+			delete(d.entries, k)
+		}
+	}
+}
+
+func (e *dedupeEntry) replay(w http.ResponseWriter) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body.Bytes())
+}
+
+// Writes through to the underlying writer while recording the response.
+type recordingWriter struct {
+	w           http.ResponseWriter
+	e           *dedupeEntry
+	wroteHeader bool
+}
+
+func (r *recordingWriter) Header() http.Header {
+	return r.w.Header()
+}
+
+func (r *recordingWriter) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.e.status = status
+	for k, v := range r.w.Header() {
+		r.e.header[k] = append([]string(nil), v...)
+	}
+	r.w.WriteHeader(status)
+}
+
+func (r *recordingWriter) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.e.body.Write(b)
+	return r.w.Write(b)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDedupeReplaysResponse(t *testing.T) {
+	d := MakeDedupe(time.Minute)
+	calls := 0
+	handle := func(w http.ResponseWriter) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("taken"))
+	}
+
+	first := httptest.NewRecorder()
+	if d.Do("trigger", first, handle) {
+		t.Fatal("First delivery reported as duplicate.")
+	}
+	second := httptest.NewRecorder()
+	if !d.Do("trigger", second, handle) {
+		t.Fatal("Retry not reported as duplicate.")
+	}
+
+	if calls != 1 {
+		t.Fatalf("Handler called %d times, expected 1", calls)
+	}
+	if second.Code != http.StatusAccepted || second.Body.String() != "taken" {
+		t.Fatalf("Incorrect replay: %d %q", second.Code, second.Body.String())
+	}
+	if second.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Headers not replayed: %v", second.Header())
+	}
+}
+
+func TestDedupeDistinctKeys(t *testing.T) {
+	d := MakeDedupe(time.Minute)
+	calls := 0
+	handle := func(w http.ResponseWriter) { calls++ }
+
+	d.Do("a", httptest.NewRecorder(), handle)
+	d.Do("b", httptest.NewRecorder(), handle)
+	if calls != 2 {
+		t.Fatalf("Handler called %d times, expected 2", calls)
+	}
+}
+
+func TestDedupeExpires(t *testing.T) {
+	d := MakeDedupe(time.Millisecond)
+	calls := 0
+	handle := func(w http.ResponseWriter) { calls++ }
+
+	d.Do("a", httptest.NewRecorder(), handle)
+	time.Sleep(5 * time.Millisecond)
+	if d.Do("a", httptest.NewRecorder(), handle) {
+		t.Fatal("Expired entry reported as duplicate.")
+	}
+	if calls != 2 {
+		t.Fatalf("Handler called %d times, expected 2", calls)
+	}
+}
+
+func TestDedupeWaitsForInFlight(t *testing.T) {
+	d := MakeDedupe(time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.Do("a", httptest.NewRecorder(), func(w http.ResponseWriter) {
+			close(started)
+			<-release
+			w.Write([]byte("done"))
+		})
+	}()
+	<-started
+
+	retry := httptest.NewRecorder()
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		close(release)
+	}()
+	d.Do("a", retry, func(w http.ResponseWriter) {
+		t.Error("Handler called for in-flight duplicate.")
+	})
+	wg.Wait()
+
+	if retry.Body.String() != "done" {
+		t.Fatalf("Incorrect replay: %q", retry.Body.String())
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	if RequestKey("123.456", []byte("x")) != "123.456" {
+		t.Fatal("Trigger ID not used as key.")
+	}
+	if RequestKey("", []byte("x")) != RequestKey("", []byte("x")) {
+		t.Fatal("Identical bodies produce different keys.")
+	}
+	if RequestKey("", []byte("x")) == RequestKey("", []byte("y")) {
+		t.Fatal("Different bodies produce the same key.")
+	}
+}
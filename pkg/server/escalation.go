@@ -0,0 +1,73 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"fmt"
+	"time"
+)
+
+// Default interval between checks of queues against their escalation
+// thresholds.
+const DefaultEscalationInterval = time.Minute
+
+// Periodically checks served queues against their escalation thresholds and
+// alerts their admins. Runs until stop is closed; shutdown waits for it to
+// return.
+func (sg *ServerGroup) WatchEscalations(interval time.Duration, stop <-chan struct{}) {
+	if !sg.background.add() {
+		return
+	}
+	defer sg.background.done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			sg.checkEscalations(now)
+		}
+	}
+}
+
+func (sg *ServerGroup) checkEscalations(now time.Time) {
+	sg.Lock()
+	servers := make([]*Server, 0, len(sg.servers))
+	for _, srv := range sg.servers {
+		servers = append(servers, srv)
+	}
+	sg.Unlock()
+
+	for _, srv := range servers {
+		for _, alert := range srv.service.Escalations(now) {
+			glog.Infof("Escalating for channel %v: %v", srv.channel, alert)
+			if err := srv.admin.SendAdminMessage(fmt.Sprintf("%s (<#%s>)", alert, srv.channel)); err != nil {
+				glog.Errorf("Error sending escalation for channel %v: %v", srv.channel, err)
+			}
+		}
+	}
+}
+
+// Sets (or, given "off", clears) the escalation thresholds of a queue.
+func (sg *ServerGroup) setEscalation(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, def string) {
+	e, err := service.ParseEscalation(def)
+	if err != nil {
+		glog.Errorf("Error parsing escalation for channel %v: %v", cmd.ChannelID, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgEscalateUsage, service.Args{"Error": err, "Command": sg.command}))
+		return
+	}
+	srv.service.SetEscalation(e)
+	sg.Lock()
+	sg.Persist()
+	sg.Unlock()
+
+	msg := srv.service.Msg(locale, service.MsgEscalateOff, nil)
+	if e != nil {
+		msg = srv.service.Msg(locale, service.MsgEscalateSet, service.Args{"Thresholds": e})
+	}
+	reply(api, cmd, msg)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingAdmins struct {
+	fixedAdmins
+	messages []string
+}
+
+func (a *recordingAdmins) SendAdminMessage(str string) error {
+	a.messages = append(a.messages, str)
+	return nil
+}
+
+func TestCheckEscalations(t *testing.T) {
+	sg := testGroup("C1", "C2")
+	admins := &recordingAdmins{}
+	srv, _ := sg.Lookup("T1", "C1")
+	srv.channel = "C1"
+	srv.admin = admins
+	e, _ := service.ParseEscalation("size=1")
+	srv.service.SetEscalation(e)
+	for _, id := range []string{"U1", "U2"} {
+		srv.service.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: id}}, &service.EnqueueResponse{})
+	}
+
+	sg.checkEscalations(time.Now())
+	sg.checkEscalations(time.Now())
+	if len(admins.messages) != 1 || !strings.Contains(admins.messages[0], "<#C1>") {
+		t.Fatalf("Expected a single escalation for C1, got %v", admins.messages)
+	}
+}
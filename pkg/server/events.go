@@ -0,0 +1,222 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Inner event types handled in addition to those defined in slackevents.
+const (
+	ChannelArchive   = "channel_archive"
+	ChannelUnarchive = "channel_unarchive"
+	ChannelRename    = "channel_rename"
+	GroupArchive     = "group_archive"
+	GroupUnarchive   = "group_unarchive"
+	GroupRename      = "group_rename"
+
+	SubteamMembersChanged = "subteam_members_changed"
+)
+
+// An Events API request. Inner is decoded according to its type when the
+// event is handled.
+type Event struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	Inner     json.RawMessage `json:"event"`
+}
+
+type innerEventType struct {
+	Type string `json:"type"`
+}
+
+type subteamMembersChangedEvent struct {
+	SubteamID string `json:"subteam_id"`
+}
+
+func ParseEvent(body []byte) (ev *Event, err error) {
+	ev = &Event{}
+	err = json.Unmarshal(body, ev)
+	return
+}
+
+// Applies a workspace lifecycle event to the served queues.
+func (sg *ServerGroup) HandleEvent(ctx context.Context, ev *Event) {
+	log := logging.FromContext(ctx)
+	if ev.Type != slackevents.CallbackEvent {
+		log.Debugf("Ignoring event of type %v", ev.Type)
+		return
+	}
+	var it innerEventType
+	if err := json.Unmarshal(ev.Inner, &it); err != nil {
+		log.Errorf("Error unmarshalling event: %v", err)
+		return
+	}
+	log = log.With(logging.Action, it.Type)
+	ctx = logging.NewContext(ctx, log)
+	log.Debugf("Received event")
+
+	var err error
+	switch it.Type {
+	case slackevents.MemberJoinedChannel:
+		e := slackevents.MemberJoinedChannelEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil {
+			sg.membership(ev.TeamID).Invalidate(service.ChannelMembersKey(e.Channel))
+		}
+	case slackevents.MemberLeftChannel:
+		e := slackevents.MemberLeftChannelEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil {
+			sg.membership(ev.TeamID).Invalidate(service.ChannelMembersKey(e.Channel))
+			sg.memberLeft(ctx, ev.TeamID, e.Channel, e.User)
+		}
+	case SubteamMembersChanged:
+		e := subteamMembersChangedEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil {
+			sg.membership(ev.TeamID).Invalidate(service.UsergroupMembersKey(e.SubteamID))
+		}
+	case ChannelArchive, GroupArchive:
+		e := slack.ChannelInfoEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil {
+			sg.Archive(ev.TeamID, e.Channel)
+		}
+	case ChannelUnarchive, GroupUnarchive:
+		e := slack.ChannelInfoEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil {
+			sg.Unarchive(ev.TeamID, e.Channel)
+		}
+	case ChannelRename, GroupRename:
+		e := slack.ChannelRenameEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil {
+			sg.rename(ev.TeamID, e.Channel.ID, e.Channel.Name)
+		}
+	case slackevents.AppHomeOpened:
+		e := slackevents.AppHomeOpenedEvent{}
+		if err = json.Unmarshal(ev.Inner, &e); err == nil && e.Tab == "home" {
+			sg.homeOpened(ev.TeamID, e.User)
+		}
+	case slackevents.AppUninstalled, slackevents.TokensRevoked:
+		log.Infof("App uninstalled or tokens revoked")
+		sg.teams.Remove(ev.TeamID)
+		sg.dropTeam(ev.TeamID)
+	default:
+		log.Debugf("Ignoring event")
+	}
+	if err != nil {
+		log.Errorf("Error unmarshalling event: %v", err)
+	}
+}
+
+func (sg *ServerGroup) rename(team string, channelID string, name string) {
+	sg.Lock()
+	defer sg.Unlock()
+	renamed := false
+	for key, srv := range sg.servers {
+		if key.team != team && key.team != "" {
+			continue
+		}
+		if c, ok := srv.admin.(service.RenamableAdminChannel); ok && c.Rename(channelID, name) {
+			srv.adminChan = name
+			renamed = true
+		}
+	}
+	if c, ok := sg.teamAdmins[team].(service.RenamableAdminChannel); ok {
+		c.Rename(channelID, name)
+	}
+	if renamed {
+		sg.Persist()
+	}
+}
+
+// Removes a user who left a queue's channel from the queue.
+func (sg *ServerGroup) memberLeft(ctx context.Context, team string, channelID string, userID string) {
+	srv, ok := sg.Lookup(team, channelID)
+	if !ok {
+		return
+	}
+	log := logging.FromContext(ctx).With(logging.Channel, channelID, logging.User, userID)
+	req := &service.RemoveUserRequest{Id: userID}
+	resp := &service.RemoveUserResponse{}
+	srv.service.RemoveUser(logging.NewContext(ctx, log), req, resp)
+	if !resp.Ok {
+		return
+	}
+	log.Infof("Removed from queue after leaving the channel")
+	err := srv.admin.SendAdminMessage(srv.service.Msg(srv.service.Locale(), service.MsgLeftChannel,
+		service.Args{"User": fmt.Sprintf("<@%s>", userID), "Pos": resp.Pos + 1}))
+	if err != nil {
+		log.Errorf("Error sending admin message for removal: %v", err)
+	}
+}
+
+// Finds the key of a team's channel in servers.
+func findKey(servers map[serverKey]*Server, team string, channelID string) (key serverKey, ok bool) {
+	key = serverKey{team, channelID}
+	if _, ok = servers[key]; ok {
+		return
+	}
+	key = serverKey{"", channelID}
+	_, ok = servers[key]
+	return
+}
+
+// Stops serving, and forgets, the queues of a team that uninstalled the app.
+// Queues without a team are kept, as they may belong to the fallback client.
+func (sg *ServerGroup) dropTeam(team string) {
+	if team == "" {
+		return
+	}
+	sg.Lock()
+	defer sg.Unlock()
+	for _, servers := range []map[serverKey]*Server{sg.servers, sg.archived} {
+		for key, srv := range servers {
+			if key.team == team {
+				glog.Infof("Dropping queue for channel %v of uninstalled team %v", key.channel, team)
+				srv.service.ExpireWaiting()
+				delete(servers, key)
+			}
+		}
+	}
+	delete(sg.teamAdmins, team)
+	sg.usersMu.Lock()
+	delete(sg.users, team)
+	delete(sg.members, team)
+	sg.usersMu.Unlock()
+	sg.Persist()
+}
+
+// Stops serving the queue for an archived channel. Its state is kept, and the
+// queue is served again if the channel is unarchived.
+func (sg *ServerGroup) Archive(team string, channelID string) {
+	sg.Lock()
+	defer sg.Unlock()
+	key, ok := findKey(sg.servers, team, channelID)
+	if !ok {
+		return
+	}
+	glog.Infof("Archiving queue for channel %v", channelID)
+	sg.archived[key] = sg.servers[key]
+	delete(sg.servers, key)
+	sg.Persist()
+}
+
+func (sg *ServerGroup) Unarchive(team string, channelID string) {
+	sg.Lock()
+	defer sg.Unlock()
+	key, ok := findKey(sg.archived, team, channelID)
+	if !ok {
+		return
+	}
+	glog.Infof("Unarchiving queue for channel %v", channelID)
+	sg.servers[key] = sg.archived[key]
+	delete(sg.archived, key)
+	sg.Persist()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"fmt"
+	"testing"
+)
+
+type staticUserLookup struct{}
+
+func (ul staticUserLookup) Lookup(id string) (user *slack.User, err error) {
+	return &slack.User{ID: id}, nil
+}
+
+func testGroup(channels ...string) *ServerGroup {
+	sg := CreateServerGroup(MakeTeamStore(nil, "", nil, nil), service.MakeProfileStore(nil), nil, "", "/queue", service.CommandNames{}, nil)
+	for _, c := range channels {
+		sg.servers[serverKey{"T1", c}] = &Server{
+			service: service.TS(staticUserLookup{}, nil),
+			admin:   service.NoopAdminInterface{}}
+	}
+	return sg
+}
+
+func callback(inner string) *Event {
+	ev, _ := ParseEvent([]byte(fmt.Sprintf(`{"type":"event_callback","team_id":"T1","event_id":"Ev1","event":%s}`, inner)))
+	return ev
+}
+
+func TestArchiveAndUnarchive(t *testing.T) {
+	sg := testGroup("C1")
+
+	sg.HandleEvent(context.Background(), callback(`{"type":"channel_archive","channel":"C1","user":"U1"}`))
+	if _, ok := sg.Lookup("T1", "C1"); ok {
+		t.Fatal("Archived queue still served.")
+	}
+	if _, ok := sg.archived[serverKey{"T1", "C1"}]; !ok {
+		t.Fatal("Archived queue not retained.")
+	}
+
+	sg.HandleEvent(context.Background(), callback(`{"type":"channel_unarchive","channel":"C1","user":"U1"}`))
+	if _, ok := sg.Lookup("T1", "C1"); !ok {
+		t.Fatal("Unarchived queue not served.")
+	}
+}
+
+func TestMemberLeftRemovesFromQueue(t *testing.T) {
+	sg := testGroup("C1")
+	srv, _ := sg.Lookup("T1", "C1")
+	for _, id := range []string{"U1", "U2"} {
+		srv.service.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: id}}, &service.EnqueueResponse{})
+	}
+
+	sg.HandleEvent(context.Background(), callback(`{"type":"member_left_channel","user":"U1","channel":"C1"}`))
+
+	resp := &service.ListResponse{}
+	srv.service.List(&service.ListRequest{}, resp)
+	if len(resp.Users) != 1 || resp.Users[0].ID != "U2" {
+		t.Fatalf("Expected only U2 in queue, got %v", resp.Users)
+	}
+}
+
+func TestMemberLeftOtherChannel(t *testing.T) {
+	sg := testGroup("C1")
+	srv, _ := sg.Lookup("T1", "C1")
+	srv.service.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: "U1"}}, &service.EnqueueResponse{})
+
+	sg.HandleEvent(context.Background(), callback(`{"type":"member_left_channel","user":"U1","channel":"C2"}`))
+
+	resp := &service.ListResponse{}
+	srv.service.List(&service.ListRequest{}, resp)
+	if len(resp.Users) != 1 {
+		t.Fatalf("User removed after leaving a different channel: %v", resp.Users)
+	}
+}
+
+func TestSubteamMembersChangedInvalidates(t *testing.T) {
+	sg := testGroup()
+	key := service.UsergroupMembersKey("S1")
+	sg.membership("T1").Members(key, func() ([]string, error) { return []string{"A1"}, nil })
+
+	sg.HandleEvent(context.Background(), callback(`{"type":"subteam_members_changed","subteam_id":"S1","team_id":"T1"}`))
+
+	if s := sg.membership("T1").Stats(); s.Invalidations != 1 {
+		t.Fatalf("User group membership not invalidated: %v", s)
+	}
+}
+
+func TestAppUninstalledDropsTeamQueues(t *testing.T) {
+	sg := testGroup("C1", "C2")
+	sg.servers[serverKey{"T2", "C3"}] = &Server{service: service.TS(staticUserLookup{}, nil), admin: service.NoopAdminInterface{}}
+	sg.Archive("T1", "C2")
+
+	sg.HandleEvent(context.Background(), callback(`{"type":"app_uninstalled"}`))
+
+	if _, ok := sg.Lookup("T1", "C1"); ok {
+		t.Fatal("Queue of uninstalled team still served.")
+	}
+	if _, ok := sg.archived[serverKey{"T1", "C2"}]; ok {
+		t.Fatal("Archived queue of uninstalled team retained.")
+	}
+	if _, ok := sg.Lookup("T2", "C3"); !ok {
+		t.Fatal("Queue of another team dropped.")
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/queue"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Uploads the queue's history, and the users waiting in it, to its admin
+// channel: "export [csv|json] [all | today | week | yyyy-mm-dd..yyyy-mm-dd]".
+// Without an admin channel, the file is sent to the user.
+func (sg *ServerGroup) export(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, args string) {
+	now := sg.userNow(cmd.TeamID, api, cmd.UserID)
+	format, q, p, err := exportQuery(srv, args, now)
+	if err == nil && sg.history == nil {
+		err = fmt.Errorf("history is not recorded")
+	}
+	var entries []service.HistoryEntry
+	if err == nil {
+		entries, err = sg.history.Query(q)
+	}
+	if err != nil {
+		glog.Errorf("Error exporting channel %v: %q: %v", cmd.ChannelID, args, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgExportUsage, service.Args{"Command": sg.command}))
+		return
+	}
+	desc := p.describe(srv.service, locale)
+	records := service.HistoryRecords(entries)
+	if q.Until.IsZero() || q.Until.After(now) {
+		els, _ := srv.service.Snapshot()
+		records = append(records, service.WaitingRecords(srv.team, srv.channel, els, now)...)
+	}
+	var b bytes.Buffer
+	if err = service.WriteExport(&b, format, records); err != nil {
+		glog.Errorf("Error writing export of %v: %v", srv.channel, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgExportFailed, nil))
+		return
+	}
+
+	channel, err := srv.adminChannelID()
+	if err != nil {
+		glog.Infof("No admin channel for export of %v, sending to %v: %v", srv.channel, cmd.UserID, err)
+		var c *slack.Channel
+		c, _, _, err = api.OpenConversation(&slack.OpenConversationParameters{Users: []string{cmd.UserID}})
+		if err == nil {
+			channel = c.ID
+		}
+	}
+	if err == nil {
+		_, err = api.UploadFile(slack.FileUploadParameters{
+			Reader:   &b,
+			Filename: exportFilename(srv.channel, format, now),
+			Filetype: exportFiletype(format),
+			Title:    srv.service.Msg(locale, service.MsgExportTitle, service.Args{"Period": desc}),
+			Channels: []string{channel}})
+	}
+	if err != nil {
+		glog.Errorf("Error uploading export of %v: %v", srv.channel, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgExportUploadFailed, nil))
+		return
+	}
+	glog.Infof("Exported %d records of %v to %v", len(records), srv.channel, channel)
+	if channel != cmd.ChannelID {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgExported, service.Args{"Records": len(records), "Period": desc, "Channel": channel}))
+	}
+}
+
+// Parses the arguments of an export command into a format, and a query of
+// the server's queue and its period.
+func exportQuery(srv *Server, args string, now time.Time) (format service.ExportFormat, q service.HistoryQuery, p period, err error) {
+	fields := strings.Fields(args)
+	format = service.ExportCSV
+	if len(fields) > 0 {
+		if f, ok := service.ParseExportFormat(fields[0]); ok {
+			format = f
+			fields = fields[1:]
+		}
+	}
+	q = service.HistoryQuery{TeamID: srv.team, ChannelID: srv.channel}
+	switch {
+	case len(fields) > 1:
+		err = fmt.Errorf("too many arguments: %q", args)
+	case len(fields) == 0 || fields[0] == "all":
+		p = period{service.MsgPeriodAll, nil}
+	default:
+		q.Since, q.Until, p, err = parsePeriod(fields[0], now)
+		if fields[0] == "today" || fields[0] == "week" {
+			// Through now, including those waiting.
+			q.Until = time.Time{}
+		}
+	}
+	return
+}
+
+func exportFilename(channel string, format service.ExportFormat, now time.Time) string {
+	ext := "csv"
+	if format == service.ExportJSON {
+		ext = "jsonl"
+	}
+	return fmt.Sprintf("queue-%s-%s.%s", channel, now.Format("20060102-1504"), ext)
+}
+
+func exportFiletype(format service.ExportFormat) string {
+	if format == service.ExportJSON {
+		return "text"
+	}
+	return "csv"
+}
+
+// A queue as persisted by a ServerGroup.
+type PersistedQueue struct {
+	TeamID    string
+	ChannelID string
+	Archived  bool
+	Elements  []queue.Element
+}
+
+// Reads the queues of a ServerGroup persisted with persist, without serving
+// them, e.g., to export them.
+func ReadQueues(persist persister.Persister) (queues []PersistedQueue) {
+	sgstate := ServerGroupState{}
+	persist.Read(&sgstate)
+	for _, state := range sgstate.States {
+		q := queue.MakeQueue(queuePersister(persist, state.TeamID, queueName(state)))
+		q.Recover()
+		queues = append(queues, PersistedQueue{
+			TeamID:    state.TeamID,
+			ChannelID: state.ChannelID,
+			Archived:  state.Archived,
+			Elements:  q.List()})
+	}
+	return
+}
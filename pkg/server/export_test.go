@@ -0,0 +1,31 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"testing"
+	"time"
+)
+
+func TestExportQuery(t *testing.T) {
+	srv := &Server{team: "T1", channel: "C1"}
+	now := time.Date(2021, 3, 4, 15, 30, 0, 0, time.UTC)
+
+	format, q, _, err := exportQuery(srv, "", now)
+	if err != nil || format != service.ExportCSV || q.ChannelID != "C1" || !q.Since.IsZero() || !q.Until.IsZero() {
+		t.Fatalf("Unexpected default export: %v %+v (%v)", format, q, err)
+	}
+	format, q, _, err = exportQuery(srv, "json week", now)
+	if err != nil || format != service.ExportJSON || q.Since.IsZero() || !q.Until.IsZero() {
+		t.Fatalf("Unexpected export of the week: %v %+v (%v)", format, q, err)
+	}
+	_, q, _, err = exportQuery(srv, "2021-03-01..2021-03-02", now)
+	if err != nil || !q.Until.Equal(time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected export of range: %+v (%v)", q, err)
+	}
+	for _, args := range []string{"xml", "csv today week"} {
+		if _, _, _, err := exportQuery(srv, args, now); err == nil {
+			t.Fatalf("Expected error for %q", args)
+		}
+	}
+}
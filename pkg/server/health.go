@@ -0,0 +1,137 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/golang/glog"
+
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How long a health check waits for the group's lock before reporting the
+// process as wedged.
+const DefaultHealthTimeout = 5 * time.Second
+
+var errProbeInFlight = errors.New("previous health check still waiting for the server group's lock")
+
+// Whether the group's lock can be taken within timeout, i.e., nothing holding
+// it is stuck. At most one check waits for the lock at a time.
+func (sg *ServerGroup) Healthy(timeout time.Duration) error {
+	if !atomic.CompareAndSwapInt32(&sg.probing, 0, 1) {
+		return errProbeInFlight
+	}
+	done := make(chan struct{})
+	go func() {
+		sg.Lock()
+		sg.Unlock()
+		atomic.StoreInt32(&sg.probing, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for the server group's lock")
+	}
+}
+
+// Whether the group has recovered its queues and can persist changes.
+func (sg *ServerGroup) Ready() error {
+	if atomic.LoadInt32(&sg.recovered) == 0 {
+		return errors.New("recovering")
+	}
+	return persister.CheckWritable(sg.persist)
+}
+
+// Serves liveness checks.
+func (sg *ServerGroup) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	check(w, sg.Healthy(DefaultHealthTimeout))
+}
+
+// Serves readiness checks.
+func (sg *ServerGroup) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	check(w, sg.Ready())
+}
+
+func check(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain")
+	if err != nil {
+		glog.Errorf("Failed check: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// State of a queue, for debugging.
+type QueueDump struct {
+	TeamID    string          `json:"team_id,omitempty"`
+	ChannelID string          `json:"channel_id"`
+	AdminChan string          `json:"admin_channel"`
+	Archived  bool            `json:"archived,omitempty"`
+	Seq       int64           `json:"seq"`
+	Elements  []queue.Element `json:"elements"`
+}
+
+// Returns the state of every queue, served or archived.
+func (sg *ServerGroup) Dump() (dumps []QueueDump) {
+	sg.Lock()
+	servers := make(map[serverKey]*Server)
+	archived := make(map[serverKey]bool)
+	for key, srv := range sg.servers {
+		servers[key] = srv
+	}
+	for key, srv := range sg.archived {
+		servers[key] = srv
+		archived[key] = true
+	}
+	sg.Unlock()
+
+	dumps = []QueueDump{}
+	for key, srv := range servers {
+		els, seq := srv.service.Snapshot()
+		dumps = append(dumps, QueueDump{
+			TeamID:    key.team,
+			ChannelID: key.channel,
+			AdminChan: srv.adminChan,
+			Archived:  archived[key],
+			Seq:       seq,
+			Elements:  els})
+	}
+	sort.Slice(dumps, func(i, j int) bool {
+		if dumps[i].TeamID != dumps[j].TeamID {
+			return dumps[i].TeamID < dumps[j].TeamID
+		}
+		return dumps[i].ChannelID < dumps[j].ChannelID
+	})
+	return
+}
+
+// Serves the state of every queue as JSON to requests bearing token. An
+// empty token serves no one.
+func (sg *ServerGroup) DebugHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			glog.Errorf("Unauthorized request for %v from %v", r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sg.Dump()); err != nil {
+			glog.Errorf("Error writing queue dump: %v", err)
+		}
+	})
+}
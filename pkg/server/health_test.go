@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	sg := testGroup()
+	if sg.Ready() == nil {
+		t.Fatalf("Expected not ready before recovery")
+	}
+	sg.Recover()
+	if err := sg.Ready(); err != nil {
+		t.Fatalf("Expected ready after recovery: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sg.persist = persister.FilePersister{Fn: filepath.Join(dir, "missing", "state")}
+	if sg.Ready() == nil {
+		t.Fatalf("Expected not ready with an unwritable persister")
+	}
+	sg.persist = persister.FilePersister{Fn: filepath.Join(dir, "state")}
+	if err := sg.Ready(); err != nil {
+		t.Fatalf("Expected ready with a writable persister: %v", err)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	sg := testGroup()
+	if err := sg.Healthy(time.Second); err != nil {
+		t.Fatalf("Expected healthy: %v", err)
+	}
+	sg.Lock()
+	if sg.Healthy(10*time.Millisecond) == nil {
+		t.Fatalf("Expected unhealthy while the lock is held")
+	}
+	if sg.Healthy(10*time.Millisecond) != errProbeInFlight {
+		t.Fatalf("Expected a single probe waiting for the lock")
+	}
+	sg.Unlock()
+
+	w := httptest.NewRecorder()
+	deadline := time.Now().Add(time.Second)
+	for sg.HandleHealthz(w, httptest.NewRequest("GET", "/healthz", nil)); w.Code != http.StatusOK; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected healthy after the lock is released, got %v", w.Code)
+		}
+		w = httptest.NewRecorder()
+		sg.HandleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	sg := testGroup("C1")
+	srv := sg.servers[serverKey{"T1", "C1"}]
+	srv.channel = "C1"
+	srv.adminChan = "G1"
+	srv.service.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: "U1"}}, &service.EnqueueResponse{})
+
+	for _, tc := range []struct {
+		handler string
+		auth    string
+		code    int
+	}{
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/debug/queues", nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		sg.DebugHandler(tc.handler).ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Fatalf("Expected %v with token %q and %q, got %v", tc.code, tc.handler, tc.auth, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var dumps []QueueDump
+		if err := json.Unmarshal(w.Body.Bytes(), &dumps); err != nil {
+			t.Fatalf("Invalid dump %q: %v", w.Body.String(), err)
+		}
+		if len(dumps) != 1 || dumps[0].ChannelID != "C1" || dumps[0].AdminChan != "G1" ||
+			dumps[0].Seq != 1 || len(dumps[0].Elements) != 1 || dumps[0].Elements[0].Id != "U1" {
+			t.Fatalf("Unexpected dump %+v", dumps)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Most history entries shown in a reply; the most recent are shown.
+const maxHistoryShown = 20
+
+// Shows the queue's history for a user, "history <@U123>", or for the day,
+// "history today".
+func (sg *ServerGroup) showHistory(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, arg string) {
+	q, err := historyQuery(srv, arg, sg.userNow(cmd.TeamID, api, cmd.UserID))
+	if err == nil && sg.history == nil {
+		err = fmt.Errorf("history is not recorded")
+	}
+	var entries []service.HistoryEntry
+	if err == nil {
+		entries, err = sg.history.Query(q)
+	}
+	if err != nil {
+		glog.Errorf("Error querying history for channel %v: %q: %v", cmd.ChannelID, arg, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgHistoryUsage, service.Args{"Command": sg.command}))
+		return
+	}
+	reply(api, cmd, formatHistory(srv.service, locale, entries, q))
+}
+
+// Parses the argument of a history command into a query of the server's
+// queue.
+func historyQuery(srv *Server, arg string, now time.Time) (q service.HistoryQuery, err error) {
+	q = service.HistoryQuery{TeamID: srv.team, ChannelID: srv.channel}
+	arg = strings.TrimSpace(arg)
+	if arg == "" || arg == "today" {
+		y, m, d := now.Date()
+		q.Since = time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+		return q, nil
+	}
+	members, err := service.ParseMembers(arg)
+	if err != nil {
+		return
+	}
+	if members == nil || len(members.Users) != 1 || len(members.Channels)+len(members.Usergroups) > 0 {
+		err = fmt.Errorf("expected one user, got %q", arg)
+		return
+	}
+	q.UserID = members.Users[0]
+	return q, nil
+}
+
+// Renders the entries matching q, the most recent last.
+func formatHistory(s *service.QueueService, locale string, entries []service.HistoryEntry, q service.HistoryQuery) string {
+	if len(entries) == 0 {
+		return s.Msg(locale, service.MsgHistoryEmpty, service.Args{"User": q.UserID})
+	}
+	args := service.Args{"User": q.UserID}
+	if len(entries) > maxHistoryShown {
+		args["Shown"], args["Total"] = maxHistoryShown, len(entries)
+		entries = entries[len(entries)-maxHistoryShown:]
+	}
+	var b strings.Builder
+	b.WriteString(s.Msg(locale, service.MsgHistoryHeader, args))
+	for _, e := range entries {
+		b.WriteString("\n")
+		b.WriteString(s.Msg(locale, service.MsgHistoryEntry, service.Args{
+			"Time":    slackTime(e.Dequeued),
+			"User":    e.UserID,
+			"Outcome": s.Msg(locale, "outcome_"+string(e.Outcome), nil),
+			"Admin":   e.AdminID,
+			"Wait":    e.Wait().Round(time.Minute).String(),
+			"Topic":   e.Description()}))
+	}
+	return b.String()
+}
+
+// Formats a time for Slack to show in each reader's time zone.
+func slackTime(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short} {time}|%s>", t.Unix(), t.Format("Jan 2 15:04"))
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	srv := &Server{team: "T1", channel: "C1"}
+	now := time.Date(2021, 3, 4, 15, 30, 0, 0, time.UTC)
+
+	q, err := historyQuery(srv, "today", now)
+	if err != nil || q.ChannelID != "C1" || q.TeamID != "T1" || !q.Since.Equal(time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected query for today: %+v (%v)", q, err)
+	}
+	q, err = historyQuery(srv, "<@U123|ada>", now)
+	if err != nil || q.UserID != "U123" || !q.Since.IsZero() {
+		t.Fatalf("Unexpected query for user: %+v (%v)", q, err)
+	}
+	for _, arg := range []string{"yesterday", "<#C1|general>", "<@U1> <@U2>"} {
+		if _, err := historyQuery(srv, arg, now); err == nil {
+			t.Fatalf("Expected error for %q", arg)
+		}
+	}
+}
+
+func TestFormatHistory(t *testing.T) {
+	qs := service.TS(staticUserLookup{}, nil)
+	q := service.HistoryQuery{UserID: "U123"}
+	if got := formatHistory(qs, service.English, nil, q); got != "No visits to this queue for <@U123>." {
+		t.Fatalf("Unexpected empty history %q", got)
+	}
+	start := time.Date(2021, 3, 4, 15, 0, 0, 0, time.UTC)
+	entries := []service.HistoryEntry{{UserID: "U123", AdminID: "U9", Enqueued: start, Dequeued: start.Add(10 * time.Minute), Outcome: service.OutcomeServed, Topic: "hw1"}}
+	got := formatHistory(qs, service.Spanish, entries, q)
+	if !strings.HasPrefix(got, "Visitas a esta cola de <@U123>:\n•") || !strings.HasSuffix(got, "<@U123> atendido por <@U9> tras 10m0s: hw1") {
+		t.Fatalf("Unexpected history %q", got)
+	}
+}
+
+type tzUserLookup string
+
+func (ul tzUserLookup) Lookup(id string) (user *slack.User, err error) {
+	return &slack.User{ID: id, TZ: string(ul)}, nil
+}
+
+func TestUserNow(t *testing.T) {
+	sg := testGroup()
+	sg.users["T1"] = tzUserLookup("America/New_York")
+	if now := sg.userNow("T1", nil, "U1"); now.Location().String() != "America/New_York" {
+		t.Fatalf("Expected the user's time zone, got %v", now.Location())
+	}
+	sg.users["T1"] = tzUserLookup("Nowhere/Special")
+	if now := sg.userNow("T1", nil, "U1"); now.Location() != time.UTC {
+		t.Fatalf("Expected UTC for an unknown time zone, got %v", now.Location())
+	}
+}
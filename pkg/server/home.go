@@ -0,0 +1,219 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Views of users who haven't opened the App Home recently aren't refreshed.
+	homeViewerTTL = 12 * time.Hour
+	// Changes to a team's queues within this long are published together.
+	homeRefreshDelay = 2 * time.Second
+	// Slack's limit on blocks in a Home tab.
+	maxHomeBlocks = 100
+)
+
+type homeViewer struct {
+	team string
+	user string
+}
+
+// The App Home published to a viewer. Publishes are serialized so that an
+// older view never replaces a newer one.
+type homeView struct {
+	mu     sync.Mutex
+	opened time.Time
+	seq    int64 // version of the team's queues last published
+}
+
+// Users who have recently opened the App Home, whose views are refreshed when
+// queues change.
+type homeViewers struct {
+	mu        sync.Mutex
+	viewers   map[homeViewer]*homeView
+	seqs      map[string]int64 // per-team version, bumped when any of its queues change
+	scheduled map[string]bool  // teams with a refresh pending
+}
+
+func (hv *homeViewers) opened(team string, user string) *homeView {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if hv.viewers == nil {
+		hv.viewers = make(map[homeViewer]*homeView)
+	}
+	v, ok := hv.viewers[homeViewer{team, user}]
+	if !ok {
+		v = &homeView{}
+		hv.viewers[homeViewer{team, user}] = v
+	}
+	v.opened = time.Now()
+	return v
+}
+
+// Recent viewers in team, or in any team if team is empty.
+func (hv *homeViewers) recent(team string) (viewers map[homeViewer]*homeView) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	viewers = make(map[homeViewer]*homeView)
+	now := time.Now()
+	for k, v := range hv.viewers {
+		if now.Sub(v.opened) > homeViewerTTL {
+			delete(hv.viewers, k)
+			continue
+		}
+		if team == "" || k.team == team {
+			viewers[k] = v
+		}
+	}
+	return
+}
+
+// Records a change to the queues of team. Returns whether a refresh needs to
+// be scheduled, i.e., none is pending.
+func (hv *homeViewers) changed(team string) bool {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if hv.seqs == nil {
+		hv.seqs = make(map[string]int64)
+		hv.scheduled = make(map[string]bool)
+	}
+	hv.seqs[team]++
+	if hv.scheduled[team] {
+		return false
+	}
+	hv.scheduled[team] = true
+	return true
+}
+
+// Current version of team's queues.
+func (hv *homeViewers) seq(team string) int64 {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	return hv.seqs[team]
+}
+
+// Returns the current version of team's queues, starting the refresh that was
+// pending.
+func (hv *homeViewers) start(team string) int64 {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	delete(hv.scheduled, team)
+	return hv.seqs[team]
+}
+
+func (sg *ServerGroup) homeOpened(team string, user string) {
+	v := sg.homes.opened(team, user)
+	sg.publishHome(team, user, v, sg.homes.seq(team), true)
+}
+
+// Schedules a refresh of the App Home of recent viewers after a queue in team
+// changes. Changes made before the refresh starts are published with it.
+func (sg *ServerGroup) queuesChanged(team string) {
+	if sg.homes.changed(team) && sg.background.add() {
+		time.AfterFunc(homeRefreshDelay, func() {
+			defer sg.background.done()
+			sg.refreshHomes(team)
+		})
+	}
+}
+
+// Republishes the App Home of recent viewers in team.
+func (sg *ServerGroup) refreshHomes(team string) {
+	seq := sg.homes.start(team)
+	for k, v := range sg.homes.recent(team) {
+		sg.publishHome(k.team, k.user, v, seq, false)
+	}
+}
+
+type channelServer struct {
+	channel string
+	srv     *Server
+}
+
+// Servers of a team, ordered by channel.
+func (sg *ServerGroup) teamServers(team string) (servers []channelServer) {
+	sg.Lock()
+	defer sg.Unlock()
+	for key, srv := range sg.servers {
+		if key.team == team || key.team == "" {
+			servers = append(servers, channelServer{key.channel, srv})
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].channel < servers[j].channel })
+	return
+}
+
+// Renders the App Home of user, who should have been looked up with
+// users.info for their locale.
+func homeBlocks(team string, user *slack.User, servers []channelServer) (blocks []slack.Block) {
+	locale := service.MatchLocale(user.Locale)
+	header := slack.NewTextBlockObject("plain_text", service.Message(locale, service.MsgHomeHeader, nil), false, false)
+	blocks = append(blocks, slack.NewHeaderBlock(header))
+
+	userID := user.ID
+	user = &slack.User{ID: userID, TeamID: team, Locale: user.Locale}
+	for _, cs := range servers {
+		err := cs.srv.auth.Check(cs.srv.service, service.OpList, user)
+		if err != nil && service.AsError(err).Kind != service.ErrPermissionDenied {
+			glog.Errorf("Error checking whether %v may list for home: %v", userID, err)
+		}
+		if err == nil {
+			resp := &service.ListResponse{}
+			if err = cs.srv.service.List(&service.ListRequest{}, resp); err != nil {
+				glog.Errorf("Error listing queue for channel %v: %v", cs.channel, err)
+				continue
+			}
+			blocks = append(blocks, service.QueueBlocks(cs.srv.service, cs.srv.service.UserLocale(user), cs.channel, resp)...)
+			continue
+		}
+		resp := &service.PositionResponse{}
+		cs.srv.service.Position(&service.PositionRequest{Id: userID}, resp)
+		if resp.Ok {
+			blocks = append(blocks, service.StudentHomeBlocks(cs.srv.service, cs.srv.service.UserLocale(user), cs.channel, resp)...)
+		}
+	}
+
+	if len(blocks) == 1 {
+		empty := slack.NewTextBlockObject("mrkdwn", service.Message(locale, service.MsgHomeEmpty, nil), false, false)
+		blocks = append(blocks, slack.NewSectionBlock(empty, nil, nil))
+	}
+	blocks = service.TruncateBlocks(blocks, maxHomeBlocks, service.Message(locale, service.MsgHomeTruncated, nil))
+	return
+}
+
+// Publishes the App Home of userID, showing the team's queues as of seq or
+// later. Unless force is set, nothing is done if the viewer was already shown
+// seq.
+func (sg *ServerGroup) publishHome(team string, userID string, v *homeView, seq int64, force bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !force && seq <= v.seq {
+		return
+	}
+	api, ok := sg.teams.Client(team)
+	if !ok {
+		glog.Errorf("No installation for team %v, not publishing home", team)
+		return
+	}
+	user, err := sg.userLookup(team).Lookup(userID)
+	if err != nil {
+		glog.Errorf("Error looking up locale of %v for home: %v", userID, err)
+		user = &slack.User{ID: userID}
+	}
+	blocks := homeBlocks(team, user, sg.teamServers(team))
+	view := slack.HomeTabViewRequest{Type: slack.VTHomeTab, Blocks: slack.Blocks{BlockSet: blocks}}
+	if _, err := api.PublishView(userID, view, ""); err != nil {
+		glog.Errorf("Error publishing home for %v: %v", userID, err)
+		return
+	}
+	if seq > v.seq {
+		v.seq = seq
+	}
+}
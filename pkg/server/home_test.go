@@ -0,0 +1,151 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+type fixedAdmins map[string]bool
+
+func (a fixedAdmins) IsAdmin(user *slack.User) (bool, error) {
+	return a[user.ID], nil
+}
+
+func (a fixedAdmins) SendAdminMessage(str string) error {
+	return nil
+}
+
+func homeServer(admins fixedAdmins, users ...string) *Server {
+	srv := &Server{service: service.TS(staticUserLookup{}, nil), admin: admins, auth: service.MakeAuthorizer(nil, nil, admins)}
+	for _, id := range users {
+		srv.service.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: id}}, &service.EnqueueResponse{})
+	}
+	return srv
+}
+
+func actionIDs(blocks []slack.Block) (ids []string) {
+	for _, b := range blocks {
+		switch b := b.(type) {
+		case *slack.ActionBlock:
+			for _, e := range b.Elements.ElementSet {
+				if btn, ok := e.(*slack.ButtonBlockElement); ok {
+					ids = append(ids, btn.ActionID)
+				}
+			}
+		case *slack.SectionBlock:
+			if b.Accessory != nil && b.Accessory.ButtonElement != nil {
+				ids = append(ids, b.Accessory.ButtonElement.ActionID)
+			}
+		}
+	}
+	return
+}
+
+func TestHomeForAdmin(t *testing.T) {
+	admins := fixedAdmins{"A1": true}
+	servers := []channelServer{
+		{"C1", homeServer(admins, "U1", "U2")},
+		{"C2", homeServer(fixedAdmins{}, "U3")},
+	}
+
+	ids := actionIDs(homeBlocks("T1", &slack.User{ID: "A1"}, servers))
+	// Remove and take for each user in C1, plus a move button each.
+	expected := []string{"remove", "take", "down", "remove", "take", "up"}
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected actions %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected actions %v, got %v", expected, ids)
+		}
+	}
+}
+
+func TestHomeForStudent(t *testing.T) {
+	servers := []channelServer{
+		{"C1", homeServer(fixedAdmins{}, "U1", "U2")},
+		{"C2", homeServer(fixedAdmins{}, "U3")},
+	}
+
+	blocks := homeBlocks("T1", &slack.User{ID: "U2"}, servers)
+	ids := actionIDs(blocks)
+	if len(ids) != 1 || ids[0] != "leave" {
+		t.Fatalf("Expected a single leave action, got %v", ids)
+	}
+	cb := &slack.InteractionCallback{}
+	cb.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: "leave", BlockID: blocks[1].(*slack.SectionBlock).BlockID}}
+	if service.ActionChannel(cb) != "C1" {
+		t.Fatalf("Leave action not routed to C1: %v", service.ActionChannel(cb))
+	}
+}
+
+func TestHomeNotQueued(t *testing.T) {
+	servers := []channelServer{{"C1", homeServer(fixedAdmins{}, "U1")}}
+	if ids := actionIDs(homeBlocks("T1", &slack.User{ID: "U9"}, servers)); len(ids) != 0 {
+		t.Fatalf("Unexpected actions for user not in any queue: %v", ids)
+	}
+}
+
+func TestHomeRefreshesCoalesced(t *testing.T) {
+	var hv homeViewers
+	if !hv.changed("T1") || hv.changed("T1") || hv.changed("T1") {
+		t.Fatalf("Expected one refresh scheduled for three changes")
+	}
+	if !hv.changed("T2") {
+		t.Fatalf("Refresh of T2 not scheduled")
+	}
+	if seq := hv.start("T1"); seq != 3 {
+		t.Fatalf("Expected the refresh to publish version 3, got %d", seq)
+	}
+	if !hv.changed("T1") {
+		t.Fatalf("Refresh not scheduled after the last one started")
+	}
+}
+
+func TestHomePublishedOnlyIfNewer(t *testing.T) {
+	var published int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/views.publish" {
+			atomic.AddInt32(&published, 1)
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		w.Write([]byte(`{"ok":false,"error":"unknown_method"}`))
+	}))
+	defer api.Close()
+	sg := testGroup()
+	c := service.NewSlackClient("xoxb-T1", service.DefaultRetryPolicy, nil, slack.OptionAPIURL(api.URL+"/"))
+	defer c.Close()
+	sg.teams.clients["T1"] = c
+	sg.users["T1"] = staticUserLookup{}
+
+	sg.homeOpened("T1", "U1")
+	sg.homes.changed("T1")
+	sg.homes.changed("T1")
+	sg.refreshHomes("T1")
+	// Nothing changed since.
+	sg.refreshHomes("T1")
+	if n := atomic.LoadInt32(&published); n != 2 {
+		t.Fatalf("Expected 2 publishes, got %d", n)
+	}
+
+	// An older version isn't published over a newer one.
+	v := sg.homes.opened("T1", "U1")
+	sg.publishHome("T1", "U1", v, 1, false)
+	if n := atomic.LoadInt32(&published); n != 2 {
+		t.Fatalf("Older version published")
+	}
+	// Opening the home always publishes it.
+	sg.homeOpened("T1", "U1")
+	if n := atomic.LoadInt32(&published); n != 3 {
+		t.Fatalf("Expected home published when opened, got %d publishes", n)
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+)
+
+// Renders the join message: a public message in a queue's channel with buttons
+// to join and leave the queue.
+func (s *Server) renderJoin() (seq int64, text string, blocks []slack.Block, err error) {
+	resp := &service.SummaryResponse{}
+	if err = s.service.Summary(&service.SummaryRequest{}, resp); err != nil {
+		return
+	}
+	seq = resp.Token
+	locale := s.service.Locale()
+	text = s.service.Msg(locale, service.MsgJoinText, nil)
+	blocks = service.JoinBlocks(s.service, locale, resp)
+	return
+}
+
+// Updates the join message, if it has been posted.
+func (sg *ServerGroup) refreshJoin(srv *Server) {
+	if srv.join.refresh(srv.api, srv.channel, srv.renderJoin, false, false) {
+		sg.Lock()
+		defer sg.Unlock()
+		sg.Persist()
+	}
+}
+
+// Replaces any previous join message with a new one at the bottom of the
+// channel.
+func (sg *ServerGroup) postJoin(srv *Server) {
+	srv.join.remove(srv.api, srv.channel)
+	srv.join.refresh(srv.api, srv.channel, srv.renderJoin, true, true)
+	sg.Lock()
+	defer sg.Unlock()
+	sg.Persist()
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"sync"
+)
+
+// Pinned message that is kept up to date with the state of a queue.
+type liveMessage struct {
+	mu  sync.Mutex
+	ts  string // timestamp of the message, empty if not posted
+	seq int64  // version of the queue last rendered
+}
+
+// Renders the message for the current state of the queue.
+type renderFunc func() (seq int64, text string, blocks []slack.Block, err error)
+
+func (m *liveMessage) timestamp() string {
+	if m == nil {
+		return ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ts
+}
+
+// Brings the message in channel up to date. If it hasn't been posted (or was
+// deleted), it's posted and pinned only if post is set. Unless force is set,
+// nothing is done if the message already shows the current version. Returns
+// whether a new message was posted.
+func (m *liveMessage) refresh(api *slack.Client, channel string, render renderFunc, post bool, force bool) (posted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ts == "" && !post {
+		return
+	}
+	seq, text, blocks, err := render()
+	if err != nil {
+		glog.Errorf("Error rendering message for %v: %v", channel, err)
+		return
+	}
+	if !force && m.ts != "" && seq == m.seq {
+		return
+	}
+
+	opts := []slack.MsgOption{slack.MsgOptionBlocks(blocks...), slack.MsgOptionText(text, false)}
+	if m.ts != "" {
+		_, _, _, err = api.UpdateMessage(channel, m.ts, opts...)
+		if err == nil {
+			m.seq = seq
+			return
+		}
+		if err.Error() != "message_not_found" {
+			glog.Errorf("Error updating message %v in %v: %v", m.ts, channel, err)
+			return
+		}
+		glog.Infof("Message %v in %v was deleted", m.ts, channel)
+		m.ts = ""
+		if !post {
+			return
+		}
+	}
+
+	_, ts, err := api.PostMessage(channel, opts...)
+	if err != nil {
+		glog.Errorf("Error posting message in %v: %v", channel, err)
+		return
+	}
+	m.ts = ts
+	m.seq = seq
+	posted = true
+	if err = api.AddPin(channel, slack.NewRefToMessage(channel, ts)); err != nil {
+		glog.Errorf("Error pinning message %v in %v: %v", ts, channel, err)
+	}
+	return
+}
+
+// Deletes the message, if it was posted.
+func (m *liveMessage) remove(api *slack.Client, channel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ts == "" {
+		return
+	}
+	if _, _, err := api.DeleteMessage(channel, m.ts); err != nil {
+		glog.Errorf("Error deleting message %v in %v: %v", m.ts, channel, err)
+	}
+	m.ts = ""
+}
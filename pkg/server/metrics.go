@@ -0,0 +1,92 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/metrics"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"time"
+)
+
+// Records the operations of the group's queues in m, and registers with reg
+// metrics computed from the queues, membership cache and API clients when
+// scraped.
+func (sg *ServerGroup) RegisterMetrics(reg prometheus.Registerer, m *service.Metrics) {
+	sg.Lock()
+	sg.metrics = m
+	for _, servers := range []map[serverKey]*Server{sg.servers, sg.archived} {
+		for _, srv := range servers {
+			srv.service.EnableMetrics(m)
+		}
+	}
+	sg.Unlock()
+
+	reg.MustRegister(
+		metrics.NewGaugeFunc("slackqueue_queue_length", "Users waiting, by channel.",
+			[]string{"channel"}, func(set metrics.SetFunc) {
+				for channel, resp := range sg.summaries() {
+					set(float64(resp.Size), channel)
+				}
+			}),
+		metrics.NewGaugeFunc("slackqueue_queue_oldest_wait_seconds", "Wait of the user waiting longest, by channel; zero if none are.",
+			[]string{"channel"}, func(set metrics.SetFunc) {
+				now := time.Now()
+				for channel, resp := range sg.summaries() {
+					var wait time.Duration
+					if resp.Size > 0 {
+						wait = now.Sub(resp.Oldest)
+					}
+					set(wait.Seconds(), channel)
+				}
+			}),
+		metrics.NewCounterFunc("slackqueue_membership_cache_events_total", "Lookups and fetches of the membership caches, by event.",
+			[]string{"event"}, func(set metrics.SetFunc) {
+				s := sg.membershipStats()
+				set(float64(s.Hits), "hit")
+				set(float64(s.Misses), "miss")
+				set(float64(s.Stale), "stale")
+				set(float64(s.Fetches), "fetch")
+				set(float64(s.Errors), "error")
+				set(float64(s.Invalidations), "invalidation")
+			}),
+		metrics.NewCounterFunc("slackqueue_slack_client_events_total", "Requests and admin messages of the Slack API clients, by event.",
+			[]string{"event"}, func(set metrics.SetFunc) {
+				s := sg.teams.Stats()
+				set(float64(s.Requests), "request")
+				set(float64(s.Retries), "retry")
+				set(float64(s.RateLimited), "ratelimited")
+				set(float64(s.Failures), "failure")
+				set(float64(s.Queued), "message_queued")
+				set(float64(s.Sent), "message_sent")
+				set(float64(s.Dropped), "message_dropped")
+			}))
+}
+
+// Summaries of the served queues, keyed by channel label. Summaries of
+// channels sharing a label are combined.
+func (sg *ServerGroup) summaries() map[string]service.SummaryResponse {
+	sg.Lock()
+	servers := make([]*Server, 0, len(sg.servers))
+	for _, srv := range sg.servers {
+		servers = append(servers, srv)
+	}
+	sg.Unlock()
+
+	summaries := make(map[string]service.SummaryResponse)
+	for _, srv := range servers {
+		resp := service.SummaryResponse{}
+		srv.service.Summary(&service.SummaryRequest{}, &resp)
+		channel := sg.metrics.Channel(srv.channel)
+		s, ok := summaries[channel]
+		if ok {
+			s.Size += resp.Size
+			if resp.Size > 0 && (s.Oldest.IsZero() || resp.Oldest.Before(s.Oldest)) {
+				s.Oldest = resp.Oldest
+			}
+			resp = s
+		}
+		summaries[channel] = resp
+	}
+	return summaries
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/metrics"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/slack-go/slack"
+
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueueMetrics(t *testing.T) {
+	sg := testGroup()
+	for _, c := range []string{"C1", "C2"} {
+		qs := service.TS(staticUserLookup{}, nil)
+		qs.Identify("T1", c)
+		sg.servers[serverKey{"T1", c}] = &Server{channel: c, service: qs, admin: service.NoopAdminInterface{}}
+	}
+	reg := prometheus.NewRegistry()
+	sg.RegisterMetrics(reg, service.NewMetrics(reg, metrics.MakeChannelLabeler(metrics.DefaultMaxChannels)))
+	qs := sg.servers[serverKey{"T1", "C1"}].service
+	qs.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: "U1"}}, &service.EnqueueResponse{})
+	qs.Remove(context.Background(), &service.RemoveRequest{Pos: 0, Token: -1}, &service.RemoveResponse{})
+
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b := w.Body
+	for _, line := range []string{
+		`slackqueue_queue_length{channel="C1"} 1`,
+		`slackqueue_queue_length{channel="C2"} 0`,
+		`slackqueue_queue_operations_total{channel="C1",op="enqueue",result="ok"} 1`,
+		`slackqueue_queue_operations_total{channel="C1",op="remove",result="failed"} 1`,
+		`slackqueue_queue_version_conflicts_total{channel="C1",op="remove"} 1`,
+		`slackqueue_membership_cache_events_total{event="hit"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("Expected %q in:\n%s", line, b.String())
+		}
+	}
+}
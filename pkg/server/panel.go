@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+)
+
+// Slack's limit on blocks in a message.
+const maxMessageBlocks = 50
+
+// Renders the control panel: a message in a queue's admin channel showing the
+// live queue, with the same controls as the list command.
+func (s *Server) renderPanel() (seq int64, text string, blocks []slack.Block, err error) {
+	resp := &service.ListResponse{}
+	if err = s.service.List(&service.ListRequest{}, resp); err != nil {
+		return
+	}
+	seq = resp.Token
+	locale := s.service.Locale()
+	text = s.service.Msg(locale, service.MsgPanelText, service.Args{"Channel": s.channel})
+	blocks = service.TruncateBlocks(service.QueueBlocks(s.service, locale, s.channel, resp), maxMessageBlocks,
+		s.service.Msg(locale, service.MsgPanelTruncated, nil))
+	return
+}
+
+// Brings the control panel up to date with the queue, posting it if it doesn't
+// exist yet. Returns whether a new message was posted.
+func (s *Server) refreshPanel(force bool) (posted bool) {
+	ac, ok := s.admin.(service.AdminChannel)
+	if !ok {
+		return
+	}
+	id, err := ac.AdminChannelID()
+	if err != nil {
+		glog.Errorf("Error finding admin channel for control panel of %v: %v", s.channel, err)
+		return
+	}
+	return s.panel.refresh(s.api, id, s.renderPanel, true, force)
+}
+
+// Deletes the control panel of a deleted queue.
+func (s *Server) removePanel() {
+	ac, ok := s.admin.(service.AdminChannel)
+	if !ok {
+		return
+	}
+	id, err := ac.AdminChannelID()
+	if err != nil {
+		glog.Errorf("Error finding admin channel for control panel of %v: %v", s.channel, err)
+		return
+	}
+	s.panel.remove(s.api, id)
+}
+
+func (sg *ServerGroup) refreshPanel(srv *Server, force bool) {
+	if srv.refreshPanel(force) {
+		sg.Lock()
+		defer sg.Unlock()
+		sg.Persist()
+	}
+}
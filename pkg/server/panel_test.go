@@ -0,0 +1,103 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type channelAdmins struct {
+	fixedAdmins
+	id string
+}
+
+func (a channelAdmins) AdminChannelID() (string, error) {
+	return a.id, nil
+}
+
+// Records calls to the Slack Web API.
+type fakeAPI struct {
+	mu    sync.Mutex
+	calls []string
+	srv   *httptest.Server
+}
+
+func makeFakeAPI() *fakeAPI {
+	f := &fakeAPI{}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls = append(f.calls, r.URL.Path)
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"channel":"A1","ts":"1.0001"}`))
+	}))
+	return f
+}
+
+func (f *fakeAPI) client() *slack.Client {
+	return slack.New("xoxb-test", slack.OptionAPIURL(f.srv.URL+"/"))
+}
+
+func (f *fakeAPI) reset() (calls []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls, f.calls = f.calls, nil
+	return
+}
+
+func TestPanelPostedThenUpdated(t *testing.T) {
+	f := makeFakeAPI()
+	defer f.srv.Close()
+	srv := &Server{
+		api:     f.client(),
+		channel: "C1",
+		service: service.TS(staticUserLookup{}, nil),
+		admin:   channelAdmins{id: "A1"},
+		panel:   &liveMessage{}}
+
+	if !srv.refreshPanel(false) {
+		t.Fatal("Panel not posted.")
+	}
+	if calls := f.reset(); len(calls) != 2 || calls[0] != "/chat.postMessage" || calls[1] != "/pins.add" {
+		t.Fatalf("Expected post and pin, got %v", calls)
+	}
+	if srv.panel.timestamp() != "1.0001" {
+		t.Fatalf("Panel timestamp not recorded: %v", srv.panel.timestamp())
+	}
+
+	// Unchanged queue.
+	srv.refreshPanel(false)
+	if calls := f.reset(); len(calls) != 0 {
+		t.Fatalf("Panel refreshed without changes: %v", calls)
+	}
+
+	srv.service.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: "U1"}}, &service.EnqueueResponse{})
+	if srv.refreshPanel(false) {
+		t.Fatal("Panel reposted instead of updated.")
+	}
+	if calls := f.reset(); len(calls) != 1 || calls[0] != "/chat.update" {
+		t.Fatalf("Expected update, got %v", calls)
+	}
+}
+
+func TestNoPanelWithoutAdminChannel(t *testing.T) {
+	f := makeFakeAPI()
+	defer f.srv.Close()
+	srv := &Server{
+		api:     f.client(),
+		channel: "C1",
+		service: service.TS(staticUserLookup{}, nil),
+		admin:   service.NoopAdminInterface{},
+		panel:   &liveMessage{}}
+
+	srv.refreshPanel(true)
+	if calls := f.reset(); len(calls) != 0 {
+		t.Fatalf("Unexpected calls for queue without admin channel: %v", calls)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"strings"
+)
+
+const linkSetting = "link"
+
+// Manages the profile of the user issuing a command, e.g., "me link <url>".
+func (sg *ServerGroup) me(api *slack.Client, cmd *slack.SlashCommand, locale string, args string) {
+	usage := service.Message(locale, service.MsgMeUsage, service.Args{"Command": sg.command})
+
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	switch {
+	case parts[0] == "":
+		p, _ := sg.profiles.Profile(cmd.TeamID, cmd.UserID)
+		if p.MeetingLink == "" {
+			reply(api, cmd, service.Message(locale, service.MsgMeNoLink, service.Args{"Usage": usage}))
+		} else {
+			reply(api, cmd, service.Message(locale, service.MsgMeLink, service.Args{"Link": p.MeetingLink}))
+		}
+	case parts[0] == linkSetting:
+		text := ""
+		if len(parts) > 1 {
+			text = parts[1]
+		}
+		link, ok := service.ParseMeetingLink(text)
+		if !ok {
+			reply(api, cmd, service.Message(locale, service.MsgMeInvalid, service.Args{"Text": text, "Usage": usage}))
+			return
+		}
+		glog.Infof("Setting meeting link of %v to %q", cmd.UserID, link)
+		sg.profiles.SetMeetingLink(cmd.TeamID, cmd.UserID, link)
+		if link == "" {
+			reply(api, cmd, service.Message(locale, service.MsgMeRemoved, nil))
+		} else {
+			reply(api, cmd, service.Message(locale, service.MsgMeSet, service.Args{"Link": link}))
+		}
+	default:
+		reply(api, cmd, usage)
+	}
+}
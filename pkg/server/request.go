@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/slack-go/slack"
+
+	"context"
+)
+
+// Returns a context for handling a slash command, logging with a new request
+// ID and the command's team, channel and user.
+func CommandContext(ctx context.Context, cmd *slack.SlashCommand) context.Context {
+	return logging.StartRequest(ctx, "command",
+		logging.Team, cmd.TeamID, logging.Channel, cmd.ChannelID, logging.User, cmd.UserID)
+}
+
+// Returns a context for handling an Events API event, logging with a new
+// request ID and the event's team and ID.
+func EventContext(ctx context.Context, ev *Event) context.Context {
+	return logging.StartRequest(ctx, "event", logging.Team, ev.TeamID, logging.Event, ev.EventID)
+}
+
+// Returns a context for handling an interaction, logging with a new request
+// ID and the interaction's team, channel and user.
+func ActionContext(ctx context.Context, cb *slack.InteractionCallback) context.Context {
+	return logging.StartRequest(ctx, "action",
+		logging.Team, cb.Team.ID, logging.Channel, cb.Channel.ID, logging.User, cb.User.ID)
+}
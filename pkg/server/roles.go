@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"strings"
+)
+
+// Shows the queue's policy, or sets the members of a role:
+// "role ta <#C123|tas> <@U123>", or "role ta default".
+func (sg *ServerGroup) setRole(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, args string) {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if parts[0] == "" {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgPolicy, service.Args{"Policy": srv.service.Policy()}))
+		return
+	}
+	role, ok := service.ParseRole(parts[0])
+	var members *service.Members
+	var err error
+	if ok && len(parts) > 1 {
+		members, err = service.ParseMembers(parts[1])
+	}
+	if !ok || len(parts) < 2 || err != nil {
+		glog.Errorf("Error parsing role for channel %v: %q: %v", cmd.ChannelID, args, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgRoleUsage, service.Args{"Command": sg.command}))
+		return
+	}
+	srv.service.SetPolicy(srv.service.Policy().WithMembers(role, members))
+	sg.Lock()
+	sg.Persist()
+	sg.Unlock()
+
+	if members == nil {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgRoleDefault, service.Args{"Role": role}))
+	} else {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgRoleSet, service.Args{"Role": role, "Members": members}))
+	}
+}
+
+// Sets the roles that may perform an operation: "allow list ta observer", or
+// "allow list default". Owners may always perform any operation.
+func (sg *ServerGroup) setGrant(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, args string) {
+	fields := strings.Fields(args)
+	ok := len(fields) > 1 && service.IsOp(fields[0])
+	var roles []service.Role
+	if ok && !(len(fields) == 2 && fields[1] == "default") {
+		roles = []service.Role{}
+		for _, f := range fields[1:] {
+			role, valid := service.ParseRole(f)
+			if !valid {
+				ok = false
+				break
+			}
+			roles = append(roles, role)
+		}
+	}
+	if !ok {
+		glog.Errorf("Error parsing grant for channel %v: %q", cmd.ChannelID, args)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgAllowUsage, service.Args{"Command": sg.command}))
+		return
+	}
+	op := fields[0]
+	srv.service.SetPolicy(srv.service.Policy().WithGrant(op, roles))
+	sg.Lock()
+	sg.Persist()
+	sg.Unlock()
+
+	if roles == nil {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgAllowDefault, service.Args{"Op": op}))
+	} else {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgAllowSet, service.Args{"Roles": strings.Join(fields[1:], ", "), "Op": op}))
+	}
+}
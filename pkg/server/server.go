@@ -1,39 +1,102 @@
 package server
 
 import (
+	"github.com/ml8/slack-queue/pkg/logging"
 	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/queue"
 	"github.com/ml8/slack-queue/pkg/service"
 	"github.com/slack-go/slack"
 
 	"github.com/golang/glog"
 
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	CreateString = "create"
-	DeleteString = "delete"
+	CreateString   = "create"
+	DeleteString   = "delete"
+	PostString     = "post"
+	FormString     = "form"
+	EscalateString = "escalate"
+	MeString       = "me"
+	LocaleString   = "locale"
+	MessageString  = "message"
+	RoleString     = "role"
+	AllowString    = "allow"
+	HistoryString  = "history"
+	StatsString    = "stats"
+	ExportString   = "export"
 )
 
+// Management commands that configure the queue of the channel they're issued
+// in, and may be used by the queue's admins.
+var queueCommands = map[string]bool{
+	PostString:     true,
+	FormString:     true,
+	EscalateString: true,
+	LocaleString:   true,
+	MessageString:  true,
+	RoleString:     true,
+	AllowString:    true,
+	HistoryString:  true,
+	StatsString:    true,
+	ExportString:   true,
+}
+
+// Operations of queue commands that aren't OpManage.
+var queueCommandOps = map[string]string{
+	HistoryString: service.OpHistory,
+	StatsString:   service.OpStats,
+	ExportString:  service.OpHistory,
+}
+
+// Servers are keyed by team and channel. Servers created before multi-workspace
+// support have an empty team.
+type serverKey struct {
+	team    string
+	channel string
+}
+
 type ServerGroup struct {
 	sync.Mutex
-	servers      map[string]*Server
-	api          *slack.Client
-	admin        service.AdminInterface
+	servers      map[serverKey]*Server
+	archived     map[serverKey]*Server
+	teams        *TeamStore
+	profiles     *service.ProfileStore
+	history      service.HistoryStore
+	authChannel  string
+	teamAdmins   map[string]service.AdminInterface   // per-team global admins
+	usersMu      sync.Mutex                          // guards users and members; may be held with the group's lock
+	users        map[string]service.UserLookup       // per-team profile caches
+	members      map[string]*service.MembershipCache // per-team membership caches, shared by admins and policies
 	command      string
 	commandNames service.CommandNames
 	persist      persister.Persister
+	homes        homeViewers
+	background   backgroundWork
+	metrics      *service.Metrics // set by RegisterMetrics
+	recovered    int32            // set once Recover finishes
+	probing      int32            // set while a health check waits for the lock
 }
 
-func CreateServerGroup(api *slack.Client, admin service.AdminInterface, command string, commandNames service.CommandNames, persist persister.Persister) *ServerGroup {
+func CreateServerGroup(teams *TeamStore, profiles *service.ProfileStore, history service.HistoryStore, authChannel string, command string, commandNames service.CommandNames, persist persister.Persister) *ServerGroup {
 	return &ServerGroup{
-		servers:      make(map[string]*Server),
-		api:          api,
-		admin:        admin,
+		servers:      make(map[serverKey]*Server),
+		archived:     make(map[serverKey]*Server),
+		teams:        teams,
+		profiles:     profiles,
+		history:      history,
+		authChannel:  authChannel,
+		teamAdmins:   make(map[string]service.AdminInterface),
+		members:      make(map[string]*service.MembershipCache),
+		users:        make(map[string]service.UserLookup),
 		command:      command,
 		commandNames: commandNames,
 		persist:      persist}
@@ -41,78 +104,265 @@ func CreateServerGroup(api *slack.Client, admin service.AdminInterface, command
 
 type Server struct {
 	api       *slack.Client
+	team      string
+	channel   string
 	service   *service.QueueService
 	admin     service.AdminInterface
+	auth      *service.Authorizer
 	commands  map[string]service.Command
 	actions   map[string]service.Action
 	adminChan string
+	panel     *liveMessage // control panel in the admin channel
+	join      *liveMessage // join message in the queue's channel
 }
 
 type ServerState struct {
+	TeamID    string `json:"TeamID,omitempty"`
 	ChannelID string `json:"ChannelID"`
 	AdminChan string `json:"AdminChan"`
+	Archived  bool   `json:"Archived,omitempty"`
+	PanelTs   string `json:"PanelTs,omitempty"`
+	JoinTs    string `json:"JoinTs,omitempty"`
+
+	Form       *service.IntakeForm      `json:"Form,omitempty"`
+	Escalation *service.Escalation      `json:"Escalation,omitempty"`
+	Messages   *service.MessageSettings `json:"Messages,omitempty"`
+	Policy     *service.Policy          `json:"Policy,omitempty"`
 }
 
 type ServerGroupState struct {
 	States []ServerState `json:"States"`
 }
 
-func (s *Server) ForwardCommand(cmd *slack.SlashCommand, w http.ResponseWriter) {
+func (s *Server) ForwardCommand(ctx context.Context, cmd *slack.SlashCommand, w http.ResponseWriter) {
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).With(logging.Action, cmd.Command))
 	c, ok := s.commands[cmd.Command]
 	if !ok {
-		w.WriteHeader(http.StatusNotFound)
+		service.ReplyCommandError(ctx, w, cmd, s.service.Locale(), service.Errorf(service.ErrNotFound, "unknown command %v", cmd.Command))
 		return
 	}
 
-	c.Handle(cmd, s.service, w)
+	c.Handle(ctx, cmd, s.service, w)
 }
 
-func (s *Server) ForwardAction(act *slack.InteractionCallback, w http.ResponseWriter) {
+func (s *Server) ForwardAction(ctx context.Context, act *slack.InteractionCallback, w http.ResponseWriter) {
 	var handler service.Action
 	ok := false
+	name := ""
+	if act.Type == slack.InteractionTypeViewSubmission {
+		// Submitted modals are routed by their callback ID.
+		name = act.View.CallbackID
+		handler, ok = s.actions[name]
+	}
 	// Only looking for block actions; right now at most one per payload.
 	for _, a := range act.ActionCallback.BlockActions {
-		handler, ok = s.actions[service.ParseAction(a.ActionID)]
+		name = service.ParseAction(a.ActionID)
+		handler, ok = s.actions[name]
 		if ok {
 			break
 		}
 	}
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).With(logging.Action, name))
 
 	if !ok {
-		glog.Errorf("Unknown action type: %v", act.ActionID)
-		w.WriteHeader(http.StatusNotFound)
+		service.ReplyActionError(ctx, w, s.api, act, s.service.Locale(), service.Errorf(service.ErrNotFound, "unknown action type %v", act.ActionID))
 		return
 	}
-	handler.Handle(act, s.service, w)
+	handler.Handle(ctx, act, s.service, w)
 }
 
-func (sg *ServerGroup) Lookup(id string) (srv *Server, found bool) {
+// Returns the server for a team's channel. Falls back to servers created before
+// multi-workspace support, which are keyed by channel alone.
+func (sg *ServerGroup) Lookup(team string, channel string) (srv *Server, found bool) {
 	sg.Lock()
 	defer sg.Unlock()
-	srv, found = sg.servers[id]
+	return sg.lookupLocked(team, channel)
+}
+
+// Must hold lock.
+func (sg *ServerGroup) lookupLocked(team string, channel string) (srv *Server, found bool) {
+	key, found := findKey(sg.servers, team, channel)
+	srv = sg.servers[key]
 	return
 }
 
+// Returns the API client for a team.
+func (sg *ServerGroup) Client(team string) (api *slack.Client, ok bool) {
+	return sg.teams.Client(team)
+}
+
+// Returns the global admins for a team, i.e., the members of the team's auth
+// channel.
+func (sg *ServerGroup) admin(team string, api *service.SlackClient) service.AdminInterface {
+	sg.Lock()
+	defer sg.Unlock()
+	admin, ok := sg.teamAdmins[team]
+	if !ok {
+		admin = service.AdminInterfaceFromChannel(api, sg.membership(team), sg.authChannel)
+		sg.teamAdmins[team] = admin
+	}
+	return admin
+}
+
+// Returns the cache of a team's channel and user group memberships, shared by
+// its queues. Channel names and IDs are only unique within a team, so teams
+// don't share caches.
+func (sg *ServerGroup) membership(team string) *service.MembershipCache {
+	sg.usersMu.Lock()
+	defer sg.usersMu.Unlock()
+	c, ok := sg.members[team]
+	if !ok {
+		c = service.MakeMembershipCache(service.DefaultMembershipTTL)
+		sg.members[team] = c
+	}
+	return c
+}
+
+// Combined stats of the teams' membership caches.
+func (sg *ServerGroup) membershipStats() (stats service.MembershipStats) {
+	sg.usersMu.Lock()
+	defer sg.usersMu.Unlock()
+	for _, c := range sg.members {
+		stats = stats.Add(c.Stats())
+	}
+	return
+}
+
+// Returns the cache of a team's user profiles, shared by its queues.
+func (sg *ServerGroup) userLookup(team string) service.UserLookup {
+	sg.usersMu.Lock()
+	defer sg.usersMu.Unlock()
+	ul, ok := sg.users[team]
+	if !ok {
+		ul = service.MakeCachingUserLookup(func(id string) (*slack.User, error) {
+			return sg.fetchUser(team, id)
+		}, service.DefaultUserTTL, service.DefaultNegativeUserTTL)
+		sg.users[team] = ul
+	}
+	return ul
+}
+
+// Looks up a user with the team's current client, which retries, as the
+// client is replaced when the team reinstalls.
+func (sg *ServerGroup) fetchUser(team string, id string) (*slack.User, error) {
+	api, ok := sg.teams.SlackClient(team)
+	if !ok {
+		return nil, service.Errorf(service.ErrNotFound, "no installation for team %v", team)
+	}
+	return api.GetUserInfo(id)
+}
+
+func (sg *ServerGroup) queuePersister(team string, name string) persister.Persister {
+	return queuePersister(sg.persist, team, name)
+}
+
+// Persister of a queue in a group persisted with persist.
+func queuePersister(persist persister.Persister, team string, name string) persister.Persister {
+	if persist == nil {
+		return nil
+	}
+	suffix := "-" + team + "-" + name
+	if team == "" {
+		suffix = "-" + name
+	}
+	if fp, ok := persist.(persister.FilePersister); ok {
+		// Keep its latency observer.
+		fp.Fn += suffix
+		return fp
+	}
+	return persister.FilePersister{Fn: persist.Id() + suffix}
+}
+
+// Name of the persisted queue of a server. Servers without a team were
+// persisted in a file named for their admin channel.
+func queueName(state ServerState) string {
+	if state.TeamID == "" {
+		return state.AdminChan
+	}
+	return state.ChannelID
+}
+
+func (sg *ServerGroup) makeServer(client *service.SlackClient, team string, channel string, qs *service.QueueService, adminChan string) *Server {
+	api := client.Client
+	members := sg.membership(team)
+	admin := service.AdminInterfaceFromChannel(client, members, adminChan)
+	auth := service.MakeAuthorizer(api, members, admin)
+	ul := sg.userLookup(team)
+	srv := &Server{
+		api:       api,
+		team:      team,
+		channel:   channel,
+		service:   qs,
+		admin:     admin,
+		auth:      auth,
+		commands:  service.DefaultCommands(api, admin, auth, ul, sg.profiles, sg.commandNames),
+		actions:   service.DefaultActions(api, admin, auth, ul, sg.profiles),
+		adminChan: adminChan,
+		panel:     &liveMessage{},
+		join:      &liveMessage{}}
+	qs.Identify(team, channel)
+	qs.EnableHistory(sg.history)
+	qs.EnableMetrics(sg.metrics)
+	qs.EnableNotifications(ul, func(user string, text string) error {
+		_, _, err := api.PostMessage(user, slack.MsgOptionText(text, false))
+		return err
+	})
+	qs.Subscribe(func(els []queue.Element, seq int64) {
+		sg.queuesChanged(team)
+		sg.goBackground(func() { sg.refreshPanel(srv, false) })
+		sg.goBackground(func() { sg.refreshJoin(srv) })
+	})
+	return srv
+}
+
+// Returns the ID of the server's admin channel, if its admins have one.
+func (s *Server) adminChannelID() (id string, err error) {
+	ac, ok := s.admin.(service.AdminChannel)
+	if !ok {
+		return "", fmt.Errorf("admins of %v have no channel", s.channel)
+	}
+	return ac.AdminChannelID()
+}
+
 func (sg *ServerGroup) Persist() {
 	if sg.persist == nil {
 		return
 	}
-	glog.Infof("Persisting server list...")
-	state := make([]ServerState, len(sg.servers))
-	i := 0
-	for key := range sg.servers {
-		glog.Infof("%v", key)
-		state[i] = ServerState{
-			ChannelID: key,
-			AdminChan: sg.servers[key].adminChan}
-		i += 1
+	glog.Infof("Persisting %d servers...", len(sg.servers)+len(sg.archived))
+	state := make([]ServerState, 0, len(sg.servers)+len(sg.archived))
+	for key, srv := range sg.servers {
+		glog.V(1).Infof("Persisting %v", key)
+		state = append(state, ServerState{
+			TeamID:     key.team,
+			ChannelID:  key.channel,
+			AdminChan:  srv.adminChan,
+			PanelTs:    srv.panel.timestamp(),
+			JoinTs:     srv.join.timestamp(),
+			Form:       srv.service.Form(),
+			Escalation: srv.service.Escalation(),
+			Messages:   messageSettings(srv),
+			Policy:     srv.service.Policy()})
+	}
+	for key, srv := range sg.archived {
+		glog.V(1).Infof("Persisting %v (archived)", key)
+		state = append(state, ServerState{
+			TeamID:     key.team,
+			ChannelID:  key.channel,
+			AdminChan:  srv.adminChan,
+			Archived:   true,
+			PanelTs:    srv.panel.timestamp(),
+			JoinTs:     srv.join.timestamp(),
+			Form:       srv.service.Form(),
+			Escalation: srv.service.Escalation(),
+			Messages:   messageSettings(srv),
+			Policy:     srv.service.Policy()})
 	}
 	sgstate := ServerGroupState{state}
-	glog.Infof("%d", sgstate.States)
 	sg.persist.Write(sgstate)
 }
 
 func (sg *ServerGroup) Recover() {
+	defer atomic.StoreInt32(&sg.recovered, 1)
 	if sg.persist == nil {
 		glog.Infof("Nothing to recover, using in-memory state.")
 		return
@@ -122,25 +372,45 @@ func (sg *ServerGroup) Recover() {
 	sg.persist.Read(&sgstate)
 	glog.Infof("Recovered %d servers.", len(sgstate.States))
 	for _, state := range sgstate.States {
-		glog.Infof("Creating server for channel %v with admin channel %v", state.ChannelID, state.AdminChan)
-		persist := persister.FilePersister{Fn: sg.persist.Id() + "-" + state.AdminChan}
-		srv := service.PersistentTS(sg.api, persist)
+		glog.Infof("Creating server for channel %v (team %v) with admin channel %v", state.ChannelID, state.TeamID, state.AdminChan)
+		client, ok := sg.teams.SlackClient(state.TeamID)
+		if !ok {
+			glog.Errorf("No installation for team %v, not serving channel %v", state.TeamID, state.ChannelID)
+			continue
+		}
+		srv := service.TS(sg.userLookup(state.TeamID), sg.queuePersister(state.TeamID, queueName(state)))
 		srv.Recover()
+		srv.SetForm(state.Form)
+		srv.SetEscalation(state.Escalation)
+		srv.SetPolicy(state.Policy)
+		if state.Messages != nil {
+			srv.SetMessages(*state.Messages)
+		}
 
-		admin := service.AdminInterfaceFromChannel(sg.api, state.AdminChan)
-		sg.servers[state.ChannelID] = &Server{
-			api:       sg.api,
-			service:   srv,
-			admin:     admin,
-			commands:  service.DefaultCommands(sg.api, admin, sg.commandNames),
-			actions:   service.DefaultActions(sg.api, admin),
-			adminChan: state.AdminChan}
+		server := sg.makeServer(client, state.TeamID, state.ChannelID, srv, state.AdminChan)
+		server.panel.ts = state.PanelTs
+		server.join.ts = state.JoinTs
+		if state.Archived {
+			sg.archived[serverKey{state.TeamID, state.ChannelID}] = server
+			continue
+		}
+		sg.servers[serverKey{state.TeamID, state.ChannelID}] = server
+		// Versions restart on recovery, so controls on the panel are stale.
+		sg.goBackground(func() { sg.refreshPanel(server, true) })
 	}
 }
 
 // TODO this code is a mess
 
 func parseCommand(msg string) (cmd string, rest string, err error) {
+	// Form and threshold definitions contain spaces; take the rest of the line.
+	if parts := strings.SplitN(msg, " ", 2); parts[0] == FormString || parts[0] == EscalateString || parts[0] == MeString || parts[0] == MessageString || parts[0] == RoleString || parts[0] == AllowString || parts[0] == ExportString {
+		cmd = parts[0]
+		if len(parts) > 1 {
+			rest = parts[1]
+		}
+		return
+	}
 	parts := strings.Split(msg, " ")
 	if len(parts) > 2 || len(parts) < 1 {
 		err = errors.New("Too few/many arguments")
@@ -152,92 +422,260 @@ func parseCommand(msg string) (cmd string, rest string, err error) {
 	return
 }
 
-func (sg *ServerGroup) usage(cmd *slack.SlashCommand, w http.ResponseWriter) {
-	sg.api.PostMessage(cmd.ChannelID,
-		slack.MsgOptionText(fmt.Sprintf("Usage: %s create|delete [adminChannelName]", sg.command), false),
+// Replies to a management command with an ephemeral message.
+func reply(api *slack.Client, cmd *slack.SlashCommand, msg string) {
+	api.PostMessage(cmd.ChannelID,
+		slack.MsgOptionText(msg, false),
 		slack.MsgOptionPostEphemeral(cmd.UserID))
 }
 
-func (sg *ServerGroup) add(cmd *slack.SlashCommand, action string, channel string) {
+func (sg *ServerGroup) usage(api *slack.Client, cmd *slack.SlashCommand, locale string) {
+	reply(api, cmd, service.Message(locale, service.MsgUsage, service.Args{"Command": sg.command}))
+}
+
+// Locale of replies to a management command: the queue's, if it sets one,
+// otherwise the user's.
+func (sg *ServerGroup) locale(api *slack.Client, cmd *slack.SlashCommand, srv *Server) string {
+	if srv != nil {
+		if locale := srv.service.Messages().Locale; locale != "" {
+			return locale
+		}
+	}
+	user, err := sg.userLookup(cmd.TeamID).Lookup(cmd.UserID)
+	if err != nil {
+		glog.Errorf("Error looking up locale of %v: %v", cmd.UserID, err)
+		return service.DefaultLocale
+	}
+	return service.MatchLocale(user.Locale)
+}
+
+// Current time in the Slack time zone of a user, or in UTC if it's unknown,
+// so that days of history are those of whoever asks for them.
+func (sg *ServerGroup) userNow(team string, api *slack.Client, userID string) time.Time {
+	now := time.Now().UTC()
+	user, err := sg.userLookup(team).Lookup(userID)
+	if err != nil {
+		glog.Errorf("Error looking up time zone of %v: %v", userID, err)
+		return now
+	}
+	loc, err := time.LoadLocation(user.TZ)
+	if err != nil {
+		glog.Errorf("Unknown time zone %q of %v: %v", user.TZ, userID, err)
+		return now
+	}
+	return now.In(loc)
+}
+
+// Message settings of a server, or nil if it has the defaults.
+func messageSettings(srv *Server) *service.MessageSettings {
+	m := srv.service.Messages()
+	if m.Locale == "" && len(m.Overrides) == 0 {
+		return nil
+	}
+	return &m
+}
+
+// Sets (or, given an empty definition, clears) the intake form of a queue.
+func (sg *ServerGroup) setForm(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, def string) {
+	form, err := service.ParseIntakeForm(def)
+	if err != nil {
+		glog.Errorf("Error parsing form for channel %v: %v", cmd.ChannelID, err)
+		sg.usage(api, cmd, locale)
+		return
+	}
+	srv.service.SetForm(form)
+	sg.Lock()
+	sg.Persist()
+	sg.Unlock()
+
+	if form == nil {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgFormRemoved, nil))
+	} else {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgFormSet, service.Args{"Form": form}))
+	}
+}
+
+// Sets the locale of a queue's messages; "user" uses each user's locale.
+func (sg *ServerGroup) setLocale(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, arg string) {
+	if arg == "user" {
+		arg = ""
+	}
+	if err := srv.service.SetLocale(arg); err != nil {
+		glog.Errorf("Error setting locale for channel %v: %v", cmd.ChannelID, err)
+		sg.usage(api, cmd, locale)
+		return
+	}
+	sg.Lock()
+	sg.Persist()
+	sg.Unlock()
+
+	if arg == "" {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgLocaleUser, nil))
+	} else {
+		reply(api, cmd, srv.service.Msg(arg, service.MsgLocaleSet, nil))
+	}
+}
+
+// Overrides one of a queue's messages, e.g., "message up_next {{.User}}, you're
+// up!". Without a template, restores the default.
+func (sg *ServerGroup) setMessage(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, def string) {
+	parts := strings.SplitN(strings.TrimSpace(def), " ", 2)
+	key, text := parts[0], ""
+	if len(parts) > 1 {
+		text = strings.TrimSpace(parts[1])
+	}
+	if err := srv.service.SetOverride(key, text); err != nil {
+		glog.Errorf("Error overriding message for channel %v: %v", cmd.ChannelID, err)
+		reply(api, cmd, err.Error())
+		return
+	}
+	sg.Lock()
+	sg.Persist()
+	sg.Unlock()
+
+	if text == "" {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgMessageReset, service.Args{"Key": key}))
+	} else {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgMessageSet, service.Args{"Key": key}))
+	}
+}
+
+func (sg *ServerGroup) add(client *service.SlackClient, cmd *slack.SlashCommand, locale string, channel string) {
+	api := client.Client
 	sg.Lock()
 	defer sg.Unlock()
-	_, ok := sg.servers[cmd.ChannelID]
+	_, ok := sg.lookupLocked(cmd.TeamID, cmd.ChannelID)
 
 	// Check if it already exists.
 	if ok {
-		sg.api.PostMessage(cmd.ChannelID,
-			slack.MsgOptionText("Queue already exists in this channel.", false),
-			slack.MsgOptionPostEphemeral(cmd.UserID))
+		reply(api, cmd, service.Message(locale, service.MsgQueueExists, nil))
 		return
 	}
 
 	// Create it.
-	admin := service.AdminInterfaceFromChannel(sg.api, channel)
-	var persist persister.Persister
-	if sg.persist != nil {
-		persist = persister.FilePersister{Fn: sg.persist.Id() + "-" + cmd.ChannelID}
-	}
-	sg.servers[cmd.ChannelID] = &Server{
-		api:       sg.api,
-		service:   service.PersistentTS(sg.api, persist),
-		admin:     admin,
-		commands:  service.DefaultCommands(sg.api, admin, sg.commandNames),
-		actions:   service.DefaultActions(sg.api, admin),
-		adminChan: channel}
-	sg.api.PostMessage(cmd.ChannelID,
-		slack.MsgOptionText("Queue created for channel.", false))
+	qs := service.TS(sg.userLookup(cmd.TeamID), sg.queuePersister(cmd.TeamID, cmd.ChannelID))
+	srv := sg.makeServer(client, cmd.TeamID, cmd.ChannelID, qs, channel)
+	sg.servers[serverKey{cmd.TeamID, cmd.ChannelID}] = srv
+	api.PostMessage(cmd.ChannelID,
+		slack.MsgOptionText(service.Message(locale, service.MsgQueueCreated, nil), false))
 	sg.Persist()
+	sg.goBackground(func() { sg.refreshPanel(srv, true) })
 }
 
-func (sg *ServerGroup) rm(cmd *slack.SlashCommand, action string) {
+func (sg *ServerGroup) rm(api *slack.Client, cmd *slack.SlashCommand, locale string) {
 	sg.Lock()
 	defer sg.Unlock()
 
 	// Check if it already exists.
-	_, ok := sg.servers[cmd.ChannelID]
+	key, ok := findKey(sg.servers, cmd.TeamID, cmd.ChannelID)
 
 	if ok {
-		delete(sg.servers, cmd.ChannelID)
+		srv := sg.servers[key]
+		srv.service.ExpireWaiting()
+		sg.goBackground(func() {
+			srv.removePanel()
+			srv.join.remove(srv.api, srv.channel)
+		})
+		delete(sg.servers, key)
 		sg.Persist()
-		sg.api.PostMessage(cmd.ChannelID,
-			slack.MsgOptionText("Deleted this channel's queue.", false))
+		api.PostMessage(cmd.ChannelID,
+			slack.MsgOptionText(service.Message(locale, service.MsgQueueDeleted, nil), false))
 	} else {
-		sg.api.PostMessage(cmd.ChannelID,
-			slack.MsgOptionText("No queue exists in this channel.", false),
-			slack.MsgOptionPostEphemeral(cmd.UserID))
+		reply(api, cmd, service.Message(locale, service.MsgNoQueue, nil))
 	}
 }
 
-func (sg *ServerGroup) Manage(cmd *slack.SlashCommand, w http.ResponseWriter) {
-	// Check permission
-	user := &slack.User{ID: cmd.UserID, Name: cmd.UserName, TeamID: cmd.TeamID}
-	ok, err := sg.admin.IsAdmin(user)
-	if err != nil {
-		glog.Errorf("Error checking admin status of %v: %v", user.ID, err)
-		w.WriteHeader(http.StatusInternalServerError)
+// Replies to a queue command in a channel without a queue, in the user's
+// locale.
+func (sg *ServerGroup) ReplyNoQueue(cmd *slack.SlashCommand, w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+	api, ok := sg.teams.Client(cmd.TeamID)
+	if !ok {
 		return
 	}
+	reply(api, cmd, service.Message(sg.locale(api, cmd, nil), service.MsgNoQueueFor, service.Args{"Channel": cmd.ChannelName, "Command": sg.command}))
+}
+
+func (sg *ServerGroup) Manage(ctx context.Context, cmd *slack.SlashCommand, w http.ResponseWriter) {
+	client, ok := sg.teams.SlackClient(cmd.TeamID)
 	if !ok {
-		glog.Errorf("Permission denied for user %v (%v)", user.ID, user.Name)
-		w.WriteHeader(http.StatusUnauthorized)
+		err := service.Errorf(service.ErrUpstream, "no installation for team %v", cmd.TeamID)
+		service.ReplyCommandError(ctx, w, cmd, service.DefaultLocale, err)
+		return
+	}
+	api := client.Client
+
+	action, channel, perr := parseCommand(cmd.Text)
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).With(logging.Action, action))
+
+	if action == MeString {
+		// Anyone may manage their own profile.
+		w.WriteHeader(http.StatusOK)
+		sg.me(api, cmd, sg.locale(api, cmd, nil), channel)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 
-	action, channel, err := parseCommand(cmd.Text)
+	// Check permission. Queue settings may be managed by those the queue's
+	// policy allows to, everything else is restricted to global admins.
+	srv, found := sg.Lookup(cmd.TeamID, cmd.ChannelID)
+	if !found {
+		srv = nil
+	}
+	user := &slack.User{ID: cmd.UserID, Name: cmd.UserName, TeamID: cmd.TeamID}
+	var err error
+	if queueCommands[action] && found {
+		op, ok := queueCommandOps[action]
+		if !ok {
+			op = service.OpManage
+		}
+		err = srv.auth.Check(srv.service, op, user)
+	} else {
+		err = service.CheckAdmin(sg.admin(cmd.TeamID, client), user)
+	}
 	if err != nil {
-		sg.usage(cmd, w)
+		service.ReplyCommandError(ctx, w, cmd, sg.locale(api, cmd, srv), err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	locale := sg.locale(api, cmd, srv)
+
+	if perr != nil {
+		sg.usage(api, cmd, locale)
+	}
+	if queueCommands[action] && !found {
+		reply(api, cmd, service.Message(locale, service.MsgNoQueue, nil))
+		return
 	}
 
-	glog.Infof("Processing request %v %v", action, channel)
+	logging.FromContext(ctx).Infof("Processing management command, arguments %v", logging.Body(channel))
 
 	// Handle creation
 	switch action {
 	case CreateString:
-		sg.add(cmd, action, channel)
+		sg.add(client, cmd, locale, channel)
 	case DeleteString:
-		sg.rm(cmd, action)
+		sg.rm(api, cmd, locale)
+	case PostString:
+		sg.postJoin(srv)
+	case FormString:
+		sg.setForm(api, cmd, locale, srv, channel)
+	case EscalateString:
+		sg.setEscalation(api, cmd, locale, srv, channel)
+	case LocaleString:
+		sg.setLocale(api, cmd, locale, srv, channel)
+	case MessageString:
+		sg.setMessage(api, cmd, locale, srv, channel)
+	case RoleString:
+		sg.setRole(api, cmd, locale, srv, channel)
+	case AllowString:
+		sg.setGrant(api, cmd, locale, srv, channel)
+	case HistoryString:
+		sg.showHistory(api, cmd, locale, srv, channel)
+	case StatsString:
+		sg.showStats(api, cmd, locale, srv, channel)
+	case ExportString:
+		sg.export(api, cmd, locale, srv, channel)
 	default:
-		sg.usage(cmd, w)
+		sg.usage(api, cmd, locale)
 	}
 }
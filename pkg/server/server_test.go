@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestManageWithoutInstallation(t *testing.T) {
+	sg := testGroup("C1")
+	w := httptest.NewRecorder()
+	sg.Manage(context.Background(), &slack.SlashCommand{TeamID: "T2", ChannelID: "C1", UserID: "U1", Text: "post"}, w)
+	if w.Code != 200 {
+		t.Fatalf("Status %d, want 200.", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "ephemeral") || !strings.Contains(body, "Slack didn't respond") {
+		t.Fatalf("Unexpected reply %q.", body)
+	}
+}
+
+// Serves each team's channel named "tas", with the team's admin as its only
+// member, telling teams apart by their tokens.
+func teamsAPI() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		team := strings.TrimPrefix(r.FormValue("token"), "xoxb-")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/conversations.list":
+			fmt.Fprintf(w, `{"ok":true,"channels":[{"id":"C%s","name":"tas"}]}`, team)
+		case "/conversations.members":
+			fmt.Fprintf(w, `{"ok":true,"members":["A%s"]}`, strings.TrimPrefix(r.FormValue("channel"), "C"))
+		default:
+			w.Write([]byte(`{"ok":false,"error":"unknown_method"}`))
+		}
+	}))
+}
+
+func TestGlobalAdminsPerTeam(t *testing.T) {
+	api := teamsAPI()
+	defer api.Close()
+	sg := testGroup()
+	sg.authChannel = "tas"
+	for _, team := range []string{"T1", "T2"} {
+		c := service.NewSlackClient("xoxb-"+team, service.DefaultRetryPolicy, nil, slack.OptionAPIURL(api.URL+"/"))
+		defer c.Close()
+		sg.teams.clients[team] = c
+	}
+
+	for _, team := range []string{"T1", "T2"} {
+		c, _ := sg.teams.SlackClient(team)
+		admin := sg.admin(team, c)
+		if ok, err := admin.IsAdmin(&slack.User{ID: "A" + team}); !ok || err != nil {
+			t.Fatalf("Admin of %v not recognized: %v", team, err)
+		}
+		other := map[string]string{"T1": "AT2", "T2": "AT1"}[team]
+		if ok, _ := admin.IsAdmin(&slack.User{ID: other}); ok {
+			t.Fatalf("Admin of another team recognized as admin of %v", team)
+		}
+	}
+}
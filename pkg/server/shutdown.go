@@ -0,0 +1,149 @@
+package server
+
+import (
+	"github.com/golang/glog"
+
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long in-flight requests may run once shutdown begins.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// Requests were still in flight when the shutdown deadline passed.
+type DrainError struct {
+	InFlight int64
+}
+
+func (e DrainError) Error() string {
+	return fmt.Sprintf("%d requests still in flight at shutdown deadline", e.InFlight)
+}
+
+// Serves hs on l until stop is closed, then stops accepting requests and
+// waits up to timeout for those in flight to finish. Returns nil once they
+// have, a DrainError if they haven't, or the error that stopped the server.
+func Serve(hs *http.Server, l net.Listener, stop <-chan struct{}, timeout time.Duration) error {
+	handler := hs.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	var inflight int64
+	hs.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		handler.ServeHTTP(w, r)
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- hs.Serve(l)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	glog.Infof("Draining %d in-flight requests (deadline %v)...", atomic.LoadInt64(&inflight), timeout)
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		n := atomic.LoadInt64(&inflight)
+		glog.Errorf("Drain incomplete after %v, %d requests in flight: %v", time.Since(start), n, err)
+		return DrainError{n}
+	}
+	glog.Infof("Drained in-flight requests in %v.", time.Since(start))
+	return nil
+}
+
+// Work started in the background, e.g., refreshing messages after a queue
+// changes, which shutdown waits for before flushing state.
+type backgroundWork struct {
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Registers work about to start; call done once it finishes. Returns false,
+// and the work mustn't start, once shutdown has begun waiting.
+func (b *backgroundWork) add() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return false
+	}
+	b.wg.Add(1)
+	return true
+}
+
+func (b *backgroundWork) done() {
+	b.wg.Done()
+}
+
+// Stops accepting work and waits up to timeout for work in progress. Returns
+// whether it finished.
+func (b *backgroundWork) wait(timeout time.Duration) bool {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Runs f in the background, unless the group is shutting down.
+func (sg *ServerGroup) goBackground(f func()) {
+	if !sg.background.add() {
+		glog.Warningf("Shutting down, not starting background work.")
+		return
+	}
+	go func() {
+		defer sg.background.done()
+		f()
+	}()
+}
+
+// Stops starting background work, including escalation checks, and waits up
+// to timeout for work in progress to finish. Called on shutdown, once
+// requests are drained and before Flush, so that nothing changes state or
+// queues admin messages while it's persisted and the clients are closed.
+func (sg *ServerGroup) DrainBackground(timeout time.Duration) bool {
+	glog.Infof("Waiting for background work (deadline %v)...", timeout)
+	start := time.Now()
+	if !sg.background.wait(timeout) {
+		glog.Errorf("Background work still running after %v.", timeout)
+		return false
+	}
+	glog.Infof("Background work finished in %v.", time.Since(start))
+	return true
+}
+
+// Persists every queue, served or archived, and the server list. Called on
+// shutdown, once no requests or background work are in flight.
+func (sg *ServerGroup) Flush() {
+	sg.Lock()
+	defer sg.Unlock()
+	glog.Infof("Flushing %d queues...", len(sg.servers)+len(sg.archived))
+	for _, srv := range sg.servers {
+		srv.service.Persist()
+	}
+	for _, srv := range sg.archived {
+		srv.service.Persist()
+	}
+	sg.Persist()
+	glog.Infof("Flushed state.")
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Serves a handler that blocks until release is closed.
+func blockingServer(t *testing.T, release chan struct{}, stop chan struct{}, timeout time.Duration) (url string, started chan struct{}, served chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started = make(chan struct{})
+	hs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})}
+	served = make(chan error, 1)
+	go func() {
+		served <- Serve(hs, l, stop, timeout)
+	}()
+	return "http://" + l.Addr().String(), started, served
+}
+
+func TestServeDrains(t *testing.T) {
+	release := make(chan struct{})
+	stop := make(chan struct{})
+	url, started, served := blockingServer(t, release, stop, time.Second)
+
+	resp := make(chan *http.Response, 1)
+	go func() {
+		r, err := http.Get(url)
+		if err != nil {
+			t.Errorf("Error from in-flight request: %v", err)
+		}
+		resp <- r
+	}()
+	<-started
+	close(stop)
+	select {
+	case err := <-served:
+		t.Fatalf("Stopped serving with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Fatalf("Expected new requests to be refused while draining")
+	}
+	close(release)
+	if r := <-resp; r == nil || r.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the in-flight request to finish, got %+v", r)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Expected a clean drain, got %v", err)
+	}
+}
+
+func TestServeDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stop := make(chan struct{})
+	url, started, served := blockingServer(t, release, stop, 10*time.Millisecond)
+
+	go http.Get(url)
+	<-started
+	close(stop)
+	if err, ok := (<-served).(DrainError); !ok || err.InFlight != 1 {
+		t.Fatalf("Expected the drain to time out with a request in flight, got %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	persist := persister.FilePersister{Fn: filepath.Join(dir, "state")}
+
+	sg := testGroup()
+	sg.persist = persist
+	qp := queuePersister(persist, "T1", "C1")
+	qs := service.TS(staticUserLookup{}, qp)
+	sg.servers[serverKey{"T1", "C1"}] = &Server{team: "T1", channel: "C1", service: qs, admin: service.NoopAdminInterface{}}
+	qs.Enqueue(context.Background(), &service.EnqueueRequest{User: &slack.User{ID: "U1"}}, &service.EnqueueResponse{})
+	os.Remove(qp.Id())
+
+	sg.Flush()
+	queues := ReadQueues(persist)
+	if len(queues) != 1 || queues[0].ChannelID != "C1" || len(queues[0].Elements) != 1 || queues[0].Elements[0].Id != "U1" {
+		t.Fatalf("Expected the flushed queue, got %+v", queues)
+	}
+}
+
+func TestDrainBackground(t *testing.T) {
+	sg := testGroup()
+	release := make(chan struct{})
+	sg.goBackground(func() { <-release })
+	stop := make(chan struct{})
+	go sg.WatchEscalations(time.Hour, stop)
+	time.Sleep(10 * time.Millisecond)
+
+	if sg.DrainBackground(10 * time.Millisecond) {
+		t.Fatalf("Drained with background work running")
+	}
+	ran := make(chan struct{})
+	sg.goBackground(func() { close(ran) })
+	close(release)
+	if sg.DrainBackground(50 * time.Millisecond) {
+		t.Fatalf("Drained with the escalation watcher running")
+	}
+	close(stop)
+	if !sg.DrainBackground(time.Second) {
+		t.Fatalf("Background work didn't drain")
+	}
+	select {
+	case <-ran:
+		t.Fatalf("Background work started after shutdown began")
+	default:
+	}
+}
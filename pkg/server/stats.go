@@ -0,0 +1,100 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"fmt"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Posts the queue's stats to its admin channel: "stats today", "stats week",
+// or "stats 2021-03-01..2021-03-07".
+func (sg *ServerGroup) showStats(api *slack.Client, cmd *slack.SlashCommand, locale string, srv *Server, arg string) {
+	now := sg.userNow(cmd.TeamID, api, cmd.UserID)
+	since, until, p, err := parsePeriod(arg, now)
+	if err == nil && sg.history == nil {
+		err = fmt.Errorf("history is not recorded")
+	}
+	var entries []service.HistoryEntry
+	if err == nil {
+		entries, err = sg.history.Query(service.HistoryQuery{TeamID: srv.team, ChannelID: srv.channel, Since: since})
+	}
+	if err != nil {
+		glog.Errorf("Error computing stats for channel %v: %q: %v", cmd.ChannelID, arg, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgStatsUsage, service.Args{"Command": sg.command}))
+		return
+	}
+	desc := p.describe(srv.service, locale)
+	var waiting []queue.Element
+	if until.After(now) {
+		waiting, _ = srv.service.Snapshot()
+	}
+	blocks := service.StatsBlocks(srv.service, locale, srv.channel, desc, service.ComputeStats(entries, waiting, since, until))
+
+	channel, err := srv.adminChannelID()
+	if err != nil {
+		glog.Infof("No admin channel for stats of %v, replying to %v: %v", srv.channel, cmd.UserID, err)
+		api.PostMessage(cmd.ChannelID, slack.MsgOptionBlocks(blocks...), slack.MsgOptionPostEphemeral(cmd.UserID))
+		return
+	}
+	if _, _, err = api.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+		glog.Errorf("Error posting stats of %v to %v: %v", srv.channel, channel, err)
+		reply(api, cmd, srv.service.Msg(locale, service.MsgStatsPostFailed, nil))
+		return
+	}
+	if channel != cmd.ChannelID {
+		reply(api, cmd, srv.service.Msg(locale, service.MsgStatsPosted, service.Args{"Period": desc, "Channel": channel}))
+	}
+}
+
+// A period of history, described by a message.
+type period struct {
+	key  string
+	args service.Args
+}
+
+func (p period) describe(s *service.QueueService, locale string) string {
+	return s.Msg(locale, p.key, p.args)
+}
+
+// Parses a period ending now, or a range of days, into [since, until) and a
+// description of it.
+func parsePeriod(arg string, now time.Time) (since time.Time, until time.Time, p period, err error) {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	arg = strings.TrimSpace(arg)
+	switch arg {
+	case "", "today":
+		return today, now, period{service.MsgPeriodToday, nil}, nil
+	case "week":
+		return today.AddDate(0, 0, -6), now, period{service.MsgPeriodWeek, nil}, nil
+	}
+	parts := strings.SplitN(arg, "..", 2)
+	if since, err = time.ParseInLocation(dateLayout, parts[0], now.Location()); err != nil {
+		return
+	}
+	last := since
+	if len(parts) > 1 {
+		if last, err = time.ParseInLocation(dateLayout, parts[1], now.Location()); err != nil {
+			return
+		}
+	}
+	if last.Before(since) {
+		err = fmt.Errorf("range %q ends before it starts", arg)
+		return
+	}
+	until = last.AddDate(0, 0, 1)
+	if last.Equal(since) {
+		p = period{service.MsgPeriodDay, service.Args{"Day": parts[0]}}
+	} else {
+		p = period{service.MsgPeriodDays, service.Args{"From": since.Format(dateLayout), "To": last.Format(dateLayout)}}
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	now := time.Date(2021, 3, 4, 15, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		arg   string
+		since time.Time
+		until time.Time
+	}{
+		{"", day(4), now},
+		{"today", day(4), now},
+		{"week", day(4).AddDate(0, 0, -6), now},
+		{"2021-03-01", day(1), day(2)},
+		{"2021-03-01..2021-03-03", day(1), day(4)},
+	}
+	for _, test := range tests {
+		since, until, _, err := parsePeriod(test.arg, now)
+		if err != nil || !since.Equal(test.since) || !until.Equal(test.until) {
+			t.Fatalf("Expected %q to be %v to %v, got %v to %v (%v)", test.arg, test.since, test.until, since, until, err)
+		}
+	}
+	for _, arg := range []string{"month", "2021-03-03..2021-03-01", "2021-3-1"} {
+		if _, _, _, err := parsePeriod(arg, now); err == nil {
+			t.Fatalf("Expected error for %q", arg)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"sync"
+	"time"
+)
+
+// A workspace the app has been installed into.
+type Team struct {
+	ID          string `json:"ID"`
+	Name        string `json:"Name"`
+	BotUserID   string `json:"BotUserID"`
+	AccessToken string `json:"AccessToken"`
+}
+
+type TeamStoreState struct {
+	Teams []Team `json:"Teams"`
+}
+
+// Bot tokens and API clients for each installed workspace.
+//
+// The workspace of the fallback client, if there is one, uses it until it's
+// installed, so that a single-workspace deployment configured with a bot
+// token keeps working. So do queues created before multi-workspace support,
+// which have no team. Other teams must be installed.
+//
+// Thread safe.
+type TeamStore struct {
+	mu       sync.Mutex
+	teams    map[string]Team
+	clients  map[string]*service.SlackClient
+	fallback *service.SlackClient
+	fbTeam   string // team of the fallback client
+	metrics  *service.Metrics
+	persist  persister.Persister
+}
+
+// Clients of installed teams record their requests in m, if not nil.
+func MakeTeamStore(fallback *service.SlackClient, fallbackTeam string, m *service.Metrics, persist persister.Persister) *TeamStore {
+	return &TeamStore{
+		teams:    make(map[string]Team),
+		clients:  make(map[string]*service.SlackClient),
+		fallback: fallback,
+		fbTeam:   fallbackTeam,
+		metrics:  m,
+		persist:  persist}
+}
+
+// Returns the API client for a team.
+func (ts *TeamStore) Client(teamID string) (api *slack.Client, ok bool) {
+	c, ok := ts.SlackClient(teamID)
+	if ok {
+		api = c.Client
+	}
+	return
+}
+
+// Returns the combined stats of the API clients of installed teams, and the
+// fallback client.
+func (ts *TeamStore) Stats() (stats service.ClientStats) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, c := range ts.clients {
+		stats = stats.Add(c.Stats())
+	}
+	if ts.fallback != nil {
+		stats = stats.Add(ts.fallback.Stats())
+	}
+	return
+}
+
+// Returns the API client for a team, with its retry and background message
+// handling.
+func (ts *TeamStore) SlackClient(teamID string) (c *service.SlackClient, ok bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	c, ok = ts.clients[teamID]
+	if !ok && ts.fallback != nil && (teamID == ts.fbTeam || teamID == "") {
+		c, ok = ts.fallback, true
+	}
+	return
+}
+
+// Stores the token for a newly installed (or reinstalled) team.
+func (ts *TeamStore) Install(team Team) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	glog.Infof("Installing for team %v (%v)", team.ID, team.Name)
+	ts.teams[team.ID] = team
+	if c, ok := ts.clients[team.ID]; ok {
+		c.Close()
+	}
+	ts.clients[team.ID] = service.NewSlackClient(team.AccessToken, service.DefaultRetryPolicy, ts.metrics)
+	ts.persistLocked()
+}
+
+// Forgets the token of an uninstalled team.
+func (ts *TeamStore) Remove(teamID string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.teams[teamID]; !ok {
+		return
+	}
+	glog.Infof("Removing installation for team %v", teamID)
+	delete(ts.teams, teamID)
+	if c, ok := ts.clients[teamID]; ok {
+		c.Close()
+	}
+	delete(ts.clients, teamID)
+	ts.persistLocked()
+}
+
+// Closes every client, e.g., on shutdown, and waits up to timeout for their
+// queued background messages to be sent.
+func (ts *TeamStore) Close(timeout time.Duration) {
+	ts.mu.Lock()
+	clients := make([]*service.SlackClient, 0, len(ts.clients)+1)
+	for _, c := range ts.clients {
+		clients = append(clients, c)
+	}
+	if ts.fallback != nil {
+		clients = append(clients, ts.fallback)
+	}
+	ts.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for _, c := range clients {
+		c.Close()
+	}
+	for _, c := range clients {
+		if !c.Wait(time.Until(deadline)) {
+			glog.Errorf("Timed out sending queued admin messages after %v", timeout)
+		}
+	}
+}
+
+// Must hold lock.
+func (ts *TeamStore) persistLocked() {
+	if ts.persist == nil {
+		return
+	}
+	state := TeamStoreState{}
+	for _, team := range ts.teams {
+		state.Teams = append(state.Teams, team)
+	}
+	if err := ts.persist.Write(state); err != nil {
+		glog.Errorf("Error persisting teams: %v", err)
+	}
+}
+
+func (ts *TeamStore) Recover() {
+	if ts.persist == nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	state := TeamStoreState{}
+	ts.persist.Read(&state)
+	for _, team := range state.Teams {
+		ts.teams[team.ID] = team
+		ts.clients[team.ID] = service.NewSlackClient(team.AccessToken, service.DefaultRetryPolicy, ts.metrics)
+	}
+	glog.Infof("Recovered %d teams.", len(state.Teams))
+}
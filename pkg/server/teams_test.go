@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/ml8/slack-queue/pkg/service"
+
+	"testing"
+)
+
+func TestTeamStoreClients(t *testing.T) {
+	ts := MakeTeamStore(nil, "", nil, nil)
+	if _, ok := ts.Client("T1"); ok {
+		t.Fatal("Client returned for team that was never installed.")
+	}
+	ts.Install(Team{ID: "T1", AccessToken: "xoxb-1"})
+	if _, ok := ts.Client("T1"); !ok {
+		t.Fatal("No client for installed team.")
+	}
+	ts.Remove("T1")
+	if _, ok := ts.Client("T1"); ok {
+		t.Fatal("Client returned for removed team.")
+	}
+}
+
+func TestTeamStoreFallback(t *testing.T) {
+	fallback := service.NewSlackClient("xoxb-fallback", service.DefaultRetryPolicy, nil)
+	defer fallback.Close()
+	ts := MakeTeamStore(fallback, "T2", nil, nil)
+	ts.Install(Team{ID: "T1", AccessToken: "xoxb-1"})
+
+	if api, _ := ts.Client("T1"); api == fallback.Client {
+		t.Fatal("Installed team uses fallback client.")
+	}
+	if api, ok := ts.Client("T2"); !ok || api != fallback.Client {
+		t.Fatal("Fallback client's team does not use it.")
+	}
+	if api, ok := ts.Client(""); !ok || api != fallback.Client {
+		t.Fatal("Queue without a team does not use fallback client.")
+	}
+	if _, ok := ts.Client("T3"); ok {
+		t.Fatal("Client returned for unknown team.")
+	}
+}
+
+func TestLookupByTeam(t *testing.T) {
+	sg := testGroup("C1")
+	if _, ok := sg.Lookup("T2", "C1"); ok {
+		t.Fatal("Found server for another team's channel.")
+	}
+
+	// Servers created before multi-workspace support have no team.
+	sg.servers[serverKey{"", "C2"}] = &Server{admin: service.NoopAdminInterface{}}
+	if _, ok := sg.Lookup("T1", "C2"); !ok {
+		t.Fatal("Legacy server not found.")
+	}
+}
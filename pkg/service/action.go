@@ -3,26 +3,37 @@ package service
 import (
 	"github.com/slack-go/slack"
 
+	"context"
 	"net/http"
 )
 
 const (
 	// TODO make flags or make a config file.
-	takeActionName   = "take"
-	removeActionName = "remove"
-	upActionName     = "up"
-	downActionName   = "down"
+	takeActionName     = "take"
+	removeActionName   = "remove"
+	upActionName       = "up"
+	downActionName     = "down"
+	leaveActionName    = "leave"
+	joinActionName     = "join"
+	positionActionName = "position"
+	notifyActionName   = "notify"
 )
 
 // TODO(#20): There is a ton of duplicate code between the dequeue action and command and
 // the remove and dequeue actions. This should be refactored.
 
-func DefaultActions(api *slack.Client, perms AdminInterface) (actions map[string]Action) {
+// Actions, checked against the queue's policy by auth.
+func DefaultActions(api *slack.Client, perms AdminInterface, auth *Authorizer, ul UserLookup, profiles *ProfileStore) (actions map[string]Action) {
 	actions = make(map[string]Action)
-	actions[removeActionName] = &RemoveAction{api, perms, &UserLookupImpl{api}}
-	actions[takeActionName] = &TakeAction{api, perms, &UserLookupImpl{api}}
-	actions[upActionName] = &MoveAction{api, perms}
-	actions[downActionName] = &MoveAction{api, perms}
+	actions[removeActionName] = auth.Action(OpRemove, &RemoveAction{api, perms, ul})
+	actions[takeActionName] = auth.Action(OpTake, &TakeAction{api, perms, ul, profiles})
+	actions[upActionName] = auth.Action(OpMove, &MoveAction{api, perms, ul})
+	actions[downActionName] = auth.Action(OpMove, &MoveAction{api, perms, ul})
+	actions[leaveActionName] = auth.Action(OpLeave, &LeaveAction{api, perms, ul})
+	actions[joinActionName] = auth.Action(OpJoin, &JoinAction{api, perms, ul})
+	actions[positionActionName] = auth.Action(OpPosition, &PositionAction{api, ul})
+	actions[notifyActionName] = auth.Action(OpNotify, &NotifyAction{api, ul})
+	actions[intakeCallbackID] = auth.Action(OpPut, &IntakeAction{api, perms, ul})
 	return
 }
 
@@ -31,7 +42,7 @@ func ParseAction(actionID string) string {
 }
 
 type Action interface {
-	Handle(action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter)
+	Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter)
 }
 
 type RemoveAction struct {
@@ -41,12 +52,43 @@ type RemoveAction struct {
 }
 
 type TakeAction struct {
+	api      *slack.Client
+	perms    AdminInterface
+	ul       UserLookup
+	profiles *ProfileStore
+}
+
+type MoveAction struct {
 	api   *slack.Client
 	perms AdminInterface
 	ul    UserLookup
 }
 
-type MoveAction struct {
+type LeaveAction struct {
+	api   *slack.Client
+	perms AdminInterface
+	ul    UserLookup
+}
+
+type JoinAction struct {
 	api   *slack.Client
 	perms AdminInterface
+	ul    UserLookup
+}
+
+type PositionAction struct {
+	api *slack.Client
+	ul  UserLookup
+}
+
+type NotifyAction struct {
+	api *slack.Client
+	ul  UserLookup
+}
+
+// Handles submission of the intake form modal.
+type IntakeAction struct {
+	api   *slack.Client
+	perms AdminInterface
+	ul    UserLookup
 }
@@ -4,6 +4,10 @@ import (
 	"github.com/golang/glog"
 	"github.com/slack-go/slack"
 
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,14 +16,56 @@ type AdminInterface interface {
 	SendAdminMessage(str string) (err error)
 }
 
-func AdminInterfaceFromChannel(api *slack.Client, channel string) AdminInterface {
+// Implemented by AdminInterfaces whose admin channel is named, so that the
+// name can be kept current from workspace events.
+type RenamableAdminChannel interface {
+	// Updates the admin channel name if channelID is the admin channel.
+	Rename(channelID string, name string) (ok bool)
+}
+
+// Implemented by AdminInterfaces that have an admin channel.
+type AdminChannel interface {
+	AdminChannelID() (id string, err error)
+}
+
+// Returns the admins described by channel: the members of a channel, given
+// by name or ID, or of a user group, e.g., "<!subteam^S123|@tas>", optionally
+// followed by the channel admin messages are sent to, e.g.,
+// "<!subteam^S123|@tas>,<#C123|tas>". Membership is cached in members.
+func AdminInterfaceFromChannel(api *SlackClient, members *MembershipCache, channel string) AdminInterface {
 	if channel == "" {
 		return NoopAdminInterface{}
-	} else {
-		return MakeChannelAdminInterface(api, channel)
 	}
+	parts := strings.SplitN(channel, ",", 2)
+	if group, ok := parseUsergroupID(parts[0]); ok {
+		var msgChan string
+		if len(parts) > 1 {
+			msgChan, _ = parseChannelID(parts[1])
+		}
+		return MakeUsergroupAdminInterface(api, members, group, msgChan)
+	}
+	return MakeChannelAdminInterface(api, members, channel)
 }
 
+// Parses "<!subteam^S123|@tas>" or "S123".
+func parseUsergroupID(s string) (id string, ok bool) {
+	if strings.HasPrefix(s, "<!subteam^") {
+		return strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(s, "<!subteam^"), ">"), "|", 2)[0], true
+	}
+	return s, slackIDPattern.MatchString(s) && s[0] == 'S'
+}
+
+// Parses "<#C123|name>" or "C123"; channel names are lowercase, so can't be
+// mistaken for IDs.
+func parseChannelID(s string) (id string, ok bool) {
+	if strings.HasPrefix(s, "<#") {
+		return strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(s, "<#"), ">"), "|", 2)[0], true
+	}
+	return s, slackIDPattern.MatchString(s) && (s[0] == 'C' || s[0] == 'G')
+}
+
+var slackIDPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{6,}$`)
+
 type NoopAdminInterface struct {
 }
 
@@ -31,21 +77,45 @@ func (a NoopAdminInterface) SendAdminMessage(str string) (err error) {
 	return
 }
 
-const maxChannelCacheAge = "1h"
-const maxRetries = 10
+// Failed fetches are retried after a backoff of up to maxRetryBackoff;
+// meanwhile, the last known membership is used.
+const (
+	minRetryBackoff = 5 * time.Second
+	maxRetryBackoff = 5 * time.Minute
+)
+
+func retryBackoff(retries int) time.Duration {
+	d := minRetryBackoff
+	for i := 1; i < retries && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d
+}
 
+// Admins are the members of a channel.
+//
+// Thread safe.
 type ChannelAdminInterface struct {
-	adminChan       string
-	api             *slack.Client
-	chanId          string
-	stale           bool
-	users           []string
-	lastRefreshTime time.Time
-	retries         int
+	api     *SlackClient
+	members *MembershipCache
+
+	mu        sync.Mutex
+	adminChan string
+	chanId    string
+	byID      bool // adminChan is the channel's ID
 }
 
-func MakeChannelAdminInterface(api *slack.Client, adminChan string) AdminInterface {
-	return &ChannelAdminInterface{api: api, adminChan: adminChan, stale: true}
+// Admins are the members of adminChan, given by name or ID.
+func MakeChannelAdminInterface(api *SlackClient, members *MembershipCache, adminChan string) AdminInterface {
+	p := &ChannelAdminInterface{api: api, members: members, adminChan: adminChan}
+	if id, ok := parseChannelID(adminChan); ok {
+		p.chanId = id
+		p.byID = true
+	}
+	return p
 }
 
 // TODO refactor into generic function to handle paginated functions (doesn't
@@ -90,70 +160,79 @@ func getUsersInChannel(api *slack.Client, id string) (users []string, err error)
 	return
 }
 
-func (p *ChannelAdminInterface) maybeRefresh() (err error) {
-	if p.retries > maxRetries {
-		glog.Fatalf("Could not retrieve admin users; failing.")
-	}
-	maxAge, _ := time.ParseDuration(maxChannelCacheAge)
-	age := time.Now().Sub(p.lastRefreshTime)
-	stale := p.stale || age > maxAge
-	if !stale {
-		glog.V(1).Infof("Not refreshing... refreshed at %v", age)
-		return
-	}
-
-	channels, err := getChannels(p.api)
+// Finds a channel by name; returns its ID as a list of one, to be cached
+// alongside memberships.
+func findChannel(api *slack.Client, name string) (ids []string, err error) {
+	channels, err := getChannels(api)
 	if err != nil {
-		p.retries++
-		glog.Errorf("Error retrieving channels: %v", err)
 		return
 	}
 	glog.V(2).Infof("Got %d channels", len(channels))
 	for _, channel := range channels {
 		glog.V(2).Infof("Channel: %v", channel.Name)
-		if channel.Name == p.adminChan {
-			p.chanId = channel.ID
-			p.users, err = getUsersInChannel(p.api, channel.ID)
-			if err != nil {
-				glog.Errorf("Error retrieving users in channel %v: %v", channel.Name, err)
-				p.retries++
-				return
-			}
-			p.stale = false
-			p.lastRefreshTime = time.Now()
-			p.retries = 0
-			return
+		if channel.Name == name {
+			return []string{channel.ID}, nil
 		}
 	}
-	glog.Errorf("Could not find admin channel. Retrying.")
-	p.retries++
+	err = fmt.Errorf("admin channel %v not found", name)
+	return
+}
+
+func (p *ChannelAdminInterface) channelID() (id string, err error) {
+	p.mu.Lock()
+	name, id := p.adminChan, p.chanId
+	p.mu.Unlock()
+	if id != "" {
+		return
+	}
+	ids, err := p.members.Members(channelNameKey(name), func() ([]string, error) {
+		return findChannel(p.api.Client, name)
+	})
+	if err != nil {
+		return
+	}
+	id = ids[0]
+	p.mu.Lock()
+	if p.adminChan == name {
+		p.chanId = id
+	}
+	p.mu.Unlock()
 	return
 }
 
 func (p *ChannelAdminInterface) IsAdmin(user *slack.User) (ok bool, err error) {
-	ok = false
-	err = p.maybeRefresh()
+	id, err := p.channelID()
 	if err != nil {
 		return
 	}
-	glog.V(2).Infof("Channel members: %v", p.users)
-	for _, id := range p.users {
-		glog.V(2).Infof("Member: %v, User: %v", id, user.ID)
-		if id == user.ID {
-			ok = true
-			return
-		}
+	return p.members.Contains(ChannelMembersKey(id), user.ID, func() ([]string, error) {
+		return getUsersInChannel(p.api.Client, id)
+	})
+}
+
+func (p *ChannelAdminInterface) AdminChannelID() (id string, err error) {
+	return p.channelID()
+}
+
+func (p *ChannelAdminInterface) Rename(channelID string, name string) (ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if channelID != p.chanId || p.byID {
+		return
 	}
+	glog.Infof("Admin channel %v renamed to %v", p.adminChan, name)
+	p.adminChan = name
+	ok = true
 	return
 }
 
+// Queues the message behind responses to users.
 func (p *ChannelAdminInterface) SendAdminMessage(msg string) (err error) {
-	err = p.maybeRefresh()
+	id, err := p.channelID()
 	if err != nil {
 		return
 	}
-	_, _, err = p.api.PostMessage(p.chanId,
+	return p.api.PostBackground(id,
 		slack.MsgOptionText(msg, false),
 		slack.MsgOptionAsUser(true))
-	return
 }
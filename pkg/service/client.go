@@ -0,0 +1,449 @@
+package service
+
+import (
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How Slack API requests are retried.
+//
+// Requests to API methods that may not be idempotent (e.g., chat.postMessage)
+// are retried only when rate limited or when the connection failed before the
+// request was sent. Reads (e.g., users.info) are also retried on server errors
+// and timeouts.
+type RetryPolicy struct {
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Retry-After longer than this fails the request rather than waiting.
+	MaxRetryAfter time.Duration
+	// How long requests made for users may spend waiting to retry, so they
+	// can still be answered within Slack's 3 second window. Background
+	// requests wait as long as the rest of the policy allows.
+	MaxUrgentWait time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:    3,
+	MinBackoff:    500 * time.Millisecond,
+	MaxBackoff:    10 * time.Second,
+	MaxRetryAfter: 30 * time.Second,
+	MaxUrgentWait: 1500 * time.Millisecond,
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Admin messages waiting to be sent; more are dropped.
+const backgroundQueueSize = 100
+
+var (
+	ErrDropped = errors.New("message dropped, too many queued")
+	ErrClosed  = errors.New("message dropped, client closed")
+)
+
+// Counters of a SlackClient.
+type ClientStats struct {
+	Requests    int64 // API requests, including retries
+	Retries     int64
+	RateLimited int64 // responses with status 429
+	Failures    int64 // requests that failed after any retries
+	Queued      int64 // admin messages queued
+	Sent        int64 // admin messages sent
+	Dropped     int64 // admin messages dropped because the queue was full or sending failed
+}
+
+func (s ClientStats) Add(o ClientStats) ClientStats {
+	return ClientStats{
+		Requests:    s.Requests + o.Requests,
+		Retries:     s.Retries + o.Retries,
+		RateLimited: s.RateLimited + o.RateLimited,
+		Failures:    s.Failures + o.Failures,
+		Queued:      s.Queued + o.Queued,
+		Sent:        s.Sent + o.Sent,
+		Dropped:     s.Dropped + o.Dropped,
+	}
+}
+
+// A Slack API client that retries rate limited and transiently failed
+// requests, honoring Retry-After, and sends admin messages in the background,
+// behind requests made in response to users.
+//
+// Thread safe.
+type SlackClient struct {
+	*slack.Client
+	http     *retryingHTTPClient
+	queue    chan backgroundMessage
+	finished chan struct{} // closed once queued messages are sent after Close
+	stats    *ClientStats
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type backgroundMessage struct {
+	channel string
+	options []slack.MsgOption
+}
+
+// Requests are recorded in m, if not nil.
+func NewSlackClient(token string, policy RetryPolicy, m *Metrics, options ...slack.Option) *SlackClient {
+	stats := &ClientStats{}
+	h := &retryingHTTPClient{client: &http.Client{Timeout: 30 * time.Second}, policy: policy, stats: stats, metrics: m, idle: closedChan()}
+	c := &SlackClient{
+		Client:   slack.New(token, append(options, slack.OptionHTTPClient(h))...),
+		http:     h,
+		queue:    make(chan backgroundMessage, backgroundQueueSize),
+		finished: make(chan struct{}),
+		stats:    stats}
+	go c.drain()
+	return c
+}
+
+// Queues a message to be sent once no requests for users are in flight or
+// rate limited.
+func (c *SlackClient) PostBackground(channel string, options ...slack.MsgOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		atomic.AddInt64(&c.stats.Dropped, 1)
+		glog.Errorf("Dropping message to %v: %v", channel, ErrClosed)
+		return ErrClosed
+	}
+	select {
+	case c.queue <- backgroundMessage{channel, options}:
+		atomic.AddInt64(&c.stats.Queued, 1)
+		return nil
+	default:
+		atomic.AddInt64(&c.stats.Dropped, 1)
+		glog.Errorf("Dropping message to %v: %v", channel, ErrDropped)
+		return ErrDropped
+	}
+}
+
+func (c *SlackClient) drain() {
+	defer close(c.finished)
+	for m := range c.queue {
+		c.http.waitIdle()
+		_, _, err := c.PostMessageContext(withBackground(context.Background()), m.channel, m.options...)
+		if err != nil {
+			atomic.AddInt64(&c.stats.Dropped, 1)
+			glog.Errorf("Error sending message to %v: %v", m.channel, err)
+			continue
+		}
+		atomic.AddInt64(&c.stats.Sent, 1)
+	}
+}
+
+// Stops accepting background messages. Those already queued are still sent;
+// Wait for them to be.
+func (c *SlackClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+}
+
+// Waits up to timeout for the messages queued before Close to be sent.
+// Returns whether they were.
+func (c *SlackClient) Wait(timeout time.Duration) bool {
+	select {
+	case <-c.finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (c *SlackClient) Stats() ClientStats {
+	return ClientStats{
+		Requests:    atomic.LoadInt64(&c.stats.Requests),
+		Retries:     atomic.LoadInt64(&c.stats.Retries),
+		RateLimited: atomic.LoadInt64(&c.stats.RateLimited),
+		Failures:    atomic.LoadInt64(&c.stats.Failures),
+		Queued:      atomic.LoadInt64(&c.stats.Queued),
+		Sent:        atomic.LoadInt64(&c.stats.Sent),
+		Dropped:     atomic.LoadInt64(&c.stats.Dropped),
+	}
+}
+
+type backgroundKey struct{}
+
+// Marks requests made with ctx as not urgent.
+func withBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+func isBackground(ctx context.Context) bool {
+	b, _ := ctx.Value(backgroundKey{}).(bool)
+	return b
+}
+
+// Retries requests per policy. A rate limited response holds back all
+// requests until its Retry-After has passed.
+type retryingHTTPClient struct {
+	client  *http.Client
+	policy  RetryPolicy
+	stats   *ClientStats
+	metrics *Metrics
+
+	mu     sync.Mutex
+	until  time.Time     // rate limited until
+	urgent int           // requests in flight for users
+	idle   chan struct{} // closed while urgent is 0
+}
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func (h *retryingHTTPClient) Do(req *http.Request) (resp *http.Response, err error) {
+	// How long this request may wait to be retried.
+	budget := h.policy.MaxRetryAfter * time.Duration(h.policy.MaxRetries+1)
+	if !isBackground(req.Context()) {
+		budget = h.policy.MaxUrgentWait
+		h.mu.Lock()
+		if h.urgent == 0 {
+			h.idle = make(chan struct{})
+		}
+		h.urgent++
+		h.mu.Unlock()
+		defer func() {
+			h.mu.Lock()
+			if h.urgent--; h.urgent == 0 {
+				close(h.idle)
+			}
+			h.mu.Unlock()
+		}()
+	}
+	deadline := time.Now().Add(budget)
+
+	var body []byte
+	if req.Body != nil {
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return
+		}
+		req.Body.Close()
+	}
+	for attempt := 0; ; attempt++ {
+		if err = h.waitRateLimit(req.Context(), deadline); err != nil {
+			return
+		}
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		var sent int32
+		trace := &httptrace.ClientTrace{WroteHeaders: func() { atomic.StoreInt32(&sent, 1) }}
+		atomic.AddInt64(&h.stats.Requests, 1)
+		start := time.Now()
+		resp, err = h.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		method := path.Base(req.URL.Path)
+		h.metrics.slackRequest(method, start, resp, err)
+		if err == nil {
+			if code := slackError(resp); code != "" {
+				h.metrics.slackError(method, code)
+			}
+		}
+		wait, retry := h.classify(req, resp, err, atomic.LoadInt32(&sent) == 1)
+		if wait == 0 {
+			wait = h.policy.backoff(attempt)
+		}
+		if retry && time.Now().Add(wait).After(deadline) {
+			retry = false
+		}
+		if !retry || attempt >= h.policy.MaxRetries || req.Context().Err() != nil {
+			if err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+				atomic.AddInt64(&h.stats.Failures, 1)
+			}
+			return
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		atomic.AddInt64(&h.stats.Retries, 1)
+		glog.V(1).Infof("Retrying %v in %v (attempt %d): %v", req.URL.Path, wait, attempt+1, describe(resp, err))
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// API methods that only read, so are safe to repeat. The client sends most
+// requests as POSTs, so the HTTP method doesn't tell.
+var readMethods = map[string]bool{
+	"auth.test":             true,
+	"bots.info":             true,
+	"chat.getPermalink":     true,
+	"conversations.history": true,
+	"conversations.info":    true,
+	"conversations.list":    true,
+	"conversations.members": true,
+	"conversations.replies": true,
+	"files.info":            true,
+	"pins.list":             true,
+	"team.info":             true,
+	"usergroups.list":       true,
+	"usergroups.users.list": true,
+	"users.getPresence":     true,
+	"users.info":            true,
+	"users.list":            true,
+	"users.lookupByEmail":   true,
+}
+
+// Whether a request should be retried, and, if Slack said, after how long.
+// Requests other than reads that may have reached Slack aren't retried, to
+// avoid, e.g., posting a message twice.
+func (h *retryingHTTPClient) classify(req *http.Request, resp *http.Response, err error, sent bool) (wait time.Duration, retry bool) {
+	idempotent := req.Method == http.MethodGet || readMethods[path.Base(req.URL.Path)]
+	if err != nil {
+		// Network errors, including timeouts.
+		return 0, req.Context().Err() == nil && (idempotent || !sent)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		atomic.AddInt64(&h.stats.RateLimited, 1)
+		secs, perr := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if perr != nil {
+			secs = 1
+		}
+		wait = time.Duration(secs) * time.Second
+		if wait > h.policy.MaxRetryAfter {
+			return wait, false
+		}
+		h.mu.Lock()
+		if until := time.Now().Add(wait); until.After(h.until) {
+			h.until = until
+		}
+		h.mu.Unlock()
+		return wait, true
+	case resp.StatusCode >= 500:
+		return 0, idempotent
+	}
+	return 0, false
+}
+
+// Result of a request, for metrics.
+func result(resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "ratelimited"
+	case resp.StatusCode >= 500:
+		return "server_error"
+	}
+	return "ok"
+}
+
+// The error of a response with "ok": false, e.g., "channel_not_found", or
+// empty. The body is left to be read again.
+func slackError(resp *http.Response) string {
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+	if err != nil {
+		return ""
+	}
+	var r struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &r) != nil || r.Ok {
+		return ""
+	}
+	if r.Error == "" {
+		return "unknown"
+	}
+	return r.Error
+}
+
+// Fails reads with err, or ends them if it's nil.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	if r.err == nil {
+		return 0, io.EOF
+	}
+	return 0, r.err
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// Waits until the client isn't rate limited, failing if that's after
+// deadline.
+func (h *retryingHTTPClient) waitRateLimit(ctx context.Context, deadline time.Time) error {
+	h.mu.Lock()
+	until := h.until
+	h.mu.Unlock()
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	if until.After(deadline) {
+		return &slack.RateLimitedError{RetryAfter: wait}
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Waits until no requests for users are in flight and the client isn't rate
+// limited.
+func (h *retryingHTTPClient) waitIdle() {
+	for {
+		h.mu.Lock()
+		idle := h.idle
+		h.mu.Unlock()
+		<-idle
+		h.mu.Lock()
+		wait := time.Until(h.until)
+		h.mu.Unlock()
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/slack-go/slack"
+
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxRetries:    2,
+	MinBackoff:    time.Millisecond,
+	MaxBackoff:    time.Millisecond,
+	MaxRetryAfter: time.Second,
+	MaxUrgentWait: time.Second,
+}
+
+// Serves the given statuses in order, then successes.
+type flakyAPI struct {
+	mu         sync.Mutex
+	statuses   []int
+	calls      int
+	retryAfter string
+	srv        *httptest.Server
+}
+
+func makeFlakyAPI(statuses ...int) *flakyAPI {
+	f := &flakyAPI{statuses: statuses, retryAfter: "0"}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls++
+		status := http.StatusOK
+		if len(f.statuses) > 0 {
+			status, f.statuses = f.statuses[0], f.statuses[1:]
+		}
+		retryAfter := f.retryAfter
+		f.mu.Unlock()
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ok":true,"channel":"C1","ts":"1.0001"}`))
+	}))
+	return f
+}
+
+func (f *flakyAPI) client(policy RetryPolicy) *SlackClient {
+	return NewSlackClient("xoxb-test", policy, nil, slack.OptionAPIURL(f.srv.URL+"/"))
+}
+
+func TestSlackClientRetries(t *testing.T) {
+	f := makeFlakyAPI(http.StatusTooManyRequests, http.StatusTooManyRequests)
+	defer f.srv.Close()
+	c := f.client(testRetryPolicy)
+	defer c.Close()
+
+	if _, _, err := c.PostMessage("C1", slack.MsgOptionText("hi", false)); err != nil {
+		t.Fatalf("Expected success after retries, got %v", err)
+	}
+	stats := c.Stats()
+	if f.calls != 3 || stats.Retries != 2 || stats.RateLimited != 2 || stats.Failures != 0 {
+		t.Fatalf("Unexpected calls %d, stats %+v", f.calls, stats)
+	}
+}
+
+func TestSlackClientCountsSlackErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+	}))
+	defer srv.Close()
+	m := NewMetrics(prometheus.NewRegistry(), metrics.MakeChannelLabeler(metrics.DefaultMaxChannels))
+	c := NewSlackClient("xoxb-test", testRetryPolicy, m, slack.OptionAPIURL(srv.URL+"/"))
+	defer c.Close()
+
+	// The error is still returned once the response has been inspected.
+	if _, _, err := c.PostMessage("C1", slack.MsgOptionText("hi", false)); err == nil || err.Error() != "channel_not_found" {
+		t.Fatalf("Expected channel_not_found, got %v", err)
+	}
+	if n := testutil.ToFloat64(m.slackErrors.WithLabelValues("chat.postMessage", "channel_not_found")); n != 1 {
+		t.Fatalf("Expected 1 counted error, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.slackRequests.WithLabelValues("chat.postMessage", "ok")); n != 1 {
+		t.Fatalf("Expected 1 counted request, got %v", n)
+	}
+}
+
+func TestSlackClientGivesUp(t *testing.T) {
+	f := makeFlakyAPI(http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+	defer f.srv.Close()
+	c := f.client(testRetryPolicy)
+	defer c.Close()
+
+	if _, _, err := c.PostMessage("C1", slack.MsgOptionText("hi", false)); err == nil {
+		t.Fatalf("Expected error after retries")
+	}
+	if stats := c.Stats(); f.calls != 3 || stats.Failures != 1 {
+		t.Fatalf("Unexpected calls %d, stats %+v", f.calls, stats)
+	}
+}
+
+func TestSlackClientRetriesIdempotent(t *testing.T) {
+	f := makeFlakyAPI(http.StatusBadGateway, http.StatusBadGateway)
+	defer f.srv.Close()
+	c := f.client(testRetryPolicy)
+	defer c.Close()
+
+	// Posts may have been accepted despite the error, so aren't retried.
+	if _, _, err := c.PostMessage("C1", slack.MsgOptionText("hi", false)); err == nil {
+		t.Fatalf("Expected the server error")
+	}
+	if f.calls != 1 {
+		t.Fatalf("Expected a post without retries, got %d calls", f.calls)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, f.srv.URL+"/users.info", nil)
+	resp, err := c.http.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK || f.calls != 3 {
+		t.Fatalf("Expected a retried get, got %v, %v after %d calls", resp, err, f.calls)
+	}
+}
+
+func TestSlackClientRetriesReadsSentAsPost(t *testing.T) {
+	f := makeFlakyAPI(http.StatusBadGateway, http.StatusBadGateway)
+	defer f.srv.Close()
+	c := f.client(testRetryPolicy)
+	defer c.Close()
+
+	// The client posts every request, but reads are still retried.
+	if _, err := c.GetUserInfo("U1"); err != nil || f.calls != 3 {
+		t.Fatalf("Expected users.info retried, got %v after %d calls", err, f.calls)
+	}
+}
+
+func TestSlackClientUrgentWait(t *testing.T) {
+	f := makeFlakyAPI(http.StatusTooManyRequests)
+	f.retryAfter = "2"
+	defer f.srv.Close()
+	policy := testRetryPolicy
+	policy.MaxRetryAfter = 5 * time.Second
+	c := f.client(policy)
+	defer c.Close()
+
+	start := time.Now()
+	_, _, err := c.PostMessage("C1", slack.MsgOptionText("hi", false))
+	if _, ok := err.(*slack.RateLimitedError); !ok || time.Since(start) > time.Second || f.calls != 1 {
+		t.Fatalf("Expected to give up on the rate limit, got %v after %v and %d calls", err, time.Since(start), f.calls)
+	}
+	// Later requests for users fail without waiting for the rate limit.
+	if _, _, err = c.PostMessage("C1", slack.MsgOptionText("hi", false)); err == nil || f.calls != 1 {
+		t.Fatalf("Expected to fail while rate limited, got %v after %d calls", err, f.calls)
+	}
+}
+
+func TestSlackClientBackground(t *testing.T) {
+	f := makeFlakyAPI()
+	defer f.srv.Close()
+	c := f.client(testRetryPolicy)
+	defer c.Close()
+
+	// Hold back background messages, as if rate limited.
+	c.http.mu.Lock()
+	c.http.until = time.Now().Add(time.Hour)
+	c.http.mu.Unlock()
+	var dropped int
+	for i := 0; i < backgroundQueueSize+2; i++ {
+		if c.PostBackground("C1", slack.MsgOptionText("hi", false)) == ErrDropped {
+			dropped++
+		}
+	}
+	// The drain may hold one message while it waits.
+	if stats := c.Stats(); dropped == 0 || stats.Dropped != int64(dropped) || f.calls != 0 {
+		t.Fatalf("Unexpected dropped %d, calls %d, stats %+v", dropped, f.calls, stats)
+	}
+
+	c2 := f.client(testRetryPolicy)
+	defer c2.Close()
+	c2.PostBackground("C1", slack.MsgOptionText("hi", false))
+	for i := 0; i < 100 && c2.Stats().Sent == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := c2.Stats(); stats.Sent != 1 {
+		t.Fatalf("Background message not sent: %+v", stats)
+	}
+}
+
+func TestSlackClientCloseSendsQueued(t *testing.T) {
+	f := makeFlakyAPI()
+	defer f.srv.Close()
+	c := f.client(testRetryPolicy)
+
+	for i := 0; i < 3; i++ {
+		c.PostBackground("C1", slack.MsgOptionText("hi", false))
+	}
+	c.Close()
+	if err := c.PostBackground("C1", slack.MsgOptionText("late", false)); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed after Close, got %v", err)
+	}
+	if !c.Wait(5 * time.Second) {
+		t.Fatalf("Timed out waiting for queued messages")
+	}
+	if stats := c.Stats(); stats.Sent != 3 {
+		t.Fatalf("Expected queued messages sent after Close, got %+v", stats)
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"github.com/slack-go/slack"
 
+	"context"
 	"net/http"
 )
 
@@ -12,21 +13,23 @@ type CommandNames struct {
 	List string
 }
 
-func DefaultCommands(api *slack.Client, perms AdminInterface, names CommandNames) (commands map[string]Command) {
+// Commands, checked against the queue's policy by auth.
+func DefaultCommands(api *slack.Client, perms AdminInterface, auth *Authorizer, ul UserLookup, profiles *ProfileStore, names CommandNames) (commands map[string]Command) {
 	commands = make(map[string]Command)
-	commands[names.Put] = &PutCommand{api, perms, &UserLookupImpl{api}}
-	commands[names.List] = &ListCommand{api, perms}
-	commands[names.Take] = &TakeCommand{api, perms, &UserLookupImpl{api}}
+	commands[names.Put] = auth.Command(OpPut, &PutCommand{api, perms, ul})
+	commands[names.List] = auth.Command(OpList, &ListCommand{api, perms, ul})
+	commands[names.Take] = auth.Command(OpTake, &TakeCommand{api, perms, ul, profiles})
 	return
 }
 
 type Command interface {
-	Handle(cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error)
+	Handle(ctx context.Context, cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error)
 }
 
 type ListCommand struct {
 	api   *slack.Client
 	perms AdminInterface
+	ul    UserLookup
 }
 
 type PutCommand struct {
@@ -36,7 +39,8 @@ type PutCommand struct {
 }
 
 type TakeCommand struct {
-	api   *slack.Client
-	perms AdminInterface
-	ul    UserLookup
+	api      *slack.Client
+	perms    AdminInterface
+	ul       UserLookup
+	profiles *ProfileStore
 }
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func GenerateActionValue(pos int, token int64) string {
@@ -37,9 +38,48 @@ func readFile(fn string) (content string) {
 }
 
 func userToLink(user *slack.User) string {
+	if user.Name == "" && user.RealName == "" {
+		// Couldn't be looked up.
+		return fmt.Sprintf("<@%s>", user.ID)
+	}
 	name := user.Name
 	if user.RealName != "" {
 		name = user.RealName
 	}
 	return fmt.Sprintf("<slack://user?id=%s&team=%s|%s>", user.ID, user.TeamID, name)
 }
+
+// Block ID for a block belonging to the queue of a channel.
+func QueueBlockID(channel string, id string) string {
+	if channel == "" {
+		return id
+	}
+	return channel + "/" + id
+}
+
+// Returns the channel of the queue an action's block belongs to, if the block
+// ID identifies one.
+func ActionChannel(action *slack.InteractionCallback) (channel string) {
+	if action.Type == slack.InteractionTypeViewSubmission {
+		return action.View.PrivateMetadata
+	}
+	for _, act := range action.ActionCallback.BlockActions {
+		if i := strings.Index(act.BlockID, "/"); i > 0 {
+			return act.BlockID[:i]
+		}
+	}
+	return
+}
+
+// Approximate duration for display to students.
+func formatWait(s *QueueService, locale string, d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	switch {
+	case minutes < 1:
+		return s.Msg(locale, MsgWaitUnder, nil)
+	case minutes == 1:
+		return s.Msg(locale, MsgWaitMinute, nil)
+	default:
+		return s.Msg(locale, MsgWaitMinutes, Args{"Minutes": minutes})
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Kinds of errors reported to users.
+type ErrorKind int
+
+const (
+	ErrInternal ErrorKind = iota
+	ErrPermissionDenied
+	ErrQueueEmpty
+	ErrStaleVersion
+	ErrNotFound
+	ErrUpstream // Slack API failure
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrInternal:         "internal",
+	ErrPermissionDenied: "permission denied",
+	ErrQueueEmpty:       "queue empty",
+	ErrStaleVersion:     "stale version",
+	ErrNotFound:         "not found",
+	ErrUpstream:         "upstream failure",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+var errorMessages = map[ErrorKind]string{
+	ErrInternal:         MsgErrInternal,
+	ErrPermissionDenied: MsgErrPermission,
+	ErrQueueEmpty:       MsgErrQueueEmpty,
+	ErrStaleVersion:     MsgErrStale,
+	ErrNotFound:         MsgErrNotFound,
+	ErrUpstream:         MsgErrUpstream,
+}
+
+// An error reported to the user who made a request. ID correlates the reply
+// with the log entry.
+type Error struct {
+	Kind ErrorKind
+	ID   string
+	Err  error
+}
+
+func NewError(kind ErrorKind, err error) *Error {
+	return &Error{Kind: kind, ID: correlationID(), Err: err}
+}
+
+func Errorf(kind ErrorKind, format string, args ...interface{}) *Error {
+	return NewError(kind, fmt.Errorf(format, args...))
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v [%s]: %v", e.Kind, e.ID, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reply to the user, in locale.
+func (e *Error) Message(locale string) string {
+	return Message(locale, errorMessages[e.Kind], Args{"ID": e.ID})
+}
+
+func correlationID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Classifies err, if it isn't already an *Error. Errors returned by the Slack
+// API are plain errors; callers should wrap them as ErrUpstream.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	var ve queue.VersionError
+	var rle *slack.RateLimitedError
+	var ne net.Error
+	var ue *url.Error
+	switch {
+	case errors.As(err, &ve):
+		return NewError(ErrStaleVersion, err)
+	case errors.As(err, &rle), errors.As(err, &ne), errors.As(err, &ue):
+		return NewError(ErrUpstream, err)
+	}
+	return NewError(ErrInternal, err)
+}
+
+func logError(ctx context.Context, e *Error, user string) {
+	l := logging.FromContext(ctx).With(logging.User, user, "error_id", e.ID)
+	if e.Kind == ErrInternal || e.Kind == ErrUpstream {
+		l.Errorf("Error: %v", e)
+	} else {
+		l.Infof("Error: %v", e)
+	}
+}
+
+type ephemeralReply struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// Replies to a slash command with an ephemeral error message.
+func ReplyCommandError(ctx context.Context, w http.ResponseWriter, cmd *slack.SlashCommand, locale string, err error) {
+	e := AsError(err)
+	logError(ctx, e, cmd.UserID)
+	b, jerr := json.Marshal(ephemeralReply{slack.ResponseTypeEphemeral, e.Message(locale)})
+	if jerr != nil {
+		glog.Errorf("Error marshalling error reply [%s]: %v", e.ID, jerr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Acknowledges an interaction and shows the user an ephemeral error message:
+// in reply to the message they interacted with, in the channel, or, e.g., for
+// the App Home, by DM.
+func ReplyActionError(ctx context.Context, w http.ResponseWriter, api *slack.Client, action *slack.InteractionCallback, locale string, err error) {
+	e := AsError(err)
+	logError(ctx, e, action.User.ID)
+	w.WriteHeader(http.StatusOK)
+
+	text := slack.MsgOptionText(e.Message(locale), false)
+	var perr error
+	switch {
+	case action.ResponseURL != "":
+		_, _, perr = api.PostMessage("",
+			slack.MsgOptionResponseURL(action.ResponseURL, slack.ResponseTypeEphemeral), text)
+	case action.Channel.ID != "":
+		_, perr = api.PostEphemeral(action.Channel.ID, action.User.ID, text)
+	default:
+		_, _, perr = api.PostMessage(action.User.ID, text)
+	}
+	if perr != nil {
+		logging.FromContext(ctx).Errorf("Error replying to %v with error [%s]: %v", action.User.ID, e.ID, perr)
+	}
+}
+
+// Checks that user is an admin; errors are ready to be reported to the user.
+func CheckAdmin(perms AdminInterface, user *slack.User) (err error) {
+	ok, err := perms.IsAdmin(user)
+	if err != nil {
+		return NewError(ErrUpstream, fmt.Errorf("checking admin status of %v (%v): %w", user.ID, user.Name, err))
+	}
+	if !ok {
+		return Errorf(ErrPermissionDenied, "%v (%v) is not an admin", user.ID, user.Name)
+	}
+	return
+}
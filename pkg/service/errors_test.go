@@ -0,0 +1,84 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type errorAdmins struct {
+	admins map[string]bool
+	err    error
+}
+
+func (a errorAdmins) IsAdmin(user *slack.User) (bool, error) {
+	return a.admins[user.ID], a.err
+}
+
+func (a errorAdmins) SendAdminMessage(str string) error {
+	return nil
+}
+
+func TestAsError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{fmt.Errorf("taking: %w", queue.VersionError{Current: 2, Attempted: 1}), ErrStaleVersion},
+		{&slack.RateLimitedError{RetryAfter: time.Second}, ErrUpstream},
+		{errors.New("empty queue"), ErrInternal},
+		{Errorf(ErrQueueEmpty, "nothing"), ErrQueueEmpty},
+	}
+	for _, c := range cases {
+		if e := AsError(c.err); e.Kind != c.kind {
+			t.Fatalf("Expected %v for %v, got %v", c.kind, c.err, e.Kind)
+		}
+	}
+
+	e := NewError(ErrNotFound, nil)
+	if AsError(fmt.Errorf("wrapped: %w", e)) != e {
+		t.Fatalf("Wrapped error was not unwrapped")
+	}
+}
+
+func TestReplyCommandError(t *testing.T) {
+	w := httptest.NewRecorder()
+	e := Errorf(ErrInternal, "oops")
+	ReplyCommandError(context.Background(), w, &slack.SlashCommand{UserID: "U1"}, DefaultLocale, e)
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	var reply ephemeralReply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ResponseType != slack.ResponseTypeEphemeral {
+		t.Fatalf("Reply is not ephemeral: %+v", reply)
+	}
+	if !strings.Contains(reply.Text, e.ID) || strings.Contains(reply.Text, "oops") {
+		t.Fatalf("Reply should reference %v without details: %v", e.ID, reply.Text)
+	}
+}
+
+func TestCheckAdmin(t *testing.T) {
+	admins := errorAdmins{admins: map[string]bool{"A1": true}}
+	if err := CheckAdmin(admins, &slack.User{ID: "A1"}); err != nil {
+		t.Fatalf("Admin denied: %v", err)
+	}
+	if err := CheckAdmin(admins, &slack.User{ID: "U1"}); AsError(err).Kind != ErrPermissionDenied {
+		t.Fatalf("Expected permission denied, got %v", err)
+	}
+	admins.err = errors.New("channel_not_found")
+	if err := CheckAdmin(admins, &slack.User{ID: "A1"}); AsError(err).Kind != ErrUpstream {
+		t.Fatalf("Expected upstream failure, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alerts for a breach are repeated no more often than this, by default.
+const DefaultEscalationCooldown = 15 * time.Minute
+
+// Thresholds beyond which a queue is considered neglected and admins are
+// alerted. A zero threshold is not checked.
+type Escalation struct {
+	MaxWait  time.Duration `json:"MaxWait,omitempty"`
+	MaxSize  int           `json:"MaxSize,omitempty"`
+	Mention  string        `json:"Mention,omitempty"` // user group ID
+	Cooldown time.Duration `json:"Cooldown,omitempty"`
+}
+
+// Parses thresholds of the form "wait=20m size=10 mention=S123 cooldown=15m".
+// The user group may also be given as a mention, e.g., "<!subteam^S123|@tas>".
+// An empty definition or "off" returns a nil escalation, i.e., no alerts.
+func ParseEscalation(def string) (e *Escalation, err error) {
+	def = strings.TrimSpace(def)
+	if def == "" || def == "off" {
+		return
+	}
+	e = &Escalation{Cooldown: DefaultEscalationCooldown}
+	for _, tok := range strings.Fields(def) {
+		if strings.HasPrefix(tok, "<!subteam^") {
+			e.Mention = strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(tok, "<!subteam^"), ">"), "|", 2)[0]
+			continue
+		}
+		kv := strings.SplitN(tok, "=", 2)
+		if len(kv) != 2 {
+			err = errors.New(fmt.Sprintf("Invalid threshold '%v'", tok))
+			return
+		}
+		switch kv[0] {
+		case "wait":
+			e.MaxWait, err = time.ParseDuration(kv[1])
+		case "size":
+			e.MaxSize, err = strconv.Atoi(kv[1])
+		case "mention":
+			e.Mention = kv[1]
+		case "cooldown":
+			e.Cooldown, err = time.ParseDuration(kv[1])
+		default:
+			err = errors.New(fmt.Sprintf("Unknown threshold '%v'", kv[0]))
+		}
+		if err != nil {
+			return
+		}
+	}
+	if e.MaxWait <= 0 && e.MaxSize <= 0 {
+		err = errors.New("At least one of wait or size is required")
+	}
+	return
+}
+
+func (e *Escalation) String() string {
+	var parts []string
+	if e.MaxWait > 0 {
+		parts = append(parts, fmt.Sprintf("wait=%v", e.MaxWait))
+	}
+	if e.MaxSize > 0 {
+		parts = append(parts, fmt.Sprintf("size=%d", e.MaxSize))
+	}
+	if e.Mention != "" {
+		parts = append(parts, fmt.Sprintf("mention=%s", e.Mention))
+	}
+	parts = append(parts, fmt.Sprintf("cooldown=%v", e.Cooldown))
+	return strings.Join(parts, " ")
+}
+
+const (
+	waitBreach = "wait"
+	sizeBreach = "size"
+)
+
+// Tracks when each breach was last alerted.
+type escalationState struct {
+	alerted map[string]time.Time
+}
+
+// Checks the queue against its thresholds. Returns an alert for each breach
+// that hasn't been alerted within the cooldown.
+func (s *QueueService) Escalations(now time.Time) (alerts []string) {
+	lst, _ := s.q.List()
+
+	type breach struct {
+		key  string
+		args Args
+	}
+	var breaches []breach
+	s.mu.Lock()
+	e := s.escalation
+	if e == nil {
+		s.mu.Unlock()
+		return
+	}
+	alert := func(name string, key string, args Args) {
+		if last, ok := s.escalated.alerted[name]; ok && now.Sub(last) < e.Cooldown {
+			return
+		}
+		s.escalated.alerted[name] = now
+		breaches = append(breaches, breach{key, args})
+	}
+	if e.MaxWait > 0 && len(lst) > 0 {
+		if wait := now.Sub(lst[0].QTime); wait > e.MaxWait {
+			alert(waitBreach, MsgEscalateWait, Args{"Wait": wait.Round(time.Minute).String(), "Max": e.MaxWait.String()})
+		}
+	}
+	if e.MaxSize > 0 && len(lst) > e.MaxSize {
+		alert(sizeBreach, MsgEscalateSize, Args{"Size": len(lst), "Max": e.MaxSize})
+	}
+	s.mu.Unlock()
+
+	mention := ""
+	if e.Mention != "" {
+		mention = fmt.Sprintf("<!subteam^%s> ", e.Mention)
+	}
+	locale := s.Locale()
+	for _, b := range breaches {
+		alerts = append(alerts, mention+s.Msg(locale, b.key, b.args))
+	}
+	return
+}
+
+// Returns the queue's escalation thresholds, or nil if it has none.
+func (s *QueueService) Escalation() *Escalation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.escalation
+}
+
+func (s *QueueService) SetEscalation(e *Escalation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.escalation = e
+	s.escalated = escalationState{make(map[string]time.Time)}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"github.com/slack-go/slack"
+
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEscalation(t *testing.T) {
+	e, err := ParseEscalation("wait=20m size=10 <!subteam^S123|@tas>")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := Escalation{MaxWait: 20 * time.Minute, MaxSize: 10, Mention: "S123", Cooldown: DefaultEscalationCooldown}
+	if *e != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, *e)
+	}
+	if e, err = ParseEscalation("off"); e != nil || err != nil {
+		t.Fatalf("Expected off to clear thresholds, got %v, %v", e, err)
+	}
+	for _, def := range []string{"wait=soon", "cooldown=5m", "size", "color=red"} {
+		if _, err = ParseEscalation(def); err == nil {
+			t.Fatalf("Expected error parsing '%v'", def)
+		}
+	}
+}
+
+func TestEscalationCooldown(t *testing.T) {
+	ts := TS(&MockUserLookup{}, nil)
+	e, _ := ParseEscalation("wait=20m size=1 mention=S1 cooldown=10m")
+	ts.SetEscalation(e)
+
+	ts.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: "U1"}}, &EnqueueResponse{})
+	now := time.Now()
+	if alerts := ts.Escalations(now); len(alerts) != 0 {
+		t.Fatalf("Unexpected alerts: %v", alerts)
+	}
+
+	ts.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: "U2"}}, &EnqueueResponse{})
+	alerts := ts.Escalations(now.Add(30 * time.Minute))
+	if len(alerts) != 2 {
+		t.Fatalf("Expected wait and size alerts, got %v", alerts)
+	}
+	for _, a := range alerts {
+		if !strings.HasPrefix(a, "<!subteam^S1> ") {
+			t.Fatalf("Alert doesn't mention group: %v", a)
+		}
+	}
+
+	if alerts = ts.Escalations(now.Add(35 * time.Minute)); len(alerts) != 0 {
+		t.Fatalf("Alerted within cooldown: %v", alerts)
+	}
+	if alerts = ts.Escalations(now.Add(41 * time.Minute)); len(alerts) != 2 {
+		t.Fatalf("Expected alerts after cooldown, got %v", alerts)
+	}
+}
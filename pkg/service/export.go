@@ -0,0 +1,125 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV  ExportFormat = "csv"
+	ExportJSON ExportFormat = "json" // one object per line
+)
+
+func ParseExportFormat(s string) (f ExportFormat, ok bool) {
+	switch ExportFormat(s) {
+	case ExportCSV, ExportJSON:
+		return ExportFormat(s), true
+	}
+	return "", false
+}
+
+// Kinds of exported records.
+const (
+	RecordVisit   = "visit"   // a visit that ended, from the history
+	RecordWaiting = "waiting" // a user in the queue at the time of export
+)
+
+// A row of an export.
+type ExportRecord struct {
+	Record      string        `json:"record"`
+	TeamID      string        `json:"team_id,omitempty"`
+	ChannelID   string        `json:"channel_id"`
+	UserID      string        `json:"user_id"`
+	Topic       string        `json:"topic,omitempty"`
+	Fields      []queue.Field `json:"fields,omitempty"` // answers to the intake form
+	Enqueued    time.Time     `json:"enqueued"`
+	Dequeued    *time.Time    `json:"dequeued,omitempty"` // nil while waiting
+	WaitSeconds int64         `json:"wait_seconds"`
+	AdminID     string        `json:"admin_id,omitempty"`
+	Outcome     Outcome       `json:"outcome,omitempty"`
+}
+
+var exportColumns = []string{"record", "team_id", "channel_id", "user_id", "topic", "enqueued", "dequeued", "wait_seconds", "admin_id", "outcome"}
+
+func HistoryRecords(entries []HistoryEntry) (records []ExportRecord) {
+	for _, e := range entries {
+		dequeued := e.Dequeued
+		records = append(records, ExportRecord{
+			Record:      RecordVisit,
+			TeamID:      e.TeamID,
+			ChannelID:   e.ChannelID,
+			UserID:      e.UserID,
+			Topic:       e.Topic,
+			Fields:      e.Fields,
+			Enqueued:    e.Enqueued,
+			Dequeued:    &dequeued,
+			WaitSeconds: int64(e.Wait() / time.Second),
+			AdminID:     e.AdminID,
+			Outcome:     e.Outcome})
+	}
+	return
+}
+
+// Records of the users waiting in a queue, as of now.
+func WaitingRecords(team string, channel string, els []queue.Element, now time.Time) (records []ExportRecord) {
+	for _, el := range els {
+		records = append(records, ExportRecord{
+			Record:      RecordWaiting,
+			TeamID:      team,
+			ChannelID:   channel,
+			UserID:      el.Id,
+			Topic:       el.Metadata,
+			Fields:      el.Fields,
+			Enqueued:    el.QTime,
+			WaitSeconds: int64(now.Sub(el.QTime) / time.Second)})
+	}
+	return
+}
+
+func WriteExport(w io.Writer, format ExportFormat, records []ExportRecord) (err error) {
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		for _, r := range records {
+			cw.Write([]string{r.Record, r.TeamID, r.ChannelID, r.UserID, csvText(describeVisit(r.Topic, r.Fields)),
+				formatExportTime(&r.Enqueued), formatExportTime(r.Dequeued),
+				strconv.FormatInt(r.WaitSeconds, 10), r.AdminID, string(r.Outcome)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err = enc.Encode(r); err != nil {
+				return
+			}
+		}
+		return
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// Keeps spreadsheets from evaluating text entered by users as a formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return "'" + s
+	}
+	return s
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportRecords() []ExportRecord {
+	enqueued := time.Date(2021, 3, 4, 14, 0, 0, 0, time.UTC)
+	records := HistoryRecords([]HistoryEntry{{
+		TeamID: "T1", ChannelID: "C1", UserID: "U1", Topic: "=1+1", AdminID: "A1", Outcome: OutcomeServed,
+		Enqueued: enqueued, Dequeued: enqueued.Add(90 * time.Second)}})
+	return append(records, WaitingRecords("T1", "C1", []queue.Element{{Id: "U2", QTime: enqueued}}, enqueued.Add(time.Minute))...)
+}
+
+func TestWriteExportCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteExport(&b, ExportCSV, exportRecords()); err != nil {
+		t.Fatal(err)
+	}
+	expected := `record,team_id,channel_id,user_id,topic,enqueued,dequeued,wait_seconds,admin_id,outcome
+visit,T1,C1,U1,'=1+1,2021-03-04T14:00:00Z,2021-03-04T14:01:30Z,90,A1,served
+waiting,T1,C1,U2,,2021-03-04T14:00:00Z,,60,,
+`
+	if b.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestWriteExportJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteExport(&b, ExportJSON, exportRecords()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per record, got %q", b.String())
+	}
+	var waiting map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &waiting); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := waiting["dequeued"]; ok || waiting["record"] != RecordWaiting || waiting["wait_seconds"] != 60.0 {
+		t.Fatalf("Unexpected waiting record: %v", waiting)
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/golang/glog"
+
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entries older than this are dropped.
+const DefaultHistoryRetention = 365 * 24 * time.Hour
+
+// How a user's time in a queue ended.
+type Outcome string
+
+const (
+	OutcomeServed  Outcome = "served"  // dequeued by an admin
+	OutcomeRemoved Outcome = "removed" // removed by an admin
+	OutcomeLeft    Outcome = "left"    // left the queue, or its channel
+	OutcomeExpired Outcome = "expired" // still waiting when the queue was deleted
+)
+
+// A user's visit to a queue.
+type HistoryEntry struct {
+	TeamID    string        `json:"TeamID,omitempty"`
+	ChannelID string        `json:"ChannelID"`
+	UserID    string        `json:"UserID"`
+	Topic     string        `json:"Topic,omitempty"`
+	Fields    []queue.Field `json:"Fields,omitempty"` // answers to the queue's intake form
+	Enqueued  time.Time     `json:"Enqueued"`
+	Dequeued  time.Time     `json:"Dequeued"`
+	AdminID   string        `json:"AdminID,omitempty"` // who served or removed the user
+	Outcome   Outcome       `json:"Outcome"`
+}
+
+// The entry's topic, or its answers to the intake form.
+func (e HistoryEntry) Description() string {
+	return describeVisit(e.Topic, e.Fields)
+}
+
+func describeVisit(topic string, fields []queue.Field) string {
+	if topic != "" || len(fields) == 0 {
+		return topic
+	}
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.Label + ": " + f.Value
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Wait of the entry's user.
+func (e HistoryEntry) Wait() time.Duration {
+	return e.Dequeued.Sub(e.Enqueued)
+}
+
+// Selects history entries. Empty fields match everything; times bound when
+// users left the queue.
+type HistoryQuery struct {
+	TeamID    string
+	ChannelID string
+	UserID    string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (q HistoryQuery) matches(e HistoryEntry) bool {
+	return (q.TeamID == "" || q.TeamID == e.TeamID) &&
+		(q.ChannelID == "" || q.ChannelID == e.ChannelID) &&
+		(q.UserID == "" || q.UserID == e.UserID) &&
+		(q.Since.IsZero() || !e.Dequeued.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Dequeued.Before(q.Until))
+}
+
+// Records of users' visits to queues.
+type HistoryStore interface {
+	Record(e HistoryEntry) error
+	// Matching entries, oldest first.
+	Query(q HistoryQuery) ([]HistoryEntry, error)
+}
+
+// Earlier format of persisted history: a snapshot of all entries, rewritten
+// on every change. Read only to recover old history.
+type HistoryStoreState struct {
+	Entries []HistoryEntry `json:"Entries"`
+}
+
+// History of all queues, each entry appended to a Log as it's recorded.
+// Expired entries are dropped from the log when it's compacted.
+//
+// Thread safe.
+type PersistentHistoryStore struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	entries []HistoryEntry // oldest first
+	log     persister.Log
+}
+
+// Returns a store appending to log, or in memory if log is nil.
+func MakeHistoryStore(log persister.Log, retention time.Duration) *PersistentHistoryStore {
+	return &PersistentHistoryStore{retention: retention, log: log}
+}
+
+func (hs *PersistentHistoryStore) Record(e HistoryEntry) (err error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	i := sort.Search(len(hs.entries), func(i int) bool {
+		return hs.entries[i].Dequeued.After(e.Dequeued)
+	})
+	hs.entries = append(hs.entries, HistoryEntry{})
+	copy(hs.entries[i+1:], hs.entries[i:])
+	hs.entries[i] = e
+	hs.expireLocked(time.Now())
+	if hs.log == nil {
+		return
+	}
+	return hs.log.Append(e)
+}
+
+func (hs *PersistentHistoryStore) Query(q HistoryQuery) (entries []HistoryEntry, err error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for _, e := range hs.entries {
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return
+}
+
+// Must hold lock.
+func (hs *PersistentHistoryStore) expireLocked(now time.Time) {
+	if hs.retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-hs.retention)
+	i := sort.Search(len(hs.entries), func(i int) bool {
+		return !hs.entries[i].Dequeued.Before(cutoff)
+	})
+	hs.entries = hs.entries[i:]
+}
+
+// Reads the log. Doesn't modify it, so that it may be read while another
+// process appends to it, e.g., to export it.
+func (hs *PersistentHistoryStore) Recover() {
+	if hs.log == nil {
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.entries = nil
+	err := hs.log.Read(func(record json.RawMessage) error {
+		var state struct {
+			HistoryEntry
+			Entries []HistoryEntry `json:"Entries"`
+		}
+		if err := json.Unmarshal(record, &state); err != nil {
+			return err
+		}
+		if state.Entries != nil {
+			hs.entries = append(hs.entries, state.Entries...)
+		} else {
+			hs.entries = append(hs.entries, state.HistoryEntry)
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("Error reading history, recovered %d entries: %v", len(hs.entries), err)
+		return
+	}
+	sort.SliceStable(hs.entries, func(i, j int) bool {
+		return hs.entries[i].Dequeued.Before(hs.entries[j].Dequeued)
+	})
+	hs.expireLocked(time.Now())
+	glog.Infof("Recovered %d history entries.", len(hs.entries))
+}
+
+// Rewrites the log with only the entries within the retention. Must not
+// race with another process appending to the log.
+func (hs *PersistentHistoryStore) Compact() (err error) {
+	if hs.log == nil {
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.expireLocked(time.Now())
+	records := make([]interface{}, len(hs.entries))
+	for i, e := range hs.entries {
+		records[i] = e
+	}
+	return hs.log.Rewrite(records)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreQuery(t *testing.T) {
+	hs := MakeHistoryStore(nil, DefaultHistoryRetention)
+	now := time.Now()
+	hs.Record(HistoryEntry{ChannelID: "C1", UserID: "U1", Dequeued: now})
+	hs.Record(HistoryEntry{ChannelID: "C1", UserID: "U2", Dequeued: now.Add(-time.Hour)})
+	hs.Record(HistoryEntry{ChannelID: "C2", UserID: "U1", Dequeued: now})
+	// Too old to keep.
+	hs.Record(HistoryEntry{ChannelID: "C1", UserID: "U1", Dequeued: now.Add(-2 * DefaultHistoryRetention)})
+
+	entries, _ := hs.Query(HistoryQuery{ChannelID: "C1"})
+	if len(entries) != 2 || entries[0].UserID != "U2" || entries[1].UserID != "U1" {
+		t.Fatalf("Expected C1's entries oldest first, got %+v", entries)
+	}
+	entries, _ = hs.Query(HistoryQuery{UserID: "U1", Since: now.Add(-time.Minute)})
+	if len(entries) != 2 {
+		t.Fatalf("Expected U1's recent entries, got %+v", entries)
+	}
+	entries, _ = hs.Query(HistoryQuery{ChannelID: "C1", Until: now.Add(-time.Minute)})
+	if len(entries) != 1 || entries[0].UserID != "U2" {
+		t.Fatalf("Expected U2's entry, got %+v", entries)
+	}
+}
+
+func TestHistoryStoreLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "history")
+	now := time.Now()
+	// History persisted as a snapshot is recovered, then appended to.
+	persister.FilePersister{Fn: fn}.Write(HistoryStoreState{[]HistoryEntry{
+		{ChannelID: "C1", UserID: "U1", Dequeued: now.Add(-2 * DefaultHistoryRetention)},
+		{ChannelID: "C1", UserID: "U2", Dequeued: now.Add(-time.Hour)}}})
+
+	hs := MakeHistoryStore(persister.FileLog{Fn: fn}, DefaultHistoryRetention)
+	hs.Recover()
+	fields := []queue.Field{{Label: "Question", Value: "hw1"}}
+	if err := hs.Record(HistoryEntry{ChannelID: "C1", UserID: "U3", Fields: fields, Dequeued: now}); err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+	if err := hs.Compact(); err != nil {
+		t.Fatalf("Error compacting: %v", err)
+	}
+	hs.Record(HistoryEntry{ChannelID: "C1", UserID: "U4", Dequeued: now})
+
+	hs = MakeHistoryStore(persister.FileLog{Fn: fn}, 0)
+	hs.Recover()
+	entries, _ := hs.Query(HistoryQuery{})
+	if len(entries) != 3 || entries[0].UserID != "U2" || entries[1].UserID != "U3" || entries[2].UserID != "U4" {
+		t.Fatalf("Expected the unexpired entries, got %+v", entries)
+	}
+	if entries[1].Description() != "Question: hw1" {
+		t.Fatalf("Expected the intake answers, got %+v", entries[1])
+	}
+}
+
+func TestQueueServiceRecordsHistory(t *testing.T) {
+	hs := MakeHistoryStore(nil, DefaultHistoryRetention)
+	s := TS(cachingLookup(&fakeUsersInfo{}, time.Hour), nil)
+	s.Identify("T1", "C1")
+	s.EnableHistory(hs)
+	for _, id := range []string{"U1", "U2", "U3", "U4"} {
+		s.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: id}, Metadata: "topic " + id}, &EnqueueResponse{})
+	}
+
+	s.Dequeue(context.Background(), &DequeueRequest{Admin: "A1"}, &DequeueResponse{})
+	lresp := &ListResponse{}
+	s.List(&ListRequest{}, lresp)
+	s.Remove(context.Background(), &RemoveRequest{Pos: 0, Token: lresp.Token, Admin: "A2"}, &RemoveResponse{})
+	s.RemoveUser(context.Background(), &RemoveUserRequest{Id: "U3"}, &RemoveUserResponse{})
+	s.ExpireWaiting()
+
+	entries, _ := hs.Query(HistoryQuery{TeamID: "T1", ChannelID: "C1"})
+	expected := []struct {
+		user    string
+		admin   string
+		outcome Outcome
+	}{
+		{"U1", "A1", OutcomeServed},
+		{"U2", "A2", OutcomeRemoved},
+		{"U3", "", OutcomeLeft},
+		{"U4", "", OutcomeExpired},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, e := range expected {
+		got := entries[i]
+		if got.UserID != e.user || got.AdminID != e.admin || got.Outcome != e.outcome || got.Topic != "topic "+e.user || got.Enqueued.IsZero() {
+			t.Fatalf("Expected %+v, got %+v", e, got)
+		}
+	}
+}
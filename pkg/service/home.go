@@ -0,0 +1,41 @@
+package service
+
+import (
+	"github.com/slack-go/slack"
+
+	"time"
+)
+
+// Summary and full listing of the queue in channel, as shown to admins in the
+// App Home and the admin channel's control panel.
+func QueueBlocks(s *QueueService, locale string, channel string, resp *ListResponse) (blocks []slack.Block) {
+	args := Args{"Channel": channel, "Size": len(resp.Users)}
+	if len(resp.Times) > 0 {
+		args["Oldest"] = time.Now().Sub(resp.Times[0]).Round(time.Second).String()
+	}
+	summary := s.Msg(locale, MsgQueueSummary, args)
+	blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", summary, false, false), nil, nil))
+	blocks = append(blocks, listAsBlock(s, locale, channel, resp)...)
+	return
+}
+
+// App Home section for a queue in channel, shown to a student in it.
+func StudentHomeBlocks(s *QueueService, locale string, channel string, resp *PositionResponse) (blocks []slack.Block) {
+	status := s.Msg(locale, MsgStudentHome, Args{"Channel": channel, "Pos": resp.Pos + 1, "Size": resp.Size,
+		"Wait": time.Now().Sub(resp.Timestamp).Round(time.Second).String()})
+	leave := slack.NewButtonBlockElement(leaveActionName, GenerateActionValue(resp.Pos, resp.Token),
+		slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgLeaveButton, nil), false, false))
+	section := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", status, false, false), nil, slack.NewAccessory(leave))
+	section.BlockID = QueueBlockID(channel, leaveActionName)
+	blocks = append(blocks, section)
+	return
+}
+
+// Truncates blocks to at most max, replacing the last with a note.
+func TruncateBlocks(blocks []slack.Block, max int, note string) []slack.Block {
+	if len(blocks) <= max {
+		return blocks
+	}
+	more := slack.NewTextBlockObject("mrkdwn", note, false, false)
+	return append(blocks[:max-1], slack.NewContextBlock("", more))
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	intakeCallbackID = "intake"
+	intakeActionID   = "answer"
+	optionalSuffix   = "(optional)"
+)
+
+// Questions asked of students when they join a queue without a topic.
+type IntakeForm struct {
+	Fields []IntakeField `json:"Fields"`
+}
+
+type IntakeField struct {
+	Label    string `json:"Label"`
+	Optional bool   `json:"Optional,omitempty"`
+}
+
+// Parses a form definition of the form "Label; Label (optional); ...". An
+// empty definition returns a nil form, i.e., no form.
+func ParseIntakeForm(def string) (form *IntakeForm, err error) {
+	def = strings.TrimSpace(def)
+	if def == "" {
+		return
+	}
+	form = &IntakeForm{}
+	for _, part := range strings.Split(def, ";") {
+		label := strings.TrimSpace(part)
+		optional := false
+		if strings.HasSuffix(strings.ToLower(label), optionalSuffix) {
+			label = strings.TrimSpace(label[:len(label)-len(optionalSuffix)])
+			optional = true
+		}
+		if label == "" {
+			err = errors.New(fmt.Sprintf("Empty question in form '%v'", def))
+			return
+		}
+		form.Fields = append(form.Fields, IntakeField{label, optional})
+	}
+	return
+}
+
+func (f *IntakeForm) String() string {
+	labels := make([]string, len(f.Fields))
+	for i, field := range f.Fields {
+		labels[i] = field.Label
+		if field.Optional {
+			labels[i] = fmt.Sprintf("%s %s", labels[i], optionalSuffix)
+		}
+	}
+	return strings.Join(labels, "; ")
+}
+
+func intakeBlockID(i int) string {
+	return fmt.Sprintf("intake_%d", i)
+}
+
+// Modal asking the questions of a form. The channel of the queue is carried
+// in the view's private metadata.
+func intakeModal(s *QueueService, locale string, channel string, form *IntakeForm) slack.ModalViewRequest {
+	blocks := make([]slack.Block, len(form.Fields))
+	for i, field := range form.Fields {
+		input := slack.NewPlainTextInputBlockElement(nil, intakeActionID)
+		input.Multiline = true
+		block := slack.NewInputBlock(intakeBlockID(i), slack.NewTextBlockObject("plain_text", field.Label, false, false), input)
+		block.Optional = field.Optional
+		blocks[i] = block
+	}
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		Title:           slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgIntakeTitle, nil), false, false),
+		Submit:          slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgIntakeSubmit, nil), false, false),
+		Close:           slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgIntakeCancel, nil), false, false),
+		CallbackID:      intakeCallbackID,
+		PrivateMetadata: channel,
+		Blocks:          slack.Blocks{BlockSet: blocks}}
+}
+
+// Reads the answers to a form from a submitted view. Unanswered optional
+// questions are omitted.
+func intakeAnswers(form *IntakeForm, state *slack.ViewState) (fields []queue.Field) {
+	if state == nil {
+		return
+	}
+	for i, field := range form.Fields {
+		value := strings.TrimSpace(state.Values[intakeBlockID(i)][intakeActionID].Value)
+		if value == "" {
+			continue
+		}
+		fields = append(fields, queue.Field{Label: field.Label, Value: value})
+	}
+	return
+}
+
+// Renders answers one per line, e.g., for a listing or a DM.
+func formatFields(fields []queue.Field) string {
+	lines := make([]string, len(fields))
+	for i, f := range fields {
+		lines[i] = fmt.Sprintf("*%s:* %s", f.Label, f.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (a *IntakeAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	form := s.Form()
+	if form == nil {
+		// The form was removed while the modal was open; join without answers.
+		form = &IntakeForm{}
+	}
+	req := &EnqueueRequest{}
+	resp := &EnqueueResponse{}
+
+	req.User = &slack.User{}
+	req.User.ID = action.User.ID
+	req.User.Name = action.User.Name
+	req.User.TeamID = action.Team.ID
+	req.Fields = intakeAnswers(form, action.View.State)
+	if len(req.Fields) > 0 {
+		req.Metadata = req.Fields[0].Value
+	}
+
+	err := s.Enqueue(ctx, req, resp)
+	if err != nil {
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), fmt.Errorf("enqueueing: %w", err))
+		return
+	}
+
+	// Closes the modal.
+	w.WriteHeader(http.StatusOK)
+
+	channel := action.View.PrivateMetadata
+	locale := s.LocaleOf(a.ul, action.User.ID)
+	_, err = a.api.PostEphemeral(channel, action.User.ID, slack.MsgOptionBlocks(enqueueBlocks(s, locale, resp)...))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Error posting join status: %v", err)
+	}
+
+	if !resp.Ok {
+		// Don't post admin if already in queue.
+		return
+	}
+
+	fu, err := a.ul.Lookup(req.User.ID)
+	if err == nil {
+		resp.User = fu
+	}
+
+	str := s.Msg(s.Locale(), MsgAdded, Args{"User": userToLink(resp.User), "Pos": resp.Pos + 1})
+	if len(req.Fields) > 0 {
+		str = fmt.Sprintf("%s\n%s", str, formatFields(req.Fields))
+	}
+	cerr := a.perms.SendAdminMessage(str)
+	if cerr != nil {
+		logging.FromContext(ctx).Errorf("Error sending admin message for enqueue: %v", cerr)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"testing"
+)
+
+func TestParseIntakeForm(t *testing.T) {
+	form, err := ParseIntakeForm("Assignment; What have you tried?; Location (optional)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []IntakeField{{"Assignment", false}, {"What have you tried?", false}, {"Location", true}}
+	if len(form.Fields) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, form.Fields)
+	}
+	for i, f := range expected {
+		if form.Fields[i] != f {
+			t.Fatalf("Expected %v, got %v", f, form.Fields[i])
+		}
+	}
+	if form.String() != "Assignment; What have you tried?; Location (optional)" {
+		t.Fatalf("Unexpected form definition: %v", form)
+	}
+
+	if form, err = ParseIntakeForm("  "); form != nil || err != nil {
+		t.Fatalf("Expected empty definition to clear form, got %v, %v", form, err)
+	}
+	if _, err = ParseIntakeForm("Assignment;;"); err == nil {
+		t.Fatalf("Expected error for empty question.")
+	}
+}
+
+func TestIntakeAnswers(t *testing.T) {
+	form, _ := ParseIntakeForm("Assignment; Location (optional)")
+	state := &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+		intakeBlockID(0): {intakeActionID: {Value: " hw3 "}},
+		intakeBlockID(1): {intakeActionID: {Value: ""}},
+	}}
+	fields := intakeAnswers(form, state)
+	if len(fields) != 1 || fields[0] != (queue.Field{Label: "Assignment", Value: "hw3"}) {
+		t.Fatalf("Unexpected answers: %v", fields)
+	}
+}
+
+func TestEnqueueFields(t *testing.T) {
+	mul := &MockUserLookup{responses: []struct {
+		User *slack.User
+		Err  error
+	}{{&slack.User{ID: "user123"}, nil}, {&slack.User{ID: "user123"}, nil}}}
+	ts := TS(mul, nil)
+
+	fields := []queue.Field{{Label: "Assignment", Value: "hw3"}}
+	ts.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: "user123"}, Fields: fields}, &EnqueueResponse{})
+
+	resp := &ListResponse{}
+	if err := ts.List(&ListRequest{}, resp); err != nil {
+		t.Fatalf("Unexpected error listing: %v", err)
+	}
+	if len(resp.Fields) != 1 || len(resp.Fields[0]) != 1 || resp.Fields[0][0] != fields[0] {
+		t.Fatalf("Fields not listed: %v", resp.Fields)
+	}
+
+	dresp := &DequeueResponse{}
+	ts.Dequeue(context.Background(), &DequeueRequest{}, dresp)
+	if len(dresp.Fields) != 1 || dresp.Fields[0] != fields[0] {
+		t.Fatalf("Fields not dequeued: %v", dresp.Fields)
+	}
+}
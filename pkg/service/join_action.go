@@ -0,0 +1,99 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Public message in a queue's channel that lets students join and leave the
+// queue without the slash command. Shows how many are waiting and the
+// estimated wait, but not who.
+func JoinBlocks(s *QueueService, locale string, resp *SummaryResponse) (blocks []slack.Block) {
+	var status string
+	switch {
+	case resp.Size == 0:
+		status = s.Msg(locale, MsgWaitingNone, nil)
+	case resp.Size == 1:
+		status = s.Msg(locale, MsgWaitingOne, nil)
+	default:
+		status = s.Msg(locale, MsgWaitingMany, Args{"Size": resp.Size})
+	}
+	if resp.Estimated {
+		status = fmt.Sprintf("%s\n%s", status, s.Msg(locale, MsgEstimatedWait, Args{"Wait": formatWait(s, locale, resp.EstimatedWait)}))
+	}
+	blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", status, false, false), nil, nil))
+
+	join := slack.NewButtonBlockElement(joinActionName, "", slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgJoinButton, nil), false, false))
+	join.Style = slack.StylePrimary
+	leave := slack.NewButtonBlockElement(leaveActionName, "", slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgLeaveButton, nil), false, false))
+	position := slack.NewButtonBlockElement(positionActionName, "", slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgPositionButton, nil), false, false))
+	blocks = append(blocks, slack.NewActionBlock("join_actions", join, leave, position))
+	return
+}
+
+func (a *JoinAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	req := &EnqueueRequest{}
+	resp := &EnqueueResponse{}
+
+	req.User = &slack.User{}
+	req.User.ID = action.User.ID
+	req.User.Name = action.User.Name
+	req.User.TeamID = action.Team.ID
+
+	err := s.Enqueue(ctx, req, resp)
+	if err != nil {
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), fmt.Errorf("enqueueing: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	locale := s.LocaleOf(a.ul, action.User.ID)
+	_, err = a.api.PostEphemeral(action.Channel.ID, action.User.ID, slack.MsgOptionBlocks(enqueueBlocks(s, locale, resp)...))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Error posting join status: %v", err)
+	}
+
+	if !resp.Ok {
+		// Don't post admin if already in queue.
+		return
+	}
+
+	fu, err := a.ul.Lookup(req.User.ID)
+	if err == nil {
+		resp.User = fu
+	}
+
+	str := s.Msg(s.Locale(), MsgAdded, Args{"User": userToLink(resp.User), "Pos": resp.Pos + 1})
+	cerr := a.perms.SendAdminMessage(str)
+	if cerr != nil {
+		logging.FromContext(ctx).Errorf("Error sending admin message for join: %v", cerr)
+	}
+}
+
+func (a *PositionAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	req := &PositionRequest{Id: action.User.ID}
+	resp := &PositionResponse{}
+	err := s.Position(req, resp)
+	if err != nil {
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), fmt.Errorf("finding position: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	locale := s.LocaleOf(a.ul, action.User.ID)
+	str := s.Msg(locale, MsgNotQueued, nil)
+	if resp.Ok {
+		str = s.Msg(locale, MsgPosition, Args{"Pos": resp.Pos + 1, "Size": resp.Size})
+	}
+	_, err = a.api.PostEphemeral(action.Channel.ID, action.User.ID, slack.MsgOptionText(str, false))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Error posting position: %v", err)
+	}
+}
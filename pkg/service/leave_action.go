@@ -0,0 +1,53 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"fmt"
+	"net/http"
+)
+
+func (a *LeaveAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	user := &action.User
+
+	req := &RemoveUserRequest{Id: user.ID}
+	resp := &RemoveUserResponse{}
+	err := s.RemoveUser(ctx, req, resp)
+	if err != nil {
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), fmt.Errorf("removing %v from queue: %w", user.ID, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if action.Channel.ID != "" {
+		locale := s.LocaleOf(a.ul, user.ID)
+		str := s.Msg(locale, MsgLeft, nil)
+		if !resp.Ok {
+			str = s.Msg(locale, MsgNotQueued, nil)
+		}
+		_, err = a.api.PostEphemeral(action.Channel.ID, user.ID, slack.MsgOptionText(str, false))
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Error posting leave status: %v", err)
+		}
+	}
+
+	if !resp.Ok {
+		logging.FromContext(ctx).Infof("Left but was not in queue")
+		return
+	}
+
+	fu, err := a.ul.Lookup(user.ID)
+	if err == nil {
+		user = fu
+	}
+
+	str := s.Msg(s.Locale(), MsgLeftAdmin, Args{"User": userToLink(user), "Pos": resp.Pos + 1})
+	cerr := a.perms.SendAdminMessage(str)
+	if cerr != nil {
+		logging.FromContext(ctx).Errorf("Error sending admin message for leave: %v", cerr)
+	}
+}
@@ -1,47 +1,57 @@
 package service
 
 import (
+	"github.com/ml8/slack-queue/pkg/logging"
+
 	"github.com/golang/glog"
 	"github.com/slack-go/slack"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 )
 
-func listAsBlock(resp *ListResponse) (blocks []slack.Block) {
+// Renders a queue listing. If channel is non-empty, block IDs identify the
+// queue's channel, so that actions can be routed from outside the channel.
+func listAsBlock(s *QueueService, locale string, channel string, resp *ListResponse) (blocks []slack.Block) {
 	if len(resp.Users) == 0 {
 		blocks = make([]slack.Block, 1)
-		empty := slack.NewTextBlockObject("mrkdwn", "No users in queue.", false, false)
+		empty := slack.NewTextBlockObject("mrkdwn", s.Msg(locale, MsgListEmpty, nil), false, false)
 		blocks[0] = slack.NewSectionBlock(empty, nil, nil)
 		return
 	}
 	blocks = make([]slack.Block, len(resp.Users)*3)
 	for i, user := range resp.Users {
 		blocks[i*3] = slack.NewDividerBlock()
-		userinfo := fmt.Sprintf("*%d:* %s\n*Wait time:* %s\n*Topic:* %s", i+1, userToLink(user), (time.Now().Sub(resp.Times[i])).String(), resp.Metadata[i])
+		userinfo := s.Msg(locale, MsgListEntry, Args{"Pos": i + 1, "User": userToLink(user), "Wait": time.Now().Sub(resp.Times[i]).String()})
+		if i < len(resp.Fields) && len(resp.Fields[i]) > 0 {
+			userinfo += formatFields(resp.Fields[i])
+		} else {
+			userinfo += s.Msg(locale, MsgListTopic, Args{"Topic": resp.Metadata[i]})
+		}
 		userblock := slack.NewTextBlockObject("mrkdwn", userinfo, false, false)
 		iconblock := slack.NewImageBlockElement(user.Profile.Image192, user.RealName)
 		blocks[i*3+1] = slack.NewSectionBlock(userblock, nil, slack.NewAccessory(iconblock))
 
 		buttons := make([]slack.BlockElement, 2, 4)
 
-		buttons[0] = slack.NewButtonBlockElement("remove", GenerateActionValue(i, resp.Token), slack.NewTextBlockObject("plain_text", "Remove", false, false))
-		buttons[1] = slack.NewButtonBlockElement("take", GenerateActionValue(i, resp.Token), slack.NewTextBlockObject("plain_text", "Dequeue", false, false))
+		buttons[0] = slack.NewButtonBlockElement("remove", GenerateActionValue(i, resp.Token), slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgRemoveButton, nil), false, false))
+		buttons[1] = slack.NewButtonBlockElement("take", GenerateActionValue(i, resp.Token), slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgDequeueButton, nil), false, false))
 		if i != 0 {
 			buttons = append(buttons, slack.NewButtonBlockElement("up", GenerateActionValue(i, resp.Token), slack.NewTextBlockObject("plain_text", ":arrow_up_small:", true, false)))
 		}
 		if i != len(resp.Users)-1 {
 			buttons = append(buttons, slack.NewButtonBlockElement("down", GenerateActionValue(i, resp.Token), slack.NewTextBlockObject("plain_text", ":arrow_down_small:", true, false)))
 		}
-		blocks[i*3+2] = slack.NewActionBlock(fmt.Sprintf("actions_%v", user.ID), buttons...)
+		blocks[i*3+2] = slack.NewActionBlock(QueueBlockID(channel, fmt.Sprintf("actions_%v", user.ID)), buttons...)
 	}
 
 	return
 }
 
-func updateListInUI(action *slack.InteractionCallback, s *QueueService, api *slack.Client) {
+func updateListInUI(action *slack.InteractionCallback, s *QueueService, api *slack.Client, locale string) {
 	lreq := &ListRequest{}
 	lresp := &ListResponse{}
 	err := s.List(lreq, lresp)
@@ -49,45 +59,36 @@ func updateListInUI(action *slack.InteractionCallback, s *QueueService, api *sla
 		glog.Errorf("Error getting queue state: %v", err)
 		return
 	}
+	if ActionChannel(action) != "" {
+		// Listings outside the ephemeral list response (e.g., the App Home or the
+		// control panel) are refreshed when the queue changes.
+		return
+	}
 	_, _, err = api.PostMessage("",
 		slack.MsgOptionResponseURL(action.ResponseURL, slack.ResponseTypeEphemeral),
 		slack.MsgOptionReplaceOriginal(action.ResponseURL),
-		slack.MsgOptionBlocks(listAsBlock(lresp)...))
+		slack.MsgOptionBlocks(listAsBlock(s, locale, "", lresp)...))
 	if err != nil {
-		glog.Errorf("Error posting reply: %v")
+		glog.Errorf("Error posting reply: %v", err)
 	}
 }
 
-func (c *ListCommand) Handle(cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
-	// Check permission to list queue.
-	user := &slack.User{ID: cmd.UserID}
-	ok, err := c.perms.IsAdmin(user)
-	if err != nil {
-		glog.Errorf("Error checking admin status of %v (%v): %v", cmd.UserID, cmd.UserName, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !ok {
-		glog.Errorf("Permission denied to user %v (%v)", cmd.UserID, cmd.UserName)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
+func (c *ListCommand) Handle(ctx context.Context, cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
 	req := ListRequest{}
 	resp := ListResponse{}
 	err = s.List(&req, &resp)
+	locale := s.LocaleOf(c.ul, cmd.UserID)
 	if err != nil {
-		glog.Errorf("Error listing users: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		ReplyCommandError(ctx, w, cmd, locale, NewError(ErrUpstream, fmt.Errorf("listing users: %w", err)))
 		return
 	}
-	blocks := listAsBlock(&resp)
+	blocks := listAsBlock(s, locale, "", &resp)
 	msg := slack.NewBlockMessage(blocks...)
 	b, err := json.MarshalIndent(msg, "", "  ")
 	if err != nil {
 		glog.Fatalf("Error marshalling json: %v", err)
 	}
-	glog.V(2).Infof("List response:\n%v", string(b))
+	logging.FromContext(ctx).With(logging.Seq, resp.Token).Debugf("Listed %d users", len(resp.Users))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
 	return
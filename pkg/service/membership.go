@@ -0,0 +1,223 @@
+package service
+
+import (
+	"github.com/golang/glog"
+
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Memberships are refetched after this long, by default.
+const DefaultMembershipTTL = time.Hour
+
+// Keys of cached memberships.
+func ChannelMembersKey(channelID string) string {
+	return "channel:" + channelID
+}
+
+func UsergroupMembersKey(groupID string) string {
+	return "usergroup:" + groupID
+}
+
+// Resolves the name of a channel to its ID, as a list of one.
+func channelNameKey(name string) string {
+	return "channel-name:" + name
+}
+
+// Counters of a MembershipCache.
+type MembershipStats struct {
+	Hits          int64 // served fresh from the cache
+	Misses        int64 // waited for a fetch
+	Stale         int64 // served stale, e.g., while Slack is unreachable
+	Fetches       int64 // fetches started; concurrent misses share one
+	Errors        int64 // failed fetches
+	Invalidations int64
+}
+
+func (s MembershipStats) Add(o MembershipStats) MembershipStats {
+	return MembershipStats{
+		Hits:          s.Hits + o.Hits,
+		Misses:        s.Misses + o.Misses,
+		Stale:         s.Stale + o.Stale,
+		Fetches:       s.Fetches + o.Fetches,
+		Errors:        s.Errors + o.Errors,
+		Invalidations: s.Invalidations + o.Invalidations,
+	}
+}
+
+func (s MembershipStats) String() string {
+	return fmt.Sprintf("hits=%d misses=%d stale=%d fetches=%d errors=%d invalidations=%d",
+		s.Hits, s.Misses, s.Stale, s.Fetches, s.Errors, s.Invalidations)
+}
+
+// Membership of a workspace's channels and user groups, shared by everything
+// that checks it, e.g., the admins of every queue.
+//
+// A fetch runs at most once at a time per key; concurrent callers wait for
+// it. Entries older than the TTL are served while they're refetched in the
+// background; invalidated entries are refetched before they're served. When
+// a fetch fails, the last known membership is served, and the fetch is
+// retried with backoff.
+//
+// Thread safe.
+type MembershipCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*membershipEntry
+	calls   map[string]*membershipCall
+	stats   MembershipStats
+}
+
+type membershipEntry struct {
+	users       []string
+	members     map[string]bool
+	loaded      bool
+	fetched     time.Time
+	invalidated bool
+	retries     int
+	retryTime   time.Time
+}
+
+type membershipCall struct {
+	done chan struct{}
+	err  error
+}
+
+func MakeMembershipCache(ttl time.Duration) *MembershipCache {
+	return &MembershipCache{
+		ttl:     ttl,
+		entries: make(map[string]*membershipEntry),
+		calls:   make(map[string]*membershipCall)}
+}
+
+// Returns the members for key, calling fetch if they aren't cached.
+func (c *MembershipCache) Members(key string, fetch func() ([]string, error)) (users []string, err error) {
+	e, err := c.entry(key, fetch)
+	if err != nil {
+		return
+	}
+	return e.users, nil
+}
+
+// Whether userID is a member for key, calling fetch if the members aren't
+// cached.
+func (c *MembershipCache) Contains(key string, userID string, fetch func() ([]string, error)) (ok bool, err error) {
+	e, err := c.entry(key, fetch)
+	if err != nil {
+		return
+	}
+	return e.members[userID], nil
+}
+
+func (c *MembershipCache) entry(key string, fetch func() ([]string, error)) (e membershipEntry, err error) {
+	c.mu.Lock()
+	now := time.Now()
+	cur, ok := c.entries[key]
+	if !ok {
+		cur = &membershipEntry{}
+		c.entries[key] = cur
+	}
+	retrying := now.Before(cur.retryTime)
+	switch {
+	case cur.loaded && !cur.invalidated && now.Sub(cur.fetched) < c.ttl:
+		c.stats.Hits++
+		e = *cur
+		c.mu.Unlock()
+		return
+	case cur.loaded && (retrying || !cur.invalidated):
+		// Expired, or Slack is failing: serve what we have, refetching in the
+		// background.
+		c.stats.Stale++
+		e = *cur
+		if !retrying {
+			c.startLocked(key, fetch)
+		}
+		c.mu.Unlock()
+		return
+	case retrying:
+		c.mu.Unlock()
+		err = fmt.Errorf("membership of %v unavailable, retrying at %v", key, cur.retryTime)
+		return
+	}
+	c.stats.Misses++
+	call := c.startLocked(key, fetch)
+	c.mu.Unlock()
+
+	<-call.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur = c.entries[key]
+	if !cur.loaded {
+		err = call.err
+		return
+	}
+	if call.err != nil {
+		// Invalidated, but the refetch failed.
+		c.stats.Stale++
+	}
+	return *cur, nil
+}
+
+// Must hold lock. Returns the fetch in progress for key, starting one if
+// there is none.
+func (c *MembershipCache) startLocked(key string, fetch func() ([]string, error)) *membershipCall {
+	if call, ok := c.calls[key]; ok {
+		return call
+	}
+	call := &membershipCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.stats.Fetches++
+	go c.fetch(key, fetch, call)
+	return call
+}
+
+func (c *MembershipCache) fetch(key string, fetch func() ([]string, error), call *membershipCall) {
+	users, err := fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.calls, key)
+	e := c.entries[key]
+	if err != nil {
+		c.stats.Errors++
+		e.retries++
+		e.retryTime = time.Now().Add(retryBackoff(e.retries))
+		glog.Errorf("Error fetching membership of %v (attempt %d, last known: %v): %v", key, e.retries, e.loaded, err)
+	} else {
+		e.users = users
+		e.members = make(map[string]bool, len(users))
+		for _, id := range users {
+			e.members[id] = true
+		}
+		e.loaded = true
+		e.fetched = time.Now()
+		e.invalidated = false
+		e.retries = 0
+		e.retryTime = time.Time{}
+		glog.V(1).Infof("Fetched membership of %v: %d members", key, len(users))
+	}
+	call.err = err
+	close(call.done)
+}
+
+// Marks the members for key as changed, e.g., on a workspace event. They're
+// refetched when next needed.
+func (c *MembershipCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && e.loaded {
+		glog.V(1).Infof("Invalidating membership of %v", key)
+		e.invalidated = true
+		e.retryTime = time.Time{}
+		c.stats.Invalidations++
+	}
+}
+
+func (c *MembershipCache) Stats() MembershipStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMembershipCacheSingleflight(t *testing.T) {
+	c := MakeMembershipCache(DefaultMembershipTTL)
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() ([]string, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return []string{"A1"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := c.Contains(ChannelMembersKey("C1"), "A1", fetch); !ok || err != nil {
+				t.Errorf("Expected member, got %v (%v)", ok, err)
+			}
+		}()
+	}
+	// Let the callers pile up on the fetch.
+	for c.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Expected 1 fetch, got %d", n)
+	}
+	c.Contains(ChannelMembersKey("C1"), "A1", fetch)
+	if s := c.Stats(); s.Fetches != 1 || s.Hits != 1 {
+		t.Fatalf("Unexpected stats: %v", s)
+	}
+}
+
+func TestMembershipCacheInvalidate(t *testing.T) {
+	c := MakeMembershipCache(DefaultMembershipTTL)
+	users := []string{"A1"}
+	fetch := func() ([]string, error) { return users, nil }
+
+	c.Members(ChannelMembersKey("C1"), fetch)
+	users = []string{"A2"}
+	if ok, _ := c.Contains(ChannelMembersKey("C1"), "A2", fetch); ok {
+		t.Fatalf("Membership refetched before invalidation")
+	}
+	c.Invalidate(ChannelMembersKey("C1"))
+	if ok, _ := c.Contains(ChannelMembersKey("C1"), "A2", fetch); !ok {
+		t.Fatalf("Membership not refetched after invalidation")
+	}
+	if s := c.Stats(); s.Invalidations != 1 || s.Fetches != 2 {
+		t.Fatalf("Unexpected stats: %v", s)
+	}
+}
+
+func TestMembershipCacheExpiry(t *testing.T) {
+	c := MakeMembershipCache(time.Nanosecond)
+	fetched := make(chan struct{}, 2)
+	var fetchErr error
+	fetch := func() ([]string, error) {
+		defer func() { fetched <- struct{}{} }()
+		return []string{"A1"}, fetchErr
+	}
+
+	c.Members(ChannelMembersKey("C1"), fetch)
+	<-fetched
+
+	// Expired entries are served while they're refetched, even if the refetch
+	// fails.
+	fetchErr = errors.New("timeout")
+	time.Sleep(time.Millisecond)
+	if ok, err := c.Contains(ChannelMembersKey("C1"), "A1", fetch); !ok || err != nil {
+		t.Fatalf("Expected stale membership, got %v (%v)", ok, err)
+	}
+	<-fetched
+	for c.Stats().Errors < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if ok, err := c.Contains(ChannelMembersKey("C1"), "A1", fetch); !ok || err != nil {
+		t.Fatalf("Expected last known membership, got %v (%v)", ok, err)
+	}
+	if s := c.Stats(); s.Stale != 2 || s.Fetches != 2 {
+		t.Fatalf("Unexpected stats: %v", s)
+	}
+}
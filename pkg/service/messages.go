@@ -0,0 +1,716 @@
+package service
+
+import (
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Supported locales.
+const (
+	English  = "en"
+	Spanish  = "es"
+	Mandarin = "zh"
+
+	DefaultLocale = English
+)
+
+// Keys of user-facing messages. The arguments available to each message's
+// template are noted.
+const (
+	MsgEnqueued      = "enqueued"       // Pos
+	MsgAlreadyQueued = "already_queued" // Pos
+	MsgEnqueuedAt    = "enqueued_at"    // Time
+	MsgNotifyButton  = "notify_button"
+	MsgAdded         = "added"         // User, Pos, Topic
+	MsgUpNext        = "up_next"       // User
+	MsgTopic         = "topic"         // Topic
+	MsgTimeInQueue   = "time_in_queue" // Wait
+	MsgDequeued      = "dequeued"      // Admin, User, Wait
+	MsgMatch         = "match"         // Student, Admin
+	MsgMatchLink     = "match_link"    // Link
+	MsgMeetingButton = "meeting_button"
+	MsgRemoved       = "removed" // Admin, Pos
+	MsgLeft          = "left"
+	MsgNotQueued     = "not_queued"
+	MsgLeftAdmin     = "left_admin"   // User, Pos
+	MsgLeftChannel   = "left_channel" // User, Pos
+	MsgPosition      = "position"     // Pos, Size
+
+	MsgNotifyMoved    = "notify_moved"   // From, To
+	MsgNotifySkipped  = "notify_skipped" // Pos
+	MsgNotifyNext     = "notify_next"
+	MsgNotifyPosition = "notify_position" // Pos
+	MsgNotifyOn       = "notify_on"       // At
+	MsgNotifyOff      = "notify_off"
+
+	MsgJoinText       = "join_text"
+	MsgWaitingNone    = "waiting_none"
+	MsgWaitingOne     = "waiting_one"
+	MsgWaitingMany    = "waiting_many"   // Size
+	MsgEstimatedWait  = "estimated_wait" // Wait
+	MsgJoinButton     = "join_button"
+	MsgLeaveButton    = "leave_button"
+	MsgPositionButton = "position_button"
+	MsgWaitUnder      = "wait_under_minute"
+	MsgWaitMinute     = "wait_minute"
+	MsgWaitMinutes    = "wait_minutes" // Minutes
+	MsgIntakeTitle    = "intake_title"
+	MsgIntakeSubmit   = "intake_submit"
+	MsgIntakeCancel   = "intake_cancel"
+
+	MsgQueueSummary   = "queue_summary" // Channel, Size, Oldest (empty if no one is waiting)
+	MsgStudentHome    = "student_home"  // Channel, Pos, Size, Wait
+	MsgHomeHeader     = "home_header"
+	MsgHomeEmpty      = "home_empty"
+	MsgHomeTruncated  = "home_truncated"
+	MsgPanelText      = "panel_text" // Channel
+	MsgPanelTruncated = "panel_truncated"
+	MsgListEmpty      = "list_empty"
+	MsgListEntry      = "list_entry" // Pos, User, Wait
+	MsgListTopic      = "list_topic" // Topic
+	MsgRemoveButton   = "remove_button"
+	MsgDequeueButton  = "dequeue_button"
+	MsgEscalateWait   = "escalate_wait" // Wait, Max
+	MsgEscalateSize   = "escalate_size" // Size, Max
+
+	MsgUsage         = "usage" // Command
+	MsgQueueExists   = "queue_exists"
+	MsgQueueCreated  = "queue_created"
+	MsgQueueDeleted  = "queue_deleted"
+	MsgNoQueue       = "no_queue"
+	MsgFormSet       = "form_set" // Form
+	MsgFormRemoved   = "form_removed"
+	MsgLocaleSet     = "locale_set" // Locale
+	MsgLocaleUser    = "locale_user"
+	MsgMessageSet    = "message_set"    // Key
+	MsgMessageReset  = "message_reset"  // Key
+	MsgNoQueueFor    = "no_queue_for"   // Channel, Command
+	MsgEscalateUsage = "escalate_usage" // Error, Command
+	MsgEscalateSet   = "escalate_set"   // Thresholds
+	MsgEscalateOff   = "escalate_off"
+	MsgMeUsage       = "me_usage"   // Command
+	MsgMeNoLink      = "me_no_link" // Usage
+	MsgMeLink        = "me_link"    // Link
+	MsgMeInvalid     = "me_invalid" // Text, Usage
+	MsgMeRemoved     = "me_removed"
+	MsgMeSet         = "me_set"         // Link
+	MsgPolicy        = "policy"         // Policy
+	MsgRoleUsage     = "role_usage"     // Command
+	MsgRoleDefault   = "role_default"   // Role
+	MsgRoleSet       = "role_set"       // Role, Members
+	MsgAllowUsage    = "allow_usage"    // Command
+	MsgAllowDefault  = "allow_default"  // Op
+	MsgAllowSet      = "allow_set"      // Roles, Op
+	MsgHistoryUsage  = "history_usage"  // Command
+	MsgHistoryEmpty  = "history_empty"  // User (empty for today)
+	MsgHistoryHeader = "history_header" // User (empty for today), Shown, Total (if not all are shown)
+	MsgHistoryEntry  = "history_entry"  // Time, User, Outcome, Admin, Wait, Topic
+
+	// Outcomes of visits, keyed by "outcome_" and the HistoryEntry's Outcome.
+	MsgOutcomeServed  = "outcome_served"
+	MsgOutcomeRemoved = "outcome_removed"
+	MsgOutcomeLeft    = "outcome_left"
+	MsgOutcomeExpired = "outcome_expired"
+
+	MsgPeriodToday        = "period_today"
+	MsgPeriodWeek         = "period_week"
+	MsgPeriodDay          = "period_day"   // Day
+	MsgPeriodDays         = "period_days"  // From, To
+	MsgStatsUsage         = "stats_usage"  // Command
+	MsgStatsHeader        = "stats_header" // Period
+	MsgStatsRange         = "stats_range"  // Channel, Since, Until
+	MsgStatsEmpty         = "stats_empty"
+	MsgStatsVisits        = "stats_visits"     // Total, Served, Removed, Left, Expired
+	MsgStatsThroughput    = "stats_throughput" // Rate, Hours
+	MsgStatsWait          = "stats_wait"       // Median, P90
+	MsgStatsPeak          = "stats_peak"       // Size, Time
+	MsgStatsHours         = "stats_hours"      // Hours
+	MsgStatsHour          = "stats_hour"       // Hour, Joined
+	MsgStatsSessions      = "stats_sessions"   // Sessions
+	MsgStatsPostFailed    = "stats_post_failed"
+	MsgStatsPosted        = "stats_posted" // Period, Channel
+	MsgPeriodAll          = "period_all"
+	MsgExportUsage        = "export_usage" // Command
+	MsgExportFailed       = "export_failed"
+	MsgExportTitle        = "export_title" // Period
+	MsgExportUploadFailed = "export_upload_failed"
+	MsgExported           = "exported" // Records, Period, Channel
+
+	MsgErrInternal   = "err_internal"   // ID
+	MsgErrPermission = "err_permission" // ID
+	MsgErrQueueEmpty = "err_queue_empty"
+	MsgErrStale      = "err_stale"
+	MsgErrNotFound   = "err_not_found"
+	MsgErrUpstream   = "err_upstream" // ID
+)
+
+// Template arguments.
+type Args map[string]interface{}
+
+var catalog = map[string]map[string]string{
+	English: {
+		MsgEnqueued:      "*Status:*\nOk! You're {{.Pos}} in the queue.",
+		MsgAlreadyQueued: "*Status:*\nYou are already queued at position {{.Pos}}.",
+		MsgEnqueuedAt:    "*Enqueued At:*\n{{.Time}}",
+		MsgNotifyButton:  "Notify me",
+		MsgAdded:         "{{.User}} added to queue in position {{.Pos}}{{if .Topic}} for {{.Topic}}{{end}}",
+		MsgUpNext:        "Ok! Up next is {{.User}}.",
+		MsgTopic:         "Topic: {{.Topic}}",
+		MsgTimeInQueue:   "Time spent in queue: {{.Wait}}",
+		MsgDequeued:      "{{.Admin}} dequeued {{.User}} (wait time {{.Wait}})",
+		MsgMatch:         "Hello {{.Student}}! You've been matched with {{.Admin}}.",
+		MsgMatchLink:     "Join their meeting at {{.Link}}",
+		MsgMeetingButton: "Join meeting",
+		MsgRemoved:       "{{.Admin}} removed position {{.Pos}}",
+		MsgLeft:          "You left the queue.",
+		MsgNotQueued:     "You aren't in the queue.",
+		MsgLeftAdmin:     "{{.User}} left the queue from position {{.Pos}}",
+		MsgLeftChannel:   "{{.User}} left the channel and was removed from position {{.Pos}}",
+		MsgPosition:      "You're {{.Pos}} of {{.Size}} in the queue.",
+
+		MsgNotifyMoved:    "An admin moved you from position {{.From}} to {{.To}} in the queue.",
+		MsgNotifySkipped:  "Someone behind you in the queue was helped or left before you. You're still {{.Pos}} in the queue.",
+		MsgNotifyNext:     "You're next in the queue!",
+		MsgNotifyPosition: "You're {{.Pos}} in the queue.",
+		MsgNotifyOn:       "I'll DM you when you're {{.At}} in the queue, when you're next, and if an admin moves you.",
+		MsgNotifyOff:      "I'll stop sending you queue notifications.",
+
+		MsgJoinText:       "Join the queue",
+		MsgWaitingNone:    "*No one is waiting.*",
+		MsgWaitingOne:     "*1 person is waiting.*",
+		MsgWaitingMany:    "*{{.Size}} people are waiting.*",
+		MsgEstimatedWait:  "Estimated wait: {{.Wait}}",
+		MsgJoinButton:     "Join",
+		MsgLeaveButton:    "Leave",
+		MsgPositionButton: "My position",
+		MsgWaitUnder:      "less than a minute",
+		MsgWaitMinute:     "about 1 minute",
+		MsgWaitMinutes:    "about {{.Minutes}} minutes",
+		MsgIntakeTitle:    "Join the queue",
+		MsgIntakeSubmit:   "Join",
+		MsgIntakeCancel:   "Cancel",
+
+		MsgQueueSummary:   "*<#{{.Channel}}>*\n{{.Size}} waiting{{if .Oldest}}, oldest waiting {{.Oldest}}{{end}}",
+		MsgStudentHome:    "*<#{{.Channel}}>*\nYou're {{.Pos}} of {{.Size}} in the queue (waiting {{.Wait}}).",
+		MsgHomeHeader:     "Queues",
+		MsgHomeEmpty:      "You aren't waiting in any queues.",
+		MsgHomeTruncated:  "Some queues are not shown; list them in their channels.",
+		MsgPanelText:      "Queue for <#{{.Channel}}>",
+		MsgPanelTruncated: "Some users are not shown; use the list command to see the full queue.",
+		MsgListEmpty:      "No users in queue.",
+		MsgListEntry:      "*{{.Pos}}:* {{.User}}\n*Wait time:* {{.Wait}}\n",
+		MsgListTopic:      "*Topic:* {{.Topic}}",
+		MsgRemoveButton:   "Remove",
+		MsgDequeueButton:  "Dequeue",
+		MsgEscalateWait:   ":rotating_light: The oldest entry in the queue has waited {{.Wait}} (threshold {{.Max}}).",
+		MsgEscalateSize:   ":rotating_light: {{.Size}} people are waiting in the queue (threshold {{.Max}}).",
+
+		MsgUsage:              "Usage: {{.Command}} create [adminChannelName] | delete | post | form [Question; Question (optional); ...] | escalate [wait=20m] [size=10] [mention=@group] [cooldown=15m] | escalate off | locale [en|es|zh|user] | message key [template] | role [role] [#channel @group @user ... | default] | allow operation [role ...] | history [@user | today] | stats [today | week | yyyy-mm-dd..yyyy-mm-dd] | export [csv | json] [all | week | ...] | me link [url]",
+		MsgQueueExists:        "Queue already exists in this channel.",
+		MsgQueueCreated:       "Queue created for channel.",
+		MsgQueueDeleted:       "Deleted this channel's queue.",
+		MsgNoQueue:            "No queue exists in this channel.",
+		MsgFormSet:            "Students joining with an empty topic will be asked: {{.Form}}",
+		MsgFormRemoved:        "Removed the intake form; students will be enqueued directly.",
+		MsgLocaleSet:          "Messages for this queue will be sent in English.",
+		MsgLocaleUser:         "Messages for this queue will be sent in each user's language.",
+		MsgMessageSet:         "Updated message {{.Key}} for this queue.",
+		MsgMessageReset:       "Restored the default message {{.Key}} for this queue.",
+		MsgNoQueueFor:         "No queue exists for channel {{.Channel}}, use {{.Command}} to create one.",
+		MsgEscalateUsage:      "{{.Error}}. Usage: {{.Command}} escalate [wait=20m] [size=10] [mention=@group] [cooldown=15m] | escalate off",
+		MsgEscalateSet:        "Admins will be alerted when the queue exceeds: {{.Thresholds}}",
+		MsgEscalateOff:        "Removed escalation thresholds.",
+		MsgMeUsage:            "Usage: {{.Command}} me link [url], where the url may contain {student}, {admin} and {topic}.",
+		MsgMeNoLink:           "You have no meeting link. {{.Usage}}",
+		MsgMeLink:             "Your meeting link is {{.Link}}",
+		MsgMeInvalid:          "{{printf \"%q\" .Text}} isn't a valid link. {{.Usage}}",
+		MsgMeRemoved:          "Removed your meeting link.",
+		MsgMeSet:              "Students you dequeue will be sent {{.Link}}",
+		MsgPolicy:             "Permissions for this queue:\n{{.Policy}}",
+		MsgRoleUsage:          "Usage: {{.Command}} role [owner|ta|observer|student] [#channel @group @user ... | default]",
+		MsgRoleDefault:        "Role {{.Role}} restored to its default.",
+		MsgRoleSet:            "Role {{.Role}} is now: {{.Members}}",
+		MsgAllowUsage:         "Usage: {{.Command}} allow [put|list|take|remove|move|join|leave|position|notify|manage|history|stats] [owner|ta|observer|student ... | default]",
+		MsgAllowDefault:       "Permission to {{.Op}} restored to its default.",
+		MsgAllowSet:           "Owners and {{.Roles}} may now {{.Op}}.",
+		MsgHistoryUsage:       "Usage: {{.Command}} history [@user | today]",
+		MsgHistoryEmpty:       "No visits to this queue {{if .User}}for <@{{.User}}>{{else}}today{{end}}.",
+		MsgHistoryHeader:      "Visits to this queue {{if .User}}for <@{{.User}}>{{else}}today{{end}}{{if .Shown}} (last {{.Shown}} of {{.Total}}){{end}}:",
+		MsgHistoryEntry:       "• {{.Time}} <@{{.User}}> {{.Outcome}}{{if .Admin}} by <@{{.Admin}}>{{end}} after {{.Wait}}{{if .Topic}}: {{.Topic}}{{end}}",
+		MsgOutcomeServed:      "served",
+		MsgOutcomeRemoved:     "removed",
+		MsgOutcomeLeft:        "left",
+		MsgOutcomeExpired:     "expired",
+		MsgPeriodToday:        "today",
+		MsgPeriodWeek:         "for the last 7 days",
+		MsgPeriodDay:          "for {{.Day}}",
+		MsgPeriodDays:         "for {{.From}} to {{.To}}",
+		MsgStatsUsage:         "Usage: {{.Command}} stats [today | week | yyyy-mm-dd..yyyy-mm-dd]",
+		MsgStatsHeader:        "Queue stats {{.Period}}",
+		MsgStatsRange:         "<#{{.Channel}}>, {{.Since}} to {{.Until}}",
+		MsgStatsEmpty:         "No visits to this queue in this period.",
+		MsgStatsVisits:        "*Visits:*\n{{.Total}} ({{.Served}} served, {{.Removed}} removed, {{.Left}} left, {{.Expired}} expired)",
+		MsgStatsThroughput:    "*Throughput:*\n{{.Rate}} served/hour over {{.Hours}} active hours",
+		MsgStatsWait:          "*Wait:*\nmedian {{.Median}}, p90 {{.P90}}",
+		MsgStatsPeak:          "*Peak length:*\n{{.Size}} at {{.Time}}",
+		MsgStatsHours:         "*Busiest hours:*\n{{.Hours}}",
+		MsgStatsHour:          "{{.Hour}} ({{.Joined}} joined)",
+		MsgStatsSessions:      "*Sessions served:*\n{{.Sessions}}",
+		MsgStatsPostFailed:    "Couldn't post stats to the admin channel.",
+		MsgStatsPosted:        "Posted stats {{.Period}} to <#{{.Channel}}>.",
+		MsgPeriodAll:          "to date",
+		MsgExportUsage:        "Usage: {{.Command}} export [csv | json] [all | today | week | yyyy-mm-dd..yyyy-mm-dd]",
+		MsgExportFailed:       "Couldn't export this queue.",
+		MsgExportTitle:        "Queue history {{.Period}}",
+		MsgExportUploadFailed: "Couldn't upload the export.",
+		MsgExported:           "Exported {{.Records}} records {{.Period}} to <#{{.Channel}}>.",
+
+		MsgErrInternal:   "Sorry, something went wrong. If it keeps happening, let the course staff know (reference {{.ID}}).",
+		MsgErrPermission: "Sorry, only this queue's admins can do that (reference {{.ID}}).",
+		MsgErrQueueEmpty: "The queue is empty.",
+		MsgErrStale:      "The queue changed since this list was shown. List the queue again and retry.",
+		MsgErrNotFound:   "That person is no longer in the queue.",
+		MsgErrUpstream:   "Slack didn't respond as expected. Please try again in a moment (reference {{.ID}}).",
+	},
+	Spanish: {
+		MsgEnqueued:      "*Estado:*\n¡Listo! Estás en la posición {{.Pos}} de la cola.",
+		MsgAlreadyQueued: "*Estado:*\nYa estás en la cola en la posición {{.Pos}}.",
+		MsgEnqueuedAt:    "*En la cola desde:*\n{{.Time}}",
+		MsgNotifyButton:  "Avísame",
+		MsgAdded:         "{{.User}} se unió a la cola en la posición {{.Pos}}{{if .Topic}} por {{.Topic}}{{end}}",
+		MsgUpNext:        "¡Listo! Sigue {{.User}}.",
+		MsgTopic:         "Tema: {{.Topic}}",
+		MsgTimeInQueue:   "Tiempo en la cola: {{.Wait}}",
+		MsgDequeued:      "{{.Admin}} atendió a {{.User}} (tiempo de espera {{.Wait}})",
+		MsgMatch:         "¡Hola {{.Student}}! Te asignamos a {{.Admin}}.",
+		MsgMatchLink:     "Únete a su reunión en {{.Link}}",
+		MsgMeetingButton: "Unirse a la reunión",
+		MsgRemoved:       "{{.Admin}} eliminó la posición {{.Pos}}",
+		MsgLeft:          "Saliste de la cola.",
+		MsgNotQueued:     "No estás en la cola.",
+		MsgLeftAdmin:     "{{.User}} salió de la cola desde la posición {{.Pos}}",
+		MsgLeftChannel:   "{{.User}} salió del canal y fue eliminado de la posición {{.Pos}}",
+		MsgPosition:      "Estás en la posición {{.Pos}} de {{.Size}} en la cola.",
+
+		MsgNotifyMoved:    "Un administrador te movió de la posición {{.From}} a la {{.To}} en la cola.",
+		MsgNotifySkipped:  "Atendieron antes que a ti a alguien que estaba detrás, o se fue. Sigues en la posición {{.Pos}} de la cola.",
+		MsgNotifyNext:     "¡Eres el siguiente en la cola!",
+		MsgNotifyPosition: "Estás en la posición {{.Pos}} de la cola.",
+		MsgNotifyOn:       "Te enviaré un mensaje directo cuando estés en la posición {{.At}}, cuando seas el siguiente y si un administrador te mueve.",
+		MsgNotifyOff:      "Dejaré de enviarte notificaciones de la cola.",
+
+		MsgJoinText:       "Únete a la cola",
+		MsgWaitingNone:    "*No hay nadie esperando.*",
+		MsgWaitingOne:     "*1 persona está esperando.*",
+		MsgWaitingMany:    "*{{.Size}} personas están esperando.*",
+		MsgEstimatedWait:  "Espera estimada: {{.Wait}}",
+		MsgJoinButton:     "Unirse",
+		MsgLeaveButton:    "Salir",
+		MsgPositionButton: "Mi posición",
+		MsgWaitUnder:      "menos de un minuto",
+		MsgWaitMinute:     "alrededor de 1 minuto",
+		MsgWaitMinutes:    "alrededor de {{.Minutes}} minutos",
+		MsgIntakeTitle:    "Únete a la cola",
+		MsgIntakeSubmit:   "Unirse",
+		MsgIntakeCancel:   "Cancelar",
+
+		MsgQueueSummary:   "*<#{{.Channel}}>*\n{{.Size}} en espera{{if .Oldest}}, la espera más larga es de {{.Oldest}}{{end}}",
+		MsgStudentHome:    "*<#{{.Channel}}>*\nEstás en la posición {{.Pos}} de {{.Size}} en la cola (esperando {{.Wait}}).",
+		MsgHomeHeader:     "Colas",
+		MsgHomeEmpty:      "No estás esperando en ninguna cola.",
+		MsgHomeTruncated:  "Algunas colas no se muestran; lístalas en sus canales.",
+		MsgPanelText:      "Cola de <#{{.Channel}}>",
+		MsgPanelTruncated: "Algunos usuarios no se muestran; usa el comando list para ver la cola completa.",
+		MsgListEmpty:      "No hay usuarios en la cola.",
+		MsgListEntry:      "*{{.Pos}}:* {{.User}}\n*Tiempo de espera:* {{.Wait}}\n",
+		MsgListTopic:      "*Tema:* {{.Topic}}",
+		MsgRemoveButton:   "Eliminar",
+		MsgDequeueButton:  "Atender",
+		MsgEscalateWait:   ":rotating_light: La entrada más antigua de la cola lleva {{.Wait}} esperando (umbral {{.Max}}).",
+		MsgEscalateSize:   ":rotating_light: {{.Size}} personas están esperando en la cola (umbral {{.Max}}).",
+
+		MsgUsage:              "Uso: {{.Command}} create [canalDeAdministración] | delete | post | form [Pregunta; Pregunta (optional); ...] | escalate [wait=20m] [size=10] [mention=@grupo] [cooldown=15m] | escalate off | locale [en|es|zh|user] | message clave [plantilla] | role [rol] [#canal @grupo @usuario ... | default] | allow operación [rol ...] | history [@usuario | today] | stats [today | week | aaaa-mm-dd..aaaa-mm-dd] | export [csv | json] [all | week | ...] | me link [url]",
+		MsgQueueExists:        "Ya existe una cola en este canal.",
+		MsgQueueCreated:       "Se creó la cola del canal.",
+		MsgQueueDeleted:       "Se eliminó la cola de este canal.",
+		MsgNoQueue:            "No hay ninguna cola en este canal.",
+		MsgFormSet:            "A quienes se unan sin tema se les preguntará: {{.Form}}",
+		MsgFormRemoved:        "Se eliminó el formulario; los estudiantes se unirán directamente.",
+		MsgLocaleSet:          "Los mensajes de esta cola se enviarán en español.",
+		MsgLocaleUser:         "Los mensajes de esta cola se enviarán en el idioma de cada usuario.",
+		MsgMessageSet:         "Se actualizó el mensaje {{.Key}} de esta cola.",
+		MsgMessageReset:       "Se restauró el mensaje predeterminado {{.Key}} de esta cola.",
+		MsgNoQueueFor:         "No existe una cola para el canal {{.Channel}}; usa {{.Command}} para crear una.",
+		MsgEscalateUsage:      "{{.Error}}. Uso: {{.Command}} escalate [wait=20m] [size=10] [mention=@grupo] [cooldown=15m] | escalate off",
+		MsgEscalateSet:        "Se alertará a los administradores cuando la cola supere: {{.Thresholds}}",
+		MsgEscalateOff:        "Se eliminaron los umbrales de escalamiento.",
+		MsgMeUsage:            "Uso: {{.Command}} me link [url], donde la url puede contener {student}, {admin} y {topic}.",
+		MsgMeNoLink:           "No tienes un enlace de reunión. {{.Usage}}",
+		MsgMeLink:             "Tu enlace de reunión es {{.Link}}",
+		MsgMeInvalid:          "{{printf \"%q\" .Text}} no es un enlace válido. {{.Usage}}",
+		MsgMeRemoved:          "Se eliminó tu enlace de reunión.",
+		MsgMeSet:              "Los estudiantes que atiendas recibirán {{.Link}}",
+		MsgPolicy:             "Permisos de esta cola:\n{{.Policy}}",
+		MsgRoleUsage:          "Uso: {{.Command}} role [owner|ta|observer|student] [#canal @grupo @usuario ... | default]",
+		MsgRoleDefault:        "Se restauró el valor predeterminado del rol {{.Role}}.",
+		MsgRoleSet:            "El rol {{.Role}} ahora es: {{.Members}}",
+		MsgAllowUsage:         "Uso: {{.Command}} allow [put|list|take|remove|move|join|leave|position|notify|manage|history|stats] [owner|ta|observer|student ... | default]",
+		MsgAllowDefault:       "Se restauró el permiso predeterminado para {{.Op}}.",
+		MsgAllowSet:           "Los propietarios y {{.Roles}} ahora pueden usar {{.Op}}.",
+		MsgHistoryUsage:       "Uso: {{.Command}} history [@usuario | today]",
+		MsgHistoryEmpty:       "No hay visitas a esta cola {{if .User}}de <@{{.User}}>{{else}}hoy{{end}}.",
+		MsgHistoryHeader:      "Visitas a esta cola {{if .User}}de <@{{.User}}>{{else}}hoy{{end}}{{if .Shown}} (últimas {{.Shown}} de {{.Total}}){{end}}:",
+		MsgHistoryEntry:       "• {{.Time}} <@{{.User}}> {{.Outcome}}{{if .Admin}} por <@{{.Admin}}>{{end}} tras {{.Wait}}{{if .Topic}}: {{.Topic}}{{end}}",
+		MsgOutcomeServed:      "atendido",
+		MsgOutcomeRemoved:     "eliminado",
+		MsgOutcomeLeft:        "salió",
+		MsgOutcomeExpired:     "expiró",
+		MsgPeriodToday:        "de hoy",
+		MsgPeriodWeek:         "de los últimos 7 días",
+		MsgPeriodDay:          "del {{.Day}}",
+		MsgPeriodDays:         "del {{.From}} al {{.To}}",
+		MsgStatsUsage:         "Uso: {{.Command}} stats [today | week | aaaa-mm-dd..aaaa-mm-dd]",
+		MsgStatsHeader:        "Estadísticas de la cola {{.Period}}",
+		MsgStatsRange:         "<#{{.Channel}}>, del {{.Since}} al {{.Until}}",
+		MsgStatsEmpty:         "No hubo visitas a esta cola en este periodo.",
+		MsgStatsVisits:        "*Visitas:*\n{{.Total}} ({{.Served}} atendidas, {{.Removed}} eliminadas, {{.Left}} abandonadas, {{.Expired}} expiradas)",
+		MsgStatsThroughput:    "*Ritmo:*\n{{.Rate}} atendidas por hora en {{.Hours}} horas activas",
+		MsgStatsWait:          "*Espera:*\nmediana {{.Median}}, p90 {{.P90}}",
+		MsgStatsPeak:          "*Longitud máxima:*\n{{.Size}} a las {{.Time}}",
+		MsgStatsHours:         "*Horas con más actividad:*\n{{.Hours}}",
+		MsgStatsHour:          "{{.Hour}} ({{.Joined}} se unieron)",
+		MsgStatsSessions:      "*Sesiones atendidas:*\n{{.Sessions}}",
+		MsgStatsPostFailed:    "No se pudieron publicar las estadísticas en el canal de administración.",
+		MsgStatsPosted:        "Se publicaron las estadísticas {{.Period}} en <#{{.Channel}}>.",
+		MsgPeriodAll:          "hasta la fecha",
+		MsgExportUsage:        "Uso: {{.Command}} export [csv | json] [all | today | week | aaaa-mm-dd..aaaa-mm-dd]",
+		MsgExportFailed:       "No se pudo exportar esta cola.",
+		MsgExportTitle:        "Historial de la cola {{.Period}}",
+		MsgExportUploadFailed: "No se pudo subir la exportación.",
+		MsgExported:           "Se exportaron {{.Records}} registros {{.Period}} a <#{{.Channel}}>.",
+
+		MsgErrInternal:   "Lo sentimos, algo salió mal. Si sigue ocurriendo, avisa al equipo del curso (referencia {{.ID}}).",
+		MsgErrPermission: "Lo sentimos, solo los administradores de esta cola pueden hacer eso (referencia {{.ID}}).",
+		MsgErrQueueEmpty: "La cola está vacía.",
+		MsgErrStale:      "La cola cambió desde que se mostró esta lista. Vuelve a listar la cola e inténtalo de nuevo.",
+		MsgErrNotFound:   "Esa persona ya no está en la cola.",
+		MsgErrUpstream:   "Slack no respondió como se esperaba. Inténtalo de nuevo en un momento (referencia {{.ID}}).",
+	},
+	Mandarin: {
+		MsgEnqueued:      "*状态：*\n好的！你在队列中排第 {{.Pos}} 位。",
+		MsgAlreadyQueued: "*状态：*\n你已经在队列中，排第 {{.Pos}} 位。",
+		MsgEnqueuedAt:    "*排队时间：*\n{{.Time}}",
+		MsgNotifyButton:  "通知我",
+		MsgAdded:         "{{.User}} 加入队列，排第 {{.Pos}} 位{{if .Topic}}，问题：{{.Topic}}{{end}}",
+		MsgUpNext:        "好的！下一位是 {{.User}}。",
+		MsgTopic:         "问题：{{.Topic}}",
+		MsgTimeInQueue:   "排队时长：{{.Wait}}",
+		MsgDequeued:      "{{.Admin}} 接待了 {{.User}}（等待时长 {{.Wait}}）",
+		MsgMatch:         "{{.Student}} 你好！已为你安排 {{.Admin}}。",
+		MsgMatchLink:     "请通过 {{.Link}} 加入会议",
+		MsgMeetingButton: "加入会议",
+		MsgRemoved:       "{{.Admin}} 移除了第 {{.Pos}} 位",
+		MsgLeft:          "你已离开队列。",
+		MsgNotQueued:     "你不在队列中。",
+		MsgLeftAdmin:     "{{.User}} 从第 {{.Pos}} 位离开了队列",
+		MsgLeftChannel:   "{{.User}} 已离开频道，并从第 {{.Pos}} 位移除",
+		MsgPosition:      "你在队列中排第 {{.Pos}} 位，共 {{.Size}} 人。",
+
+		MsgNotifyMoved:    "管理员将你在队列中的位置从第 {{.From}} 位调整到第 {{.To}} 位。",
+		MsgNotifySkipped:  "排在你后面的人已先被接待或已离开。你仍在队列中排第 {{.Pos}} 位。",
+		MsgNotifyNext:     "下一位就是你！",
+		MsgNotifyPosition: "你在队列中排第 {{.Pos}} 位。",
+		MsgNotifyOn:       "当你排到第 {{.At}} 位、轮到你下一位，或管理员调整你的位置时，我会私信通知你。",
+		MsgNotifyOff:      "我将不再向你发送队列通知。",
+
+		MsgJoinText:       "加入队列",
+		MsgWaitingNone:    "*目前无人等待。*",
+		MsgWaitingOne:     "*有 1 人在等待。*",
+		MsgWaitingMany:    "*有 {{.Size}} 人在等待。*",
+		MsgEstimatedWait:  "预计等待：{{.Wait}}",
+		MsgJoinButton:     "加入",
+		MsgLeaveButton:    "离开",
+		MsgPositionButton: "我的位置",
+		MsgWaitUnder:      "不到 1 分钟",
+		MsgWaitMinute:     "约 1 分钟",
+		MsgWaitMinutes:    "约 {{.Minutes}} 分钟",
+		MsgIntakeTitle:    "加入队列",
+		MsgIntakeSubmit:   "加入",
+		MsgIntakeCancel:   "取消",
+
+		MsgQueueSummary:   "*<#{{.Channel}}>*\n{{.Size}} 人等待中{{if .Oldest}}，最长已等待 {{.Oldest}}{{end}}",
+		MsgStudentHome:    "*<#{{.Channel}}>*\n你在队列中排第 {{.Pos}} 位，共 {{.Size}} 人（已等待 {{.Wait}}）。",
+		MsgHomeHeader:     "队列",
+		MsgHomeEmpty:      "你没有在任何队列中等待。",
+		MsgHomeTruncated:  "部分队列未显示；请在各自的频道中列出。",
+		MsgPanelText:      "<#{{.Channel}}> 的队列",
+		MsgPanelTruncated: "部分用户未显示；请使用 list 命令查看完整队列。",
+		MsgListEmpty:      "队列中没有用户。",
+		MsgListEntry:      "*{{.Pos}}:* {{.User}}\n*等待时长：* {{.Wait}}\n",
+		MsgListTopic:      "*问题：* {{.Topic}}",
+		MsgRemoveButton:   "移除",
+		MsgDequeueButton:  "接待",
+		MsgEscalateWait:   ":rotating_light: 队列中最早的一位已等待 {{.Wait}}（阈值 {{.Max}}）。",
+		MsgEscalateSize:   ":rotating_light: 队列中有 {{.Size}} 人在等待（阈值 {{.Max}}）。",
+
+		MsgUsage:              "用法：{{.Command}} create [管理频道] | delete | post | form [问题; 问题 (optional); ...] | escalate [wait=20m] [size=10] [mention=@用户组] [cooldown=15m] | escalate off | locale [en|es|zh|user] | message 键 [模板] | role [角色] [#频道 @用户组 @用户 ... | default] | allow 操作 [角色 ...] | history [@用户 | today] | stats [today | week | yyyy-mm-dd..yyyy-mm-dd] | export [csv | json] [all | week | ...] | me link [网址]",
+		MsgQueueExists:        "此频道已有队列。",
+		MsgQueueCreated:       "已为此频道创建队列。",
+		MsgQueueDeleted:       "已删除此频道的队列。",
+		MsgNoQueue:            "此频道没有队列。",
+		MsgFormSet:            "未填写问题就加入的学生将被询问：{{.Form}}",
+		MsgFormRemoved:        "已删除登记表；学生将直接加入队列。",
+		MsgLocaleSet:          "此队列的消息将以中文发送。",
+		MsgLocaleUser:         "此队列的消息将以每位用户的语言发送。",
+		MsgMessageSet:         "已更新此队列的消息 {{.Key}}。",
+		MsgMessageReset:       "已恢复此队列的默认消息 {{.Key}}。",
+		MsgNoQueueFor:         "频道 {{.Channel}} 没有队列，请使用 {{.Command}} 创建。",
+		MsgEscalateUsage:      "{{.Error}}。用法：{{.Command}} escalate [wait=20m] [size=10] [mention=@用户组] [cooldown=15m] | escalate off",
+		MsgEscalateSet:        "当队列超过以下阈值时将提醒管理员：{{.Thresholds}}",
+		MsgEscalateOff:        "已删除升级提醒阈值。",
+		MsgMeUsage:            "用法：{{.Command}} me link [网址]，网址中可包含 {student}、{admin} 和 {topic}。",
+		MsgMeNoLink:           "你还没有会议链接。{{.Usage}}",
+		MsgMeLink:             "你的会议链接是 {{.Link}}",
+		MsgMeInvalid:          "{{printf \"%q\" .Text}} 不是有效的链接。{{.Usage}}",
+		MsgMeRemoved:          "已删除你的会议链接。",
+		MsgMeSet:              "你接待的学生将收到 {{.Link}}",
+		MsgPolicy:             "此队列的权限：\n{{.Policy}}",
+		MsgRoleUsage:          "用法：{{.Command}} role [owner|ta|observer|student] [#频道 @用户组 @用户 ... | default]",
+		MsgRoleDefault:        "角色 {{.Role}} 已恢复默认设置。",
+		MsgRoleSet:            "角色 {{.Role}} 现在是：{{.Members}}",
+		MsgAllowUsage:         "用法：{{.Command}} allow [put|list|take|remove|move|join|leave|position|notify|manage|history|stats] [owner|ta|observer|student ... | default]",
+		MsgAllowDefault:       "{{.Op}} 权限已恢复默认设置。",
+		MsgAllowSet:           "所有者和 {{.Roles}} 现在可以执行 {{.Op}}。",
+		MsgHistoryUsage:       "用法：{{.Command}} history [@用户 | today]",
+		MsgHistoryEmpty:       "{{if .User}}<@{{.User}}> 没有访问此队列的记录{{else}}今天没有访问此队列的记录{{end}}。",
+		MsgHistoryHeader:      "{{if .User}}<@{{.User}}> 访问此队列的记录{{else}}今天访问此队列的记录{{end}}{{if .Shown}}（最近 {{.Shown}} 条，共 {{.Total}} 条）{{end}}：",
+		MsgHistoryEntry:       "• {{.Time}} <@{{.User}}> {{.Outcome}}{{if .Admin}}（由 <@{{.Admin}}>）{{end}}，等待 {{.Wait}}{{if .Topic}}：{{.Topic}}{{end}}",
+		MsgOutcomeServed:      "已接待",
+		MsgOutcomeRemoved:     "已移除",
+		MsgOutcomeLeft:        "已离开",
+		MsgOutcomeExpired:     "已过期",
+		MsgPeriodToday:        "（今天）",
+		MsgPeriodWeek:         "（最近 7 天）",
+		MsgPeriodDay:          "（{{.Day}}）",
+		MsgPeriodDays:         "（{{.From}} 至 {{.To}}）",
+		MsgStatsUsage:         "用法：{{.Command}} stats [today | week | yyyy-mm-dd..yyyy-mm-dd]",
+		MsgStatsHeader:        "队列统计{{.Period}}",
+		MsgStatsRange:         "<#{{.Channel}}>，{{.Since}} 至 {{.Until}}",
+		MsgStatsEmpty:         "此期间没有访问此队列的记录。",
+		MsgStatsVisits:        "*访问次数：*\n{{.Total}}（已接待 {{.Served}}，已移除 {{.Removed}}，已离开 {{.Left}}，已过期 {{.Expired}}）",
+		MsgStatsThroughput:    "*接待速度：*\n{{.Hours}} 个活跃小时内每小时接待 {{.Rate}} 人",
+		MsgStatsWait:          "*等待时长：*\n中位数 {{.Median}}，p90 {{.P90}}",
+		MsgStatsPeak:          "*最长队列：*\n{{.Time}} 时 {{.Size}} 人",
+		MsgStatsHours:         "*最繁忙时段：*\n{{.Hours}}",
+		MsgStatsHour:          "{{.Hour}}（{{.Joined}} 人加入）",
+		MsgStatsSessions:      "*接待次数：*\n{{.Sessions}}",
+		MsgStatsPostFailed:    "无法将统计信息发布到管理频道。",
+		MsgStatsPosted:        "已将统计信息{{.Period}}发布到 <#{{.Channel}}>。",
+		MsgPeriodAll:          "（截至目前）",
+		MsgExportUsage:        "用法：{{.Command}} export [csv | json] [all | today | week | yyyy-mm-dd..yyyy-mm-dd]",
+		MsgExportFailed:       "无法导出此队列。",
+		MsgExportTitle:        "队列历史{{.Period}}",
+		MsgExportUploadFailed: "无法上传导出文件。",
+		MsgExported:           "已将 {{.Records}} 条记录{{.Period}}导出到 <#{{.Channel}}>。",
+
+		MsgErrInternal:   "抱歉，出现了问题。如果问题持续出现，请告知课程工作人员（参考编号 {{.ID}}）。",
+		MsgErrPermission: "抱歉，只有此队列的管理员可以执行该操作（参考编号 {{.ID}}）。",
+		MsgErrQueueEmpty: "队列为空。",
+		MsgErrStale:      "此列表显示后队列已发生变化。请重新列出队列后再试。",
+		MsgErrNotFound:   "此人已不在队列中。",
+		MsgErrUpstream:   "Slack 未按预期响应。请稍后再试（参考编号 {{.ID}}）。",
+	},
+}
+
+// Parsed templates, keyed by template text.
+var templates sync.Map
+
+func parseTemplate(text string) (t *template.Template, err error) {
+	if v, ok := templates.Load(text); ok {
+		return v.(*template.Template), nil
+	}
+	t, err = template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return
+	}
+	templates.Store(text, t)
+	return
+}
+
+func execute(text string, args Args) (msg string, err error) {
+	t, err := parseTemplate(text)
+	if err != nil {
+		return
+	}
+	var b bytes.Buffer
+	if err = t.Execute(&b, args); err != nil {
+		return
+	}
+	msg = b.String()
+	return
+}
+
+// Renders a message from the catalog.
+func Message(locale string, key string, args Args) string {
+	return render("", locale, key, args)
+}
+
+// Renders override if given, otherwise the catalog's message, falling back to
+// the default locale.
+func render(override string, locale string, key string, args Args) string {
+	if override != "" {
+		msg, err := execute(override, args)
+		if err == nil {
+			return msg
+		}
+		glog.Errorf("Error rendering override of message %v: %v", key, err)
+	}
+	text, ok := catalog[locale][key]
+	if !ok {
+		text = catalog[DefaultLocale][key]
+	}
+	msg, err := execute(text, args)
+	if err != nil {
+		glog.Errorf("Error rendering message %v (%v): %v", key, locale, err)
+	}
+	return msg
+}
+
+// Returns the supported locale matching a Slack locale (e.g., "es-LA"), or the
+// default locale.
+func MatchLocale(locale string) string {
+	lang := strings.ToLower(strings.SplitN(strings.Replace(locale, "_", "-", 1), "-", 2)[0])
+	if _, ok := catalog[lang]; ok {
+		return lang
+	}
+	return DefaultLocale
+}
+
+// Whether locale is supported.
+func IsLocale(locale string) bool {
+	_, ok := catalog[locale]
+	return ok
+}
+
+// Per-queue message settings.
+type MessageSettings struct {
+	// Locale of all messages in the queue. If empty, messages to a user are in
+	// their own locale and other messages are in the default locale.
+	Locale    string            `json:"Locale,omitempty"`
+	Overrides map[string]string `json:"Overrides,omitempty"`
+}
+
+func (s *QueueService) Messages() (m MessageSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.Locale = s.messages.Locale
+	if len(s.messages.Overrides) > 0 {
+		m.Overrides = make(map[string]string, len(s.messages.Overrides))
+		for k, v := range s.messages.Overrides {
+			m.Overrides[k] = v
+		}
+	}
+	return
+}
+
+func (s *QueueService) SetMessages(m MessageSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = m
+}
+
+// Sets the locale of the queue's messages. An empty locale uses each user's.
+func (s *QueueService) SetLocale(locale string) (err error) {
+	if locale != "" && !IsLocale(locale) {
+		return errors.New(fmt.Sprintf("Unknown locale '%v'", locale))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages.Locale = locale
+	return
+}
+
+// Overrides a message in all locales. An empty template restores the
+// catalog's message.
+func (s *QueueService) SetOverride(key string, text string) (err error) {
+	if _, ok := catalog[DefaultLocale][key]; !ok {
+		return errors.New(fmt.Sprintf("Unknown message '%v'; messages are %v", key, strings.Join(MessageKeys(), ", ")))
+	}
+	if text != "" {
+		if _, err = parseTemplate(text); err != nil {
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if text == "" {
+		delete(s.messages.Overrides, key)
+		return
+	}
+	if s.messages.Overrides == nil {
+		s.messages.Overrides = make(map[string]string)
+	}
+	s.messages.Overrides[key] = text
+	return
+}
+
+func MessageKeys() (keys []string) {
+	for k := range catalog[DefaultLocale] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Renders a message for the queue in locale, applying the queue's overrides.
+func (s *QueueService) Msg(locale string, key string, args Args) string {
+	s.mu.Lock()
+	override := s.messages.Overrides[key]
+	s.mu.Unlock()
+	return render(override, locale, key, args)
+}
+
+// Locale of messages not addressed to a single user, e.g., admin messages.
+func (s *QueueService) Locale() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages.Locale != "" {
+		return s.messages.Locale
+	}
+	return DefaultLocale
+}
+
+// Locale of messages to user, who should have been looked up with users.info.
+func (s *QueueService) UserLocale(user *slack.User) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages.Locale != "" {
+		return s.messages.Locale
+	}
+	if user == nil {
+		return DefaultLocale
+	}
+	return MatchLocale(user.Locale)
+}
+
+// Locale of messages to the user with the given ID, looking them up if the
+// queue doesn't set a locale.
+func (s *QueueService) LocaleOf(ul UserLookup, userID string) string {
+	if locale := s.Messages().Locale; locale != "" {
+		return locale
+	}
+	user, err := ul.Lookup(userID)
+	if err != nil {
+		glog.Errorf("Error looking up locale of %v: %v", userID, err)
+		return DefaultLocale
+	}
+	return s.UserLocale(user)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"github.com/slack-go/slack"
+
+	"testing"
+)
+
+func TestCatalogComplete(t *testing.T) {
+	for locale, msgs := range catalog {
+		for _, key := range MessageKeys() {
+			text, ok := msgs[key]
+			if !ok {
+				t.Fatalf("Message %v missing from locale %v", key, locale)
+			}
+			if _, err := parseTemplate(text); err != nil {
+				t.Fatalf("Invalid template for %v (%v): %v", key, locale, err)
+			}
+		}
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	cases := map[string]string{"es-LA": Spanish, "zh-CN": Mandarin, "zh_TW": Mandarin, "en-US": English, "fr-FR": English, "": English}
+	for slackLocale, expected := range cases {
+		if locale := MatchLocale(slackLocale); locale != expected {
+			t.Fatalf("Expected %v for %q, got %v", expected, slackLocale, locale)
+		}
+	}
+}
+
+func TestQueueMessages(t *testing.T) {
+	ts := TS(&MockUserLookup{}, nil)
+	spanish := &slack.User{ID: "U1", Locale: "es-ES"}
+
+	if msg := ts.Msg(ts.UserLocale(spanish), MsgPosition, Args{"Pos": 1, "Size": 2}); msg != "Estás en la posición 1 de 2 en la cola." {
+		t.Fatalf("Expected message in user's locale, got %q", msg)
+	}
+	if msg := ts.Msg(ts.Locale(), MsgAdded, Args{"User": "U1", "Pos": 2}); msg != "U1 added to queue in position 2" {
+		t.Fatalf("Unexpected admin message: %q", msg)
+	}
+
+	if err := ts.SetLocale(Mandarin); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if locale := ts.UserLocale(spanish); locale != Mandarin {
+		t.Fatalf("Queue locale not used: %v", locale)
+	}
+	if err := ts.SetLocale("fr"); err == nil {
+		t.Fatalf("Expected error for unsupported locale.")
+	}
+
+	if err := ts.SetOverride(MsgUpNext, "{{.User}}, you're up!"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if msg := ts.Msg(Mandarin, MsgUpNext, Args{"User": "Sam"}); msg != "Sam, you're up!" {
+		t.Fatalf("Override not used: %q", msg)
+	}
+	if err := ts.SetOverride("nonexistent", "hi"); err == nil {
+		t.Fatalf("Expected error for unknown message.")
+	}
+	if err := ts.SetOverride(MsgUpNext, "{{.User"); err == nil {
+		t.Fatalf("Expected error for invalid template.")
+	}
+	ts.SetOverride(MsgUpNext, "")
+	if msg := ts.Msg(English, MsgUpNext, Args{"User": "Sam"}); msg != "Ok! Up next is Sam." {
+		t.Fatalf("Override not removed: %q", msg)
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/metrics"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"net/http"
+	"time"
+)
+
+// Metrics of queue operations and Slack API requests. A nil *Metrics records
+// nothing.
+type Metrics struct {
+	channels         *metrics.ChannelLabeler
+	queueOperations  *prometheus.CounterVec
+	versionConflicts *prometheus.CounterVec
+	slackRequests    *prometheus.CounterVec
+	slackErrors      *prometheus.CounterVec
+	slackLatency     *prometheus.HistogramVec
+}
+
+// Registers the metrics with reg, labeling channels with channels.
+func NewMetrics(reg prometheus.Registerer, channels *metrics.ChannelLabeler) *Metrics {
+	m := &Metrics{
+		channels: channels,
+		queueOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slackqueue_queue_operations_total",
+			Help: "Queue operations, by channel, operation and result (ok or failed).",
+		}, []string{"channel", "op", "result"}),
+		versionConflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slackqueue_queue_version_conflicts_total",
+			Help: "Operations rejected because the queue changed since it was listed, by channel and operation.",
+		}, []string{"channel", "op"}),
+		slackRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slackqueue_slack_requests_total",
+			Help: "Slack API requests, including retries, by method and HTTP result (ok, ratelimited, server_error or error).",
+		}, []string{"method", "result"}),
+		slackErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slackqueue_slack_api_errors_total",
+			Help: `Slack API responses with "ok": false, by method and Slack error (e.g., channel_not_found).`,
+		}, []string{"method", "error"}),
+		slackLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "slackqueue_slack_request_duration_seconds",
+			Help:    "Latency of Slack API requests, by method.",
+			Buckets: metrics.DefaultBuckets,
+		}, []string{"method"}),
+	}
+	reg.MustRegister(m.queueOperations, m.versionConflicts, m.slackRequests, m.slackErrors, m.slackLatency)
+	return m
+}
+
+// Label of a channel.
+func (m *Metrics) Channel(id string) string {
+	return m.channels.Label(id)
+}
+
+// Counts an operation on the queue of a channel, failed unless ok.
+func (m *Metrics) queueOperation(channel string, op string, ok bool, err error) {
+	if m == nil {
+		return
+	}
+	channel = m.Channel(channel)
+	result := "failed"
+	if ok {
+		result = "ok"
+	}
+	m.queueOperations.WithLabelValues(channel, op, result).Inc()
+	if _, conflict := err.(queue.VersionError); conflict {
+		m.versionConflicts.WithLabelValues(channel, op).Inc()
+	}
+}
+
+// Records an attempt at a Slack API request started at start.
+func (m *Metrics) slackRequest(method string, start time.Time, resp *http.Response, err error) {
+	if m == nil {
+		return
+	}
+	m.slackLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	m.slackRequests.WithLabelValues(method, result(resp, err)).Inc()
+}
+
+// Counts a Slack API error, e.g., channel_not_found.
+func (m *Metrics) slackError(method string, slackErr string) {
+	if m == nil {
+		return
+	}
+	m.slackErrors.WithLabelValues(method, slackErr).Inc()
+}
+
+// Counts an operation on the queue, failed unless ok.
+func (s *QueueService) count(op string, ok bool, err error) {
+	s.mu.Lock()
+	m, channel := s.metrics, s.channel
+	s.mu.Unlock()
+	m.queueOperation(channel, op, ok, err)
+}
+
+// Records the queue's operations in m.
+func (s *QueueService) EnableMetrics(m *Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = m
+}
@@ -1,25 +1,17 @@
 package service
 
 import (
-	"github.com/golang/glog"
+	"github.com/ml8/slack-queue/pkg/logging"
+
 	"github.com/slack-go/slack"
 
+	"context"
+	"fmt"
 	"net/http"
 )
 
-func (a *MoveAction) Handle(action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
-	user := &action.User
-	ok, err := a.perms.IsAdmin(user)
-	if err != nil {
-		glog.Errorf("Error checking admin status of %v (%v): %v", user.ID, user.Name, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !ok {
-		glog.Errorf("Permission denied to user %v (%v)", user.ID, user.Name)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+func (a *MoveAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	var err error
 
 	var pos int
 	var token int64
@@ -32,8 +24,7 @@ func (a *MoveAction) Handle(action *slack.InteractionCallback, s *QueueService,
 			pos, token, err = ParseActionValue(act.Value)
 			found = true
 			if err != nil {
-				glog.Error("Error parsing action value %v: %v", act.Value, err)
-				w.WriteHeader(http.StatusInternalServerError)
+				ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), fmt.Errorf("parsing action value %v: %w", act.Value, err))
 				return
 			}
 			break
@@ -41,10 +32,10 @@ func (a *MoveAction) Handle(action *slack.InteractionCallback, s *QueueService,
 	}
 
 	if !found {
-		glog.Errorf("Take action not found for remove callback!")
+		logging.FromContext(ctx).Errorf("Move action not found in callback")
 	}
 
-	glog.Infof("Moving position %d %s with token %d", pos, actName, token)
+	logging.FromContext(ctx).With(logging.Seq, token).Infof("Moving position %d %s", pos, actName)
 
 	npos := pos - 1
 	if actName == downActionName {
@@ -54,18 +45,17 @@ func (a *MoveAction) Handle(action *slack.InteractionCallback, s *QueueService,
 	req := &MoveRequest{Pos: pos, NPos: npos, Token: token}
 	resp := &MoveResponse{}
 
-	err = s.Move(req, resp)
-	if err != nil {
-		glog.Errorf("Error moving %d from a request by %v (%v): %v", pos, action.User.ID, action.User.Name, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	err = s.Move(ctx, req, resp)
+	switch {
+	case err != nil:
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), fmt.Errorf("moving %d: %w", pos, err))
+	case !resp.Ok:
+		// The list is refreshed below, but let the admin know why nothing moved.
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), Errorf(ErrStaleVersion, "moving %d with token %d", pos, token))
+	default:
+		w.WriteHeader(http.StatusOK)
 	}
 
-	// Don't care about ok status, since the UI will be refreshed with the current
-	// state anyway.
-
-	w.WriteHeader(http.StatusOK)
-
 	// Replace list with updated state.
-	updateListInUI(action, s, a.api)
+	updateListInUI(action, s, a.api, s.LocaleOf(a.ul, action.User.ID))
 }
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Users who opt in are notified once they reach this position.
+const DefaultNotifyPosition = 3
+
+// Sends a direct message to a user.
+type NotifySender func(userID string, text string) error
+
+// Renders a message to a user.
+type NotifyRenderer func(userID string, key string, args Args) string
+
+// Notifies opted-in users by DM as they move up the queue: when they reach
+// a position, when they're next, and when an admin moves them or helps
+// someone behind them first.
+//
+// Observes the queue after each mutation and compares positions against the
+// previous snapshot. Each notification is sent to a user at most once per
+// position.
+//
+// Thread safe.
+type Notifier struct {
+	mu     sync.Mutex
+	send   NotifySender
+	render NotifyRenderer
+	at     int
+	users  map[string]map[string]bool // opted-in users -> sent notifications
+	prev   []string                   // ids in the previously observed queue
+	seq    int64
+}
+
+func MakeNotifier(at int, send NotifySender, render NotifyRenderer) *Notifier {
+	return &Notifier{send: send, render: render, at: at, users: make(map[string]map[string]bool), seq: -1}
+}
+
+// Opts a user in or out of notifications. Returns whether the user is now
+// opted in.
+func (n *Notifier) Toggle(userID string) (on bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, on = n.users[userID]; on {
+		delete(n.users, userID)
+		return false
+	}
+	n.users[userID] = make(map[string]bool)
+	return true
+}
+
+type notification struct {
+	user string
+	key  string
+	args Args
+}
+
+// A queue.Subscriber.
+func (n *Notifier) Observe(els []queue.Element, seq int64) {
+	n.mu.Lock()
+	if seq <= n.seq {
+		// Snapshot of a mutation already observed.
+		n.mu.Unlock()
+		return
+	}
+	ids := make([]string, len(els))
+	pos := make(map[string]int, len(els))
+	for i, el := range els {
+		ids[i] = el.Id
+		pos[el.Id] = i
+	}
+	prevPos := make(map[string]int, len(n.prev))
+	for i, id := range n.prev {
+		prevPos[id] = i
+	}
+
+	var out []notification
+	notify := func(user string, sent string, key string, args Args) {
+		if n.users[user][sent] {
+			return
+		}
+		n.users[user][sent] = true
+		out = append(out, notification{user, key, args})
+	}
+	for user := range n.users {
+		cur, ok := pos[user]
+		if !ok {
+			// Dequeued, removed, or left; notifications end with the visit.
+			delete(n.users, user)
+			continue
+		}
+		if p, ok := prevPos[user]; ok {
+			ahead, behind := 0, 0
+			for i, id := range n.prev {
+				if _, ok := pos[id]; ok {
+					continue
+				}
+				if i < p {
+					ahead++
+				} else if i > p {
+					behind++
+				}
+			}
+			switch {
+			case cur != p-ahead:
+				if cur > p {
+					// Position notifications may be sent again on the way back up.
+					n.users[user] = make(map[string]bool)
+				}
+				notify(user, fmt.Sprintf("moved_%d", cur), MsgNotifyMoved, Args{"From": p + 1, "To": cur + 1})
+			case behind > 0:
+				notify(user, fmt.Sprintf("skipped_%d", cur), MsgNotifySkipped, Args{"Pos": cur + 1})
+			}
+		}
+		if cur == 0 {
+			notify(user, "next", MsgNotifyNext, nil)
+		} else if cur < n.at {
+			notify(user, fmt.Sprintf("position_%d", cur), MsgNotifyPosition, Args{"Pos": cur + 1})
+		}
+	}
+	n.prev = ids
+	n.seq = seq
+	n.mu.Unlock()
+
+	for _, msg := range out {
+		if err := n.send(msg.user, n.render(msg.user, msg.key, msg.args)); err != nil {
+			glog.Errorf("Error notifying %v: %v", msg.user, err)
+		}
+	}
+}
+
+func (a *NotifyAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	req := &NotifyRequest{Id: action.User.ID}
+	resp := &NotifyResponse{}
+	err := s.ToggleNotifications(req, resp)
+	if err != nil {
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, action.User.ID), fmt.Errorf("toggling notifications: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	locale := s.LocaleOf(a.ul, action.User.ID)
+	var str string
+	switch {
+	case !resp.Queued:
+		str = s.Msg(locale, MsgNotQueued, nil)
+	case resp.Enabled:
+		str = s.Msg(locale, MsgNotifyOn, Args{"At": resp.At})
+	default:
+		str = s.Msg(locale, MsgNotifyOff, nil)
+	}
+	_, err = a.api.PostEphemeral(action.Channel.ID, action.User.ID, slack.MsgOptionText(str, false))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Error posting notification status: %v", err)
+	}
+}
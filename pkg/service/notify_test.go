@@ -0,0 +1,123 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/slack-go/slack"
+
+	"context"
+	"testing"
+)
+
+type recordingSender struct {
+	sent map[string][]string
+}
+
+func (r *recordingSender) send(user string, text string) error {
+	r.sent[user] = append(r.sent[user], text)
+	return nil
+}
+
+func renderEnglish(user string, key string, args Args) string {
+	return Message(English, key, args)
+}
+
+func elements(ids ...string) (els []queue.Element) {
+	for _, id := range ids {
+		els = append(els, queue.Element{Id: id})
+	}
+	return
+}
+
+func TestNotifyPositions(t *testing.T) {
+	r := &recordingSender{make(map[string][]string)}
+	n := MakeNotifier(2, r.send, renderEnglish)
+	n.Toggle("U3")
+
+	n.Observe(elements("U1", "U2", "U3"), 0)
+	if len(r.sent["U3"]) != 0 {
+		t.Fatalf("Unexpected notification: %v", r.sent["U3"])
+	}
+	n.Observe(elements("U2", "U3"), 1)
+	n.Observe(elements("U2", "U3", "U4"), 2)
+	n.Observe(elements("U3", "U4"), 3)
+	expected := []string{"You're 2 in the queue.", "You're next in the queue!"}
+	if len(r.sent["U3"]) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, r.sent["U3"])
+	}
+	for i, msg := range expected {
+		if r.sent["U3"][i] != msg {
+			t.Fatalf("Expected %v, got %v", msg, r.sent["U3"][i])
+		}
+	}
+
+	// Stale snapshots are ignored.
+	n.Observe(elements("U4", "U3"), 2)
+	if len(r.sent["U3"]) != 2 {
+		t.Fatalf("Notified for stale snapshot: %v", r.sent["U3"])
+	}
+
+	// Notifications end once the user leaves the queue.
+	n.Observe(elements("U4"), 4)
+	n.Observe(elements("U4", "U3"), 5)
+	if len(r.sent["U3"]) != 2 {
+		t.Fatalf("Notified after leaving: %v", r.sent["U3"])
+	}
+}
+
+func TestNotifyMovedAndSkipped(t *testing.T) {
+	r := &recordingSender{make(map[string][]string)}
+	n := MakeNotifier(1, r.send, renderEnglish)
+	n.Toggle("U1")
+
+	n.Observe(elements("U1", "U2", "U3"), 0)
+	n.Observe(elements("U1", "U3"), 1)
+	n.Observe(elements("U3", "U1"), 2)
+	n.Observe(elements("U1", "U3"), 3)
+	expected := []string{
+		"You're next in the queue!",
+		"Someone behind you in the queue was helped or left before you. You're still 1 in the queue.",
+		"An admin moved you from position 1 to 2 in the queue.",
+		"An admin moved you from position 2 to 1 in the queue.",
+		"You're next in the queue!",
+	}
+	if len(r.sent["U1"]) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, r.sent["U1"])
+	}
+	for i, msg := range expected {
+		if r.sent["U1"][i] != msg {
+			t.Fatalf("Expected %v, got %v", msg, r.sent["U1"][i])
+		}
+	}
+}
+
+func TestToggleNotifications(t *testing.T) {
+	ts := TS(&MockUserLookup{}, nil)
+	r := &recordingSender{make(map[string][]string)}
+	ts.EnableNotifications(&MockUserLookup{}, r.send)
+	ts.SetLocale(Spanish)
+
+	resp := &NotifyResponse{}
+	ts.ToggleNotifications(&NotifyRequest{Id: "U1"}, resp)
+	if resp.Queued || resp.Enabled {
+		t.Fatalf("Enabled notifications for user not in queue: %+v", resp)
+	}
+
+	ts.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: "U1"}}, &EnqueueResponse{})
+	resp = &NotifyResponse{}
+	ts.ToggleNotifications(&NotifyRequest{Id: "U1"}, resp)
+	if !resp.Queued || !resp.Enabled {
+		t.Fatalf("Expected notifications enabled: %+v", resp)
+	}
+
+	ts.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: "U2"}}, &EnqueueResponse{})
+	if len(r.sent["U1"]) != 1 || r.sent["U1"][0] != "¡Eres el siguiente en la cola!" {
+		t.Fatalf("Unexpected notifications: %v", r.sent["U1"])
+	}
+
+	resp = &NotifyResponse{}
+	ts.ToggleNotifications(&NotifyRequest{Id: "U1"}, resp)
+	if !resp.Queued || resp.Enabled {
+		t.Fatalf("Expected notifications disabled: %+v", resp)
+	}
+}
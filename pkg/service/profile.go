@@ -0,0 +1,162 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const meetingActionName = "meeting"
+
+// Settings of an admin.
+type Profile struct {
+	TeamID string `json:"TeamID,omitempty"` // empty for profiles set before multi-workspace support
+	UserID string `json:"UserID"`
+	// Personal meeting URL. May contain the placeholders {student}, {admin}
+	// and {topic}, which are replaced (escaped) when the link is sent.
+	MeetingLink string `json:"MeetingLink,omitempty"`
+}
+
+type ProfileStoreState struct {
+	Profiles []Profile `json:"Profiles"`
+}
+
+// User IDs are unique only within a workspace.
+type profileKey struct {
+	team string
+	user string
+}
+
+// Admin profiles, shared by all queues.
+//
+// Thread safe.
+type ProfileStore struct {
+	mu       sync.Mutex
+	profiles map[profileKey]Profile
+	persist  persister.Persister
+}
+
+func MakeProfileStore(persist persister.Persister) *ProfileStore {
+	return &ProfileStore{profiles: make(map[profileKey]Profile), persist: persist}
+}
+
+// Returns the profile of a user of a team, or their profile set before
+// multi-workspace support.
+func (ps *ProfileStore) Profile(team string, userID string) (p Profile, ok bool) {
+	if ps == nil {
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p, ok = ps.profiles[profileKey{team, userID}]; ok {
+		return
+	}
+	p, ok = ps.profiles[profileKey{"", userID}]
+	return
+}
+
+// Sets (or, given an empty link, clears) an admin's meeting link. A profile
+// set before multi-workspace support becomes the team's.
+func (ps *ProfileStore) SetMeetingLink(team string, userID string, link string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	key := profileKey{team, userID}
+	p, ok := ps.profiles[key]
+	if !ok {
+		p = ps.profiles[profileKey{"", userID}]
+		delete(ps.profiles, profileKey{"", userID})
+	}
+	p.TeamID = team
+	p.UserID = userID
+	p.MeetingLink = link
+	ps.profiles[key] = p
+	ps.persistLocked()
+}
+
+// Returns the meeting link of admin, of team, for a session with student, if
+// the admin has one.
+func (ps *ProfileStore) MeetingLink(team string, admin *slack.User, student *slack.User, topic string) string {
+	p, ok := ps.Profile(team, admin.ID)
+	if !ok || p.MeetingLink == "" {
+		return ""
+	}
+	r := strings.NewReplacer(
+		"{student}", url.QueryEscape(displayName(student)),
+		"{admin}", url.QueryEscape(displayName(admin)),
+		"{topic}", url.QueryEscape(topic))
+	return r.Replace(p.MeetingLink)
+}
+
+// Parses a link as entered in a slash command, where Slack may have wrapped
+// it, e.g., "<https://example.com/j/1|example.com/j/1>".
+func ParseMeetingLink(text string) (link string, ok bool) {
+	link = strings.TrimSpace(text)
+	if link == "" {
+		return "", true
+	}
+	link = strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(link, "<"), ">"), "|", 2)[0]
+	u, err := url.Parse(strings.NewReplacer("{", "", "}", "").Replace(link))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", false
+	}
+	return link, true
+}
+
+// Whether an interaction is a click on a meeting link button, which needs
+// only to be acknowledged.
+func IsMeetingAction(action *slack.InteractionCallback) bool {
+	for _, a := range action.ActionCallback.BlockActions {
+		if a.ActionID == meetingActionName {
+			return true
+		}
+	}
+	return false
+}
+
+// Button opening a meeting link.
+func meetingButton(label string, link string) slack.Block {
+	button := slack.NewButtonBlockElement(meetingActionName, "", slack.NewTextBlockObject("plain_text", label, false, false))
+	button.URL = link
+	button.Style = slack.StylePrimary
+	return slack.NewActionBlock("meeting_actions", button)
+}
+
+func displayName(user *slack.User) string {
+	if user.RealName != "" {
+		return user.RealName
+	}
+	return user.Name
+}
+
+// Must hold lock.
+func (ps *ProfileStore) persistLocked() {
+	if ps.persist == nil {
+		return
+	}
+	state := ProfileStoreState{}
+	for _, p := range ps.profiles {
+		state.Profiles = append(state.Profiles, p)
+	}
+	if err := ps.persist.Write(state); err != nil {
+		glog.Errorf("Error persisting profiles: %v", err)
+	}
+}
+
+func (ps *ProfileStore) Recover() {
+	if ps.persist == nil {
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	state := ProfileStoreState{}
+	ps.persist.Read(&state)
+	for _, p := range state.Profiles {
+		ps.profiles[profileKey{p.TeamID, p.UserID}] = p
+	}
+	glog.Infof("Recovered %d profiles.", len(state.Profiles))
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/persister"
+
+	"github.com/slack-go/slack"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeetingLink(t *testing.T) {
+	ps := MakeProfileStore(nil)
+	admin := &slack.User{ID: "A1", RealName: "Ada"}
+	student := &slack.User{ID: "U1", RealName: "Sam Student"}
+	if link := ps.MeetingLink("T1", admin, student, "hw"); link != "" {
+		t.Fatalf("Unexpected link for admin without profile: %v", link)
+	}
+
+	ps.SetMeetingLink("T1", "A1", "https://meet.example.com/ada?name={student}&topic={topic}")
+	link := ps.MeetingLink("T1", admin, student, "hw 3")
+	if link != "https://meet.example.com/ada?name=Sam+Student&topic=hw+3" {
+		t.Fatalf("Incorrect link: %v", link)
+	}
+	if link := ps.MeetingLink("T2", admin, student, "hw"); link != "" {
+		t.Fatalf("Link of another team's admin with the same ID: %v", link)
+	}
+}
+
+func TestLegacyProfile(t *testing.T) {
+	ps := MakeProfileStore(nil)
+	ps.profiles[profileKey{"", "A1"}] = Profile{UserID: "A1", MeetingLink: "https://zoom.us/j/1"}
+	if p, ok := ps.Profile("T1", "A1"); !ok || p.MeetingLink != "https://zoom.us/j/1" {
+		t.Fatalf("Profile without a team not found: %+v", p)
+	}
+
+	ps.SetMeetingLink("T1", "A1", "https://zoom.us/j/2")
+	if _, ok := ps.profiles[profileKey{"", "A1"}]; ok {
+		t.Fatal("Profile without a team not moved to the team.")
+	}
+	if p, _ := ps.Profile("T1", "A1"); p.TeamID != "T1" || p.MeetingLink != "https://zoom.us/j/2" {
+		t.Fatalf("Incorrect profile: %+v", p)
+	}
+}
+
+func TestParseMeetingLink(t *testing.T) {
+	cases := map[string]string{
+		"<https://zoom.us/j/1|zoom.us/j/1>": "https://zoom.us/j/1",
+		"https://meet.example.com/{admin}":  "https://meet.example.com/{admin}",
+		" ":                                 "",
+	}
+	for text, expected := range cases {
+		if link, ok := ParseMeetingLink(text); !ok || link != expected {
+			t.Fatalf("Expected %q for %q, got %q (%v)", expected, text, link, ok)
+		}
+	}
+	for _, text := range []string{"zoom", "ftp://example.com", "https://"} {
+		if _, ok := ParseMeetingLink(text); ok {
+			t.Fatalf("Accepted invalid link %q", text)
+		}
+	}
+}
+
+func TestProfileRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := persister.FilePersister{Fn: filepath.Join(dir, "profiles")}
+
+	MakeProfileStore(p).SetMeetingLink("T1", "A1", "https://zoom.us/j/1")
+
+	ps := MakeProfileStore(p)
+	ps.Recover()
+	if profile, ok := ps.Profile("T1", "A1"); !ok || profile.MeetingLink != "https://zoom.us/j/1" {
+		t.Fatalf("Profile not recovered: %+v", profile)
+	}
+}
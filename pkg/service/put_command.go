@@ -1,37 +1,46 @@
 package service
 
 import (
+	"github.com/ml8/slack-queue/pkg/logging"
+
 	"github.com/golang/glog"
 	"github.com/slack-go/slack"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
-func enqueueAsBlock(cmd *slack.SlashCommand, resp *EnqueueResponse) (b []byte) {
+func enqueueAsBlock(cmd *slack.SlashCommand, s *QueueService, locale string, resp *EnqueueResponse) (b []byte) {
+	msg := slack.NewBlockMessage(enqueueBlocks(s, locale, resp)...)
+	b, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		glog.Fatalf("Error marshalling json: %v", err)
+	}
+	return
+}
+
+func enqueueBlocks(s *QueueService, locale string, resp *EnqueueResponse) []slack.Block {
 	var statusstr string
 	if resp.Ok {
-		statusstr = fmt.Sprintf("*Status:*\nOk! You're %d in the queue.", resp.Pos+1)
+		statusstr = s.Msg(locale, MsgEnqueued, Args{"Pos": resp.Pos + 1})
 	} else {
-		statusstr = fmt.Sprintf("*Status:*\nYou are already queued at position %d.", resp.Pos+1)
+		statusstr = s.Msg(locale, MsgAlreadyQueued, Args{"Pos": resp.Pos + 1})
 	}
-	timestr := fmt.Sprintf("*Enqueued At:*\n%v", resp.Timestamp.Local())
+	timestr := s.Msg(locale, MsgEnqueuedAt, Args{"Time": resp.Timestamp.Local()})
 
 	fields := make([]*slack.TextBlockObject, 2)
 	fields[0] = slack.NewTextBlockObject("mrkdwn", statusstr, false, false)
 	fields[1] = slack.NewTextBlockObject("mrkdwn", timestr, false, false)
-	section := slack.NewSectionBlock(nil, fields, nil)
-
-	msg := slack.NewBlockMessage(section)
-	b, err := json.MarshalIndent(msg, "", "  ")
-	if err != nil {
-		glog.Fatalf("Error marshalling json: %v", err)
-	}
-	return
+	notify := slack.NewButtonBlockElement(notifyActionName, "", slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgNotifyButton, nil), false, false))
+	return []slack.Block{
+		slack.NewSectionBlock(nil, fields, nil),
+		slack.NewActionBlock("notify_actions", notify)}
 }
 
-func (c *PutCommand) Handle(cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
+func (c *PutCommand) Handle(ctx context.Context, cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
 	// TODO Send message to auth channel
 	req := &EnqueueRequest{}
 	resp := &EnqueueResponse{}
@@ -41,19 +50,32 @@ func (c *PutCommand) Handle(cmd *slack.SlashCommand, s *QueueService, w http.Res
 	req.User.Name = cmd.UserName
 	req.User.TeamID = cmd.TeamID
 
-	glog.Infof("%+v", cmd)
+	log := logging.FromContext(ctx)
+	log.Infof("Enqueueing, topic %v", logging.Body(cmd.Text))
 	req.Metadata = cmd.Text
 
-	err = s.Enqueue(req, resp)
+	if form := s.Form(); form != nil && strings.TrimSpace(cmd.Text) == "" {
+		// Ask the form's questions; the student is enqueued on submission.
+		locale := s.LocaleOf(c.ul, cmd.UserID)
+		_, err = c.api.OpenView(cmd.TriggerID, intakeModal(s, locale, cmd.ChannelID, form))
+		if err != nil {
+			ReplyCommandError(ctx, w, cmd, locale, NewError(ErrUpstream, fmt.Errorf("opening intake form: %w", err)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = s.Enqueue(ctx, req, resp)
 	if err != nil {
-		glog.Errorf("Error enqueueing %v (%v): %v", cmd.UserID, cmd.UserName, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		ReplyCommandError(ctx, w, cmd, s.LocaleOf(c.ul, cmd.UserID), fmt.Errorf("enqueueing: %w", err))
 		return
 	}
 
-	b := enqueueAsBlock(cmd, resp)
+	b := enqueueAsBlock(cmd, s, s.LocaleOf(c.ul, cmd.UserID), resp)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
+	log.Infof("Enqueued at position %d, ok %v", resp.Pos, resp.Ok)
 
 	if !resp.Ok {
 		// Don't post admin if already in queue.
@@ -65,10 +87,10 @@ func (c *PutCommand) Handle(cmd *slack.SlashCommand, s *QueueService, w http.Res
 		resp.User = fu
 	}
 
-	str := fmt.Sprintf("%s added to queue in position %d for %s", userToLink(resp.User), resp.Pos+1, cmd.Text)
+	str := s.Msg(s.Locale(), MsgAdded, Args{"User": userToLink(resp.User), "Pos": resp.Pos + 1, "Topic": cmd.Text})
 	cerr := c.perms.SendAdminMessage(str)
 	if cerr != nil {
-		glog.Errorf("Error sending admin message for enqueue of %v: %v", cmd.UserName, cerr)
+		log.Errorf("Error sending admin message for enqueue: %v", cerr)
 	}
 
 	return
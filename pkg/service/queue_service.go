@@ -1,12 +1,16 @@
 package service
 
 import (
+	"github.com/ml8/slack-queue/pkg/logging"
 	"github.com/ml8/slack-queue/pkg/persister"
 	"github.com/ml8/slack-queue/pkg/queue"
 
 	"github.com/golang/glog"
 	"github.com/slack-go/slack"
 
+	"context"
+	"errors"
+	"sync"
 	"time"
 )
 
@@ -14,28 +18,64 @@ type UserLookup interface {
 	Lookup(id string) (user *slack.User, err error)
 }
 
-type UserLookupImpl struct {
-	api *slack.Client
-}
+type QueueService struct {
+	q      *queue.VersionedQueue
+	u      UserLookup
+	est    waitEstimator
+	mu     sync.Mutex
+	form   *IntakeForm
+	notify *Notifier
 
-func (ul *UserLookupImpl) Lookup(id string) (user *slack.User, err error) {
-	user, err = ul.api.GetUserInfo(id)
-	return
+	messages   MessageSettings
+	escalation *Escalation
+	escalated  escalationState
+	policy     *Policy
+
+	history       HistoryStore
+	metrics       *Metrics
+	team, channel string // served by the queue
 }
 
-type QueueService struct {
-	q *queue.VersionedQueue
-	u UserLookup
+const (
+	// Number of recent dequeues used to estimate waits.
+	estimatorWindow = 10
+	// Dequeues older than this aren't used to estimate waits.
+	estimatorMaxAge = 2 * time.Hour
+)
+
+// Estimates waits from the rate at which users were recently dequeued.
+type waitEstimator struct {
+	mu    sync.Mutex
+	takes []time.Time // most recent dequeues, oldest first
 }
 
-func InMemoryTS(api *slack.Client) *QueueService {
-	u := &UserLookupImpl{api}
-	return TS(u, nil)
+func (e *waitEstimator) record(t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.takes = append(e.takes, t)
+	if len(e.takes) > estimatorWindow {
+		e.takes = e.takes[len(e.takes)-estimatorWindow:]
+	}
 }
 
-func PersistentTS(api *slack.Client, persist persister.Persister) *QueueService {
-	u := &UserLookupImpl{api}
-	return TS(u, persist)
+// Estimated wait for a user at pos, or false if there have been too few
+// recent dequeues to tell.
+func (e *waitEstimator) estimate(pos int, now time.Time) (wait time.Duration, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var recent []time.Time
+	for _, t := range e.takes {
+		if now.Sub(t) <= estimatorMaxAge {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) < 2 {
+		return
+	}
+	interval := recent[len(recent)-1].Sub(recent[0]) / time.Duration(len(recent)-1)
+	wait = interval * time.Duration(pos+1)
+	ok = true
+	return
 }
 
 func TS(u UserLookup, persist persister.Persister) *QueueService {
@@ -45,31 +85,34 @@ func TS(u UserLookup, persist persister.Persister) *QueueService {
 	return s
 }
 
-func (s *QueueService) Enqueue(req *EnqueueRequest, resp *EnqueueResponse) (err error) {
+func (s *QueueService) Enqueue(ctx context.Context, req *EnqueueRequest, resp *EnqueueResponse) (err error) {
 	user := req.User
 	resp.User = user
 	now := time.Now()
-	pos, seq, e := s.q.Put(queue.Element{Id: user.ID, Metadata: req.Metadata, QTime: now})
+	pos, seq, e := s.q.Put(queue.Element{Id: user.ID, Metadata: req.Metadata, Fields: req.Fields, QTime: now})
+	s.count("enqueue", e == nil, e)
 	resp.Pos = pos
+	log := logging.FromContext(ctx).With(logging.User, user.ID, logging.Seq, seq)
 	if e != nil {
 		ae, ok := e.(queue.AlreadyExistsError)
 		if !ok {
 			// Unknown error
-			glog.Errorf("Unknown error on Put: %v", err)
+			log.Errorf("Unknown error on enqueue: %v", e)
 			err = e
 		} else {
-			glog.Infof("User (%v) %v already in queue at time %v (v %v)", user.ID, user.Name, ae.Timestamp, seq)
+			log.Infof("Already in queue since %v", ae.Timestamp)
 			resp.Ok = false
 			resp.Timestamp = ae.Timestamp
 			return
 		}
 	}
+	log.Infof("Enqueued at position %d", pos)
 	resp.Ok = true
 	resp.Timestamp = now
 	return
 }
 
-func (s *QueueService) Dequeue(req *DequeueRequest, resp *DequeueResponse) (err error) {
+func (s *QueueService) Dequeue(ctx context.Context, req *DequeueRequest, resp *DequeueResponse) (err error) {
 	var el queue.Element
 	var seq int64
 	var e error
@@ -78,73 +121,240 @@ func (s *QueueService) Dequeue(req *DequeueRequest, resp *DequeueResponse) (err
 	} else {
 		el, seq, e = s.q.Take(req.Place, req.Token)
 	}
+	s.count("dequeue", e == nil, e)
 	if e != nil {
 		resp.Token = seq
 		resp.User = nil
+		resp.Err = e
 		err = nil
-		glog.Infof("Error taking %d from queue: %v", req.Place, e)
+		logging.FromContext(ctx).With(logging.Seq, req.Token).Infof("Error taking %d from queue: %v", req.Place, e)
 		return
 	}
-	glog.Infof("Dequeueing %v (v %v)", el.Id, seq)
-	user, err := s.u.Lookup(el.Id)
-	if err != nil {
-		glog.Errorf("Dequeued user %v but could not get user info, requeueing (v %v): %v", el.Id, seq, err)
-		s.q.Put(el)
-		return
+	log := logging.FromContext(ctx).With(logging.User, el.Id, logging.Seq, seq)
+	log.Infof("Dequeued position %d", req.Place)
+	user, e := s.u.Lookup(el.Id)
+	if e != nil {
+		log.Errorf("Error looking up dequeued user: %v", e)
+		user = fallbackUser(el.Id)
 	}
 	resp.User = user
 	resp.Metadata = el.Metadata
+	resp.Fields = el.Fields
 	resp.Timestamp = el.QTime
+	now := time.Now()
+	s.est.record(now)
+	s.record(el, req.Admin, OutcomeServed, now)
 	return
 }
 
 func (s *QueueService) List(req *ListRequest, resp *ListResponse) (err error) {
 	lst, seq := s.q.List()
 	resp.Token = seq
+	if p, ok := s.u.(UserPrefetcher); ok {
+		ids := make([]string, len(lst))
+		for i, el := range lst {
+			ids[i] = el.Id
+		}
+		p.Prefetch(ids)
+	}
 	for _, el := range lst {
 		user, err := s.u.Lookup(el.Id)
 		if err != nil {
+			// Positions must match the queue's, so show the user regardless.
 			glog.Errorf("Failed to lookup user %v in queue (v %v): %v", el.Id, seq, err)
-			// Return error, but also return users that were looked up.
-		} else {
-			resp.Users = append(resp.Users, user)
-			resp.Times = append(resp.Times, el.QTime)
-			resp.Metadata = append(resp.Metadata, el.Metadata)
+			user = fallbackUser(el.Id)
 		}
+		resp.Users = append(resp.Users, user)
+		resp.Times = append(resp.Times, el.QTime)
+		resp.Metadata = append(resp.Metadata, el.Metadata)
+		resp.Fields = append(resp.Fields, el.Fields)
 	}
 	return
 }
 
-func (s *QueueService) Remove(req *RemoveRequest, resp *RemoveResponse) (err error) {
-	seq, e := s.q.Remove(req.Pos, req.Token)
+func (s *QueueService) Remove(ctx context.Context, req *RemoveRequest, resp *RemoveResponse) (err error) {
+	el, seq, e := s.q.Take(req.Pos, req.Token)
+	s.count("remove", e == nil, e)
 	resp.Token = seq
+	log := logging.FromContext(ctx).With(logging.Seq, req.Token)
 	if e != nil {
-		if ae, ok := e.(queue.VersionError); !ok {
-			glog.Errorf("Unknown error on remove at %d with token %d: %+v:", req.Pos, req.Token, ae)
-			err = ae
+		if _, ok := e.(queue.VersionError); !ok {
+			log.Errorf("Unknown error removing position %d: %v", req.Pos, e)
+			err = e
 			return
 		}
+		log.Infof("Stale remove of position %d, current sequence %d", req.Pos, seq)
+	} else {
+		log.With(logging.User, el.Id).Infof("Removed position %d", req.Pos)
+		s.record(el, req.Admin, OutcomeRemoved, time.Now())
 	}
-	glog.Infof("Remove at %d with token %d, error: %+v", req.Pos, req.Token, err)
 	resp.Err = e
 	return
 }
 
-func (s *QueueService) Move(req *MoveRequest, resp *MoveResponse) (err error) {
+func (s *QueueService) RemoveUser(ctx context.Context, req *RemoveUserRequest, resp *RemoveUserResponse) (err error) {
+	el, pos, seq, e := s.q.TakeId(req.Id)
+	s.count("leave", e == nil, e)
+	resp.Token = seq
+	resp.Pos = pos
+	resp.Ok = e == nil
+	if e == nil {
+		s.record(el, "", OutcomeLeft, time.Now())
+	}
+	log := logging.FromContext(ctx).With(logging.User, req.Id, logging.Seq, seq)
+	if e != nil {
+		log.Infof("Not removed: %v", e)
+	} else {
+		log.Infof("Removed from position %d", pos)
+	}
+	return
+}
+
+// Records everyone still waiting as expired, e.g., when the queue is deleted.
+func (s *QueueService) ExpireWaiting() {
+	lst, _ := s.q.List()
+	now := time.Now()
+	for _, el := range lst {
+		s.record(el, "", OutcomeExpired, now)
+	}
+}
+
+// Sets the team and channel the queue serves, which label its history and
+// metrics.
+func (s *QueueService) Identify(team string, channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.team = team
+	s.channel = channel
+}
+
+// Records the users leaving the queue in store.
+func (s *QueueService) EnableHistory(store HistoryStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = store
+}
+
+func (s *QueueService) record(el queue.Element, admin string, outcome Outcome, now time.Time) {
+	s.mu.Lock()
+	history, team, channel := s.history, s.team, s.channel
+	s.mu.Unlock()
+	if history == nil {
+		return
+	}
+	err := history.Record(HistoryEntry{
+		TeamID:    team,
+		ChannelID: channel,
+		UserID:    el.Id,
+		Topic:     el.Metadata,
+		Fields:    el.Fields,
+		Enqueued:  el.QTime,
+		Dequeued:  now,
+		AdminID:   admin,
+		Outcome:   outcome})
+	if err != nil {
+		glog.Errorf("Error recording %v of %v in history: %v", outcome, el.Id, err)
+	}
+}
+
+func (s *QueueService) Position(req *PositionRequest, resp *PositionResponse) (err error) {
+	lst, seq := s.q.List()
+	resp.Token = seq
+	resp.Size = len(lst)
+	for i, el := range lst {
+		if el.Id == req.Id {
+			resp.Ok = true
+			resp.Pos = i
+			resp.Timestamp = el.QTime
+			return
+		}
+	}
+	return
+}
+
+func (s *QueueService) Summary(req *SummaryRequest, resp *SummaryResponse) (err error) {
+	lst, seq := s.q.List()
+	resp.Token = seq
+	resp.Size = len(lst)
+	if len(lst) > 0 {
+		resp.Oldest = lst[0].QTime
+	}
+	resp.EstimatedWait, resp.Estimated = s.est.estimate(len(lst), time.Now())
+	return
+}
+
+func (s *QueueService) Move(ctx context.Context, req *MoveRequest, resp *MoveResponse) (err error) {
 	seq, e := s.q.Move(req.Pos, req.NPos, req.Token)
+	s.count("move", e == nil, e)
 	resp.Token = seq
+	log := logging.FromContext(ctx).With(logging.Seq, req.Token)
 	if e != nil {
-		if ae, ok := e.(queue.VersionError); !ok {
-			glog.Errorf("Unknown error on move %d -> %d with token %d, error: %+v", req.Pos, req.NPos, req.Token, ae)
-			err = ae
+		if _, ok := e.(queue.VersionError); !ok {
+			log.Errorf("Unknown error moving %d to %d: %v", req.Pos, req.NPos, e)
+			err = e
 			return
 		}
+		log.Infof("Stale move of %d to %d, current sequence %d", req.Pos, req.NPos, seq)
+	} else {
+		log.Infof("Moved %d to %d", req.Pos, req.NPos)
 	}
-	glog.Infof("Move %d -> %d, err: %+v", req.Pos, req.NPos, err)
-	resp.Ok = err == nil
+	resp.Ok = e == nil
 	return
 }
 
+// Returns the queue's intake form, or nil if it has none.
+func (s *QueueService) Form() *IntakeForm {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.form
+}
+
+func (s *QueueService) SetForm(form *IntakeForm) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.form = form
+}
+
+// Enables opt-in position notifications, sent using send in the locale of
+// each user, looked up with ul.
+func (s *QueueService) EnableNotifications(ul UserLookup, send NotifySender) {
+	render := func(userID string, key string, args Args) string {
+		return s.Msg(s.LocaleOf(ul, userID), key, args)
+	}
+	s.mu.Lock()
+	s.notify = MakeNotifier(DefaultNotifyPosition, send, render)
+	s.mu.Unlock()
+	s.q.Subscribe(s.notify.Observe)
+}
+
+// Opts a queued user in or out of position notifications.
+func (s *QueueService) ToggleNotifications(req *NotifyRequest, resp *NotifyResponse) (err error) {
+	s.mu.Lock()
+	notify := s.notify
+	s.mu.Unlock()
+	if notify == nil {
+		return errors.New("Notifications are not enabled")
+	}
+	preq := &PositionRequest{Id: req.Id}
+	presp := &PositionResponse{}
+	if err = s.Position(preq, presp); err != nil || !presp.Ok {
+		return
+	}
+	resp.Queued = true
+	resp.Enabled = notify.Toggle(req.Id)
+	resp.At = DefaultNotifyPosition
+	return
+}
+
+// Registers a subscriber for changes to the queue.
+func (s *QueueService) Subscribe(sub queue.Subscriber) {
+	s.q.Subscribe(sub)
+}
+
+func (s *QueueService) Persist() {
+	s.q.Persist()
+}
+
 func (s *QueueService) Recover() {
 	s.q.Recover()
 	return
@@ -3,7 +3,9 @@ package service
 import (
 	"github.com/slack-go/slack"
 
+	"context"
 	"testing"
+	"time"
 )
 
 type MockUserLookup struct {
@@ -32,7 +34,7 @@ func TestEnqueue(t *testing.T) {
 	req.User = &slack.User{}
 	req.User.ID = "user123"
 
-	err := ts.Enqueue(req, resp)
+	err := ts.Enqueue(context.Background(), req, resp)
 
 	if err != nil {
 		t.Fatalf("No expected failure on first put: %v", err)
@@ -53,7 +55,7 @@ func TestMultiEnqueue(t *testing.T) {
 	req.User = &slack.User{}
 	req.User.ID = "user123"
 
-	err := ts.Enqueue(req, resp)
+	err := ts.Enqueue(context.Background(), req, resp)
 
 	if err != nil {
 		t.Fatalf("No expected failure on put: %v", err)
@@ -65,7 +67,7 @@ func TestMultiEnqueue(t *testing.T) {
 
 	req.User.ID = "user456"
 
-	err = ts.Enqueue(req, resp)
+	err = ts.Enqueue(context.Background(), req, resp)
 
 	if err != nil {
 		t.Fatalf("No expected failure on put: %v", err)
@@ -77,7 +79,7 @@ func TestMultiEnqueue(t *testing.T) {
 
 	req.User.ID = "user123"
 
-	err = ts.Enqueue(req, resp)
+	err = ts.Enqueue(context.Background(), req, resp)
 
 	if err != nil {
 		t.Fatalf("No expected failure on put: %v", err)
@@ -88,4 +90,43 @@ func TestMultiEnqueue(t *testing.T) {
 	}
 }
 
+func TestWaitEstimate(t *testing.T) {
+	e := &waitEstimator{}
+	now := time.Now()
+	if _, ok := e.estimate(0, now); ok {
+		t.Fatal("Estimated wait without any dequeues.")
+	}
+
+	e.record(now.Add(-20 * time.Minute))
+	e.record(now.Add(-10 * time.Minute))
+	e.record(now)
+	wait, ok := e.estimate(2, now)
+	if !ok {
+		t.Fatal("No estimate after several dequeues.")
+	}
+	if wait != 30*time.Minute {
+		t.Fatalf("Expected 30m wait at position 2, got %v", wait)
+	}
+
+	if _, ok := e.estimate(0, now.Add(estimatorMaxAge+time.Minute)); ok {
+		t.Fatal("Estimated wait from old dequeues.")
+	}
+}
+
+func TestSummary(t *testing.T) {
+	ts := TS(&MockUserLookup{}, nil)
+	for _, id := range []string{"user123", "user456"} {
+		ts.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: id}}, &EnqueueResponse{})
+	}
+
+	resp := &SummaryResponse{}
+	ts.Summary(&SummaryRequest{}, resp)
+	if resp.Size != 2 {
+		t.Fatalf("Expected size 2, got %d", resp.Size)
+	}
+	if resp.Estimated {
+		t.Fatal("Estimated wait without any dequeues.")
+	}
+}
+
 // TODO Remove tests
@@ -1,26 +1,18 @@
 package service
 
 import (
-	"github.com/golang/glog"
+	"github.com/ml8/slack-queue/pkg/logging"
+
 	"github.com/slack-go/slack"
 
+	"context"
 	"fmt"
 	"net/http"
 )
 
-func (a *RemoveAction) Handle(action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+func (a *RemoveAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
 	user := &action.User
-	ok, err := a.perms.IsAdmin(user)
-	if err != nil {
-		glog.Errorf("Error checking admin status of %v (%v): %v", user.ID, user.Name, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !ok {
-		glog.Errorf("Permission denied to user %v (%v)", user.ID, user.Name)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	var err error
 
 	var pos int
 	var token int64
@@ -31,30 +23,33 @@ func (a *RemoveAction) Handle(action *slack.InteractionCallback, s *QueueService
 			pos, token, err = ParseActionValue(act.Value)
 			found = true
 			if err != nil {
-				glog.Error("Error parsing action value %v: %v", act.Value, err)
-				w.WriteHeader(http.StatusInternalServerError)
+				ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), fmt.Errorf("parsing action value %v: %w", act.Value, err))
 				return
 			}
 			break
 		}
 	}
 
+	log := logging.FromContext(ctx)
 	if !found {
-		glog.Errorf("Remove action not found for remove callback!")
+		log.Errorf("Remove action not found in callback")
 	}
 
-	glog.Infof("Removing position %d with token %d", pos, token)
+	log.With(logging.Seq, token).Infof("Removing position %d", pos)
 
-	req := &RemoveRequest{Pos: pos, Token: token}
+	req := &RemoveRequest{Pos: pos, Token: token, Admin: user.ID}
 	resp := &RemoveResponse{}
-	err = s.Remove(req, resp)
+	err = s.Remove(ctx, req, resp)
 	if err != nil {
-		glog.Errorf("Unexpected error for remove: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), fmt.Errorf("removing: %w", err))
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
+	if resp.Err != nil {
+		// The list is refreshed below, but let the admin know why nothing happened.
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), resp.Err)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
 
 	fu, err := a.ul.Lookup(user.ID)
 	if err == nil {
@@ -64,15 +59,13 @@ func (a *RemoveAction) Handle(action *slack.InteractionCallback, s *QueueService
 	// Post admin message
 	var str string
 	if resp.Err != nil {
-		glog.Infof("Stale remove for token %d, current token %d", req.Token, resp.Token)
 		// str = "Remove failed: Queue has been modified since listing."
 	} else {
-		glog.Infof("Successfully removed pos %d, new sequence %d", req.Pos, resp.Token)
-		str = fmt.Sprintf("%s removed position %d\n", userToLink(user), req.Pos+1)
+		str = s.Msg(s.Locale(), MsgRemoved, Args{"Admin": userToLink(user), "Pos": req.Pos + 1})
 	}
 	a.perms.SendAdminMessage(str)
 
 	// Replace list with updated state.
-	updateListInUI(action, s, a.api)
+	updateListInUI(action, s, a.api, s.LocaleOf(a.ul, user.ID))
 	return
 }
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Role string
+
+const (
+	// Manages the queue's settings, and may do anything the policy allows
+	// anyone.
+	RoleOwner Role = "owner"
+	// Helps students: lists, takes, removes and reorders.
+	RoleTA Role = "ta"
+	// Lists the queue, e.g., a course coordinator.
+	RoleObserver Role = "observer"
+	// Waits in the queue.
+	RoleStudent Role = "student"
+)
+
+var roles = []Role{RoleOwner, RoleTA, RoleObserver, RoleStudent}
+
+func ParseRole(s string) (r Role, ok bool) {
+	for _, r = range roles {
+		if string(r) == strings.ToLower(s) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// Operations governed by a queue's policy. Commands and actions are checked
+// against the operation they perform, e.g., the take command and the take
+// button are both OpTake.
+const (
+	OpPut      = "put"
+	OpList     = "list"
+	OpTake     = "take"
+	OpRemove   = "remove"
+	OpMove     = "move"
+	OpJoin     = "join"
+	OpLeave    = "leave"
+	OpPosition = "position"
+	OpNotify   = "notify"
+	OpManage   = "manage" // queue settings, e.g., the intake form
+	OpHistory  = "history"
+	OpStats    = "stats"
+)
+
+// Roles that may perform each operation unless the queue's policy says
+// otherwise. Owners may perform any operation.
+var defaultGrants = map[string][]Role{
+	OpPut:      {RoleStudent},
+	OpList:     {RoleTA, RoleObserver},
+	OpTake:     {RoleTA},
+	OpRemove:   {RoleTA},
+	OpMove:     {RoleTA},
+	OpJoin:     {RoleStudent},
+	OpLeave:    {RoleStudent},
+	OpPosition: {RoleStudent},
+	OpNotify:   {RoleStudent},
+	OpManage:   {},
+	OpHistory:  {RoleTA},
+	OpStats:    {RoleTA, RoleObserver},
+}
+
+func IsOp(op string) bool {
+	_, ok := defaultGrants[op]
+	return ok
+}
+
+// Users in a role: members of channels or user groups, or listed explicitly.
+type Members struct {
+	Channels   []string `json:"Channels,omitempty"`
+	Usergroups []string `json:"Usergroups,omitempty"`
+	Users      []string `json:"Users,omitempty"`
+}
+
+var (
+	// Channel, user group and user mentions, or bare IDs, e.g., "<#C123|tas>",
+	// "<!subteam^S123|@tas>", "<@U123|ada>" or "U123".
+	channelMember   = regexp.MustCompile(`^(?:<#([CG][A-Z0-9]+)(?:\|[^>]*)?>|([CG][A-Z0-9]+))$`)
+	usergroupMember = regexp.MustCompile(`^(?:<!subteam\^(S[A-Z0-9]+)(?:\|[^>]*)?>|(S[A-Z0-9]+))$`)
+	userMember      = regexp.MustCompile(`^(?:<@([UW][A-Z0-9]+)(?:\|[^>]*)?>|([UW][A-Z0-9]+))$`)
+)
+
+// Parses members as mentioned in a slash command, e.g.,
+// "<#C123|tas> <!subteam^S123|@tas> <@U123|ada>". Bare IDs are also
+// accepted; anything else, e.g., a name, is an error. An empty definition
+// returns nil members, i.e., the role's default.
+func ParseMembers(def string) (m *Members, err error) {
+	def = strings.TrimSpace(def)
+	if def == "" || def == "default" {
+		return
+	}
+	m = &Members{}
+	for _, tok := range strings.Fields(def) {
+		if id, ok := matchID(channelMember, tok); ok {
+			m.Channels = append(m.Channels, id)
+		} else if id, ok := matchID(usergroupMember, tok); ok {
+			m.Usergroups = append(m.Usergroups, id)
+		} else if id, ok := matchID(userMember, tok); ok {
+			m.Users = append(m.Users, id)
+		} else {
+			return nil, fmt.Errorf("Unknown member '%v'", tok)
+		}
+	}
+	return
+}
+
+// The ID in a mention or bare ID matched by re.
+func matchID(re *regexp.Regexp, tok string) (id string, ok bool) {
+	sub := re.FindStringSubmatch(tok)
+	if sub == nil {
+		return "", false
+	}
+	return sub[1] + sub[2], true
+}
+
+func (m *Members) String() string {
+	var parts []string
+	for _, id := range m.Channels {
+		parts = append(parts, fmt.Sprintf("<#%s>", id))
+	}
+	for _, id := range m.Usergroups {
+		parts = append(parts, fmt.Sprintf("<!subteam^%s>", id))
+	}
+	for _, id := range m.Users {
+		parts = append(parts, fmt.Sprintf("<@%s>", id))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Resolves channel and user group membership.
+type MemberResolver interface {
+	ChannelMembers(channelID string) (users []string, err error)
+	UsergroupMembers(groupID string) (users []string, err error)
+}
+
+// Resolves membership from Slack, through a shared cache.
+type SlackMemberResolver struct {
+	api     *slack.Client
+	members *MembershipCache
+}
+
+func (r SlackMemberResolver) ChannelMembers(channelID string) ([]string, error) {
+	return r.members.Members(ChannelMembersKey(channelID), func() ([]string, error) {
+		return getUsersInChannel(r.api, channelID)
+	})
+}
+
+func (r SlackMemberResolver) UsergroupMembers(groupID string) ([]string, error) {
+	return r.members.Members(UsergroupMembersKey(groupID), func() ([]string, error) {
+		return r.api.GetUserGroupMembers(groupID)
+	})
+}
+
+func (m *Members) contains(r MemberResolver, userID string) (ok bool, err error) {
+	for _, id := range m.Users {
+		if id == userID {
+			return true, nil
+		}
+	}
+	var users []string
+	for _, id := range m.Channels {
+		if users, err = r.ChannelMembers(id); err != nil {
+			return
+		}
+		if containsString(users, userID) {
+			return true, nil
+		}
+	}
+	for _, id := range m.Usergroups {
+		if users, err = r.UsergroupMembers(id); err != nil {
+			return
+		}
+		if containsString(users, userID) {
+			return true, nil
+		}
+	}
+	return
+}
+
+func containsString(lst []string, s string) bool {
+	for _, e := range lst {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A queue's permissions: who is in each role, and which roles may perform
+// each operation. Roles without members default to: owners and TAs, the
+// queue's admins; observers, no one; students, everyone. Operations without
+// grants default to defaultGrants.
+//
+// Policies are replaced rather than modified, so may be shared.
+type Policy struct {
+	Members map[Role]*Members `json:"Members,omitempty"`
+	Grants  map[string][]Role `json:"Grants,omitempty"`
+}
+
+// Returns a copy of the policy with a role's members replaced. Nil members
+// restore the role's default.
+func (p *Policy) WithMembers(role Role, m *Members) *Policy {
+	np := p.copy()
+	if m == nil {
+		delete(np.Members, role)
+	} else {
+		np.Members[role] = m
+	}
+	return np
+}
+
+// Returns a copy of the policy with the roles that may perform op replaced.
+// Nil roles restore the operation's default.
+func (p *Policy) WithGrant(op string, rs []Role) *Policy {
+	np := p.copy()
+	if rs == nil {
+		delete(np.Grants, op)
+	} else {
+		np.Grants[op] = rs
+	}
+	return np
+}
+
+func (p *Policy) copy() *Policy {
+	np := &Policy{Members: make(map[Role]*Members), Grants: make(map[string][]Role)}
+	if p == nil {
+		return np
+	}
+	for r, m := range p.Members {
+		np.Members[r] = m
+	}
+	for op, rs := range p.Grants {
+		np.Grants[op] = rs
+	}
+	return np
+}
+
+func (p *Policy) members(role Role) *Members {
+	if p == nil {
+		return nil
+	}
+	return p.Members[role]
+}
+
+func (p *Policy) grants(op string) []Role {
+	if p != nil {
+		if rs, ok := p.Grants[op]; ok {
+			return rs
+		}
+	}
+	return defaultGrants[op]
+}
+
+func (p *Policy) String() string {
+	var lines []string
+	for _, r := range roles {
+		var desc string
+		if m := p.members(r); m != nil {
+			desc = m.String()
+		} else {
+			switch r {
+			case RoleOwner, RoleTA:
+				desc = "admins"
+			case RoleObserver:
+				desc = "no one"
+			case RoleStudent:
+				desc = "everyone"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", r, desc))
+	}
+	var ops []string
+	for op := range defaultGrants {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		rs := []string{string(RoleOwner)}
+		for _, r := range p.grants(op) {
+			rs = append(rs, string(r))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", op, strings.Join(rs, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *QueueService) Policy() *Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+func (s *QueueService) SetPolicy(p *Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// Checks users against a queue's policy.
+type Authorizer struct {
+	api     *slack.Client
+	perms   AdminInterface // members of roles without their own
+	members MemberResolver
+}
+
+func MakeAuthorizer(api *slack.Client, members *MembershipCache, perms AdminInterface) *Authorizer {
+	return &Authorizer{api: api, perms: perms, members: SlackMemberResolver{api, members}}
+}
+
+// Whether user is in role, under policy p.
+func (a *Authorizer) HasRole(p *Policy, role Role, user *slack.User) (ok bool, err error) {
+	if m := p.members(role); m != nil {
+		return m.contains(a.members, user.ID)
+	}
+	switch role {
+	case RoleOwner, RoleTA:
+		return a.perms.IsAdmin(user)
+	case RoleStudent:
+		return true, nil
+	}
+	return
+}
+
+// Checks that user may perform op on the queue; errors are ready to be
+// reported to the user.
+func (a *Authorizer) Check(s *QueueService, op string, user *slack.User) error {
+	p := s.Policy()
+	for _, role := range append([]Role{RoleOwner}, p.grants(op)...) {
+		ok, err := a.HasRole(p, role, user)
+		if err != nil {
+			return NewError(ErrUpstream, fmt.Errorf("checking whether %v (%v) is %v: %w", user.ID, user.Name, role, err))
+		}
+		if ok {
+			glog.V(1).Infof("%v (%v) may %v as %v", user.ID, user.Name, op, role)
+			return nil
+		}
+	}
+	return Errorf(ErrPermissionDenied, "%v (%v) may not %v", user.ID, user.Name, op)
+}
+
+// Checks the queue's policy before handling a command.
+func (a *Authorizer) Command(op string, c Command) Command {
+	return &authorizedCommand{a, op, c}
+}
+
+// Checks the queue's policy before handling an action.
+func (a *Authorizer) Action(op string, act Action) Action {
+	return &authorizedAction{a, op, act}
+}
+
+type authorizedCommand struct {
+	auth *Authorizer
+	op   string
+	next Command
+}
+
+func (c *authorizedCommand) Handle(ctx context.Context, cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
+	user := &slack.User{ID: cmd.UserID, Name: cmd.UserName, TeamID: cmd.TeamID}
+	if err = c.auth.Check(s, c.op, user); err != nil {
+		ReplyCommandError(ctx, w, cmd, s.LocaleOf(s.u, cmd.UserID), err)
+		return
+	}
+	return c.next.Handle(ctx, cmd, s, w)
+}
+
+type authorizedAction struct {
+	auth *Authorizer
+	op   string
+	next Action
+}
+
+func (a *authorizedAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+	user := &action.User
+	if err := a.auth.Check(s, a.op, user); err != nil {
+		ReplyActionError(ctx, w, a.auth.api, action, s.LocaleOf(s.u, user.ID), err)
+		return
+	}
+	a.next.Handle(ctx, action, s, w)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"github.com/slack-go/slack"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type fixedMembers map[string][]string
+
+func (m fixedMembers) ChannelMembers(channelID string) ([]string, error) {
+	return m[channelID], nil
+}
+
+func (m fixedMembers) UsergroupMembers(groupID string) ([]string, error) {
+	return m[groupID], nil
+}
+
+type recordingCommand struct {
+	handled bool
+}
+
+func (c *recordingCommand) Handle(ctx context.Context, cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) error {
+	c.handled = true
+	return nil
+}
+
+func testAuthorizer(admins ...string) *Authorizer {
+	perms := errorAdmins{admins: make(map[string]bool)}
+	for _, id := range admins {
+		perms.admins[id] = true
+	}
+	return &Authorizer{
+		perms:   perms,
+		members: fixedMembers{"C1": {"O1"}, "S1": {"T1", "T2"}},
+	}
+}
+
+func TestParseMembers(t *testing.T) {
+	m, err := ParseMembers("<#C1|observers> <!subteam^S1|@tas> <@U1|ada> W2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Members{Channels: []string{"C1"}, Usergroups: []string{"S1"}, Users: []string{"U1", "W2"}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, m)
+	}
+	if m, err = ParseMembers("default"); m != nil || err != nil {
+		t.Fatalf("Expected default members, got %+v (%v)", m, err)
+	}
+	for _, def := range []string{"@someone", "Carl", "<#U1>", "<@C1|x>", "S1x", "<!subteam^>"} {
+		if _, err = ParseMembers(def); err == nil {
+			t.Fatalf("Accepted invalid member %q", def)
+		}
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	auth := testAuthorizer("A1")
+	s := TS(&MockUserLookup{}, nil)
+	admin := &slack.User{ID: "A1"}
+	student := &slack.User{ID: "U1"}
+
+	for _, op := range []string{OpList, OpTake, OpRemove, OpMove, OpManage} {
+		if err := auth.Check(s, op, admin); err != nil {
+			t.Fatalf("Admin may not %v: %v", op, err)
+		}
+		if err := auth.Check(s, op, student); AsError(err).Kind != ErrPermissionDenied {
+			t.Fatalf("Student may %v: %v", op, err)
+		}
+	}
+	if err := auth.Check(s, OpPut, student); err != nil {
+		t.Fatalf("Student may not enqueue: %v", err)
+	}
+}
+
+func TestPolicyRoles(t *testing.T) {
+	auth := testAuthorizer("A1")
+	s := TS(&MockUserLookup{}, nil)
+	observers, _ := ParseMembers("<#C1>")
+	tas, _ := ParseMembers("<!subteam^S1>")
+	s.SetPolicy(s.Policy().WithMembers(RoleObserver, observers).WithMembers(RoleTA, tas))
+
+	observer := &slack.User{ID: "O1"}
+	if err := auth.Check(s, OpList, observer); err != nil {
+		t.Fatalf("Observer may not list: %v", err)
+	}
+	if err := auth.Check(s, OpTake, observer); err == nil {
+		t.Fatalf("Observer may take")
+	}
+	if err := auth.Check(s, OpTake, &slack.User{ID: "T2"}); err != nil {
+		t.Fatalf("TA may not take: %v", err)
+	}
+	// Owners default to admins; TAs no longer do.
+	if err := auth.Check(s, OpManage, &slack.User{ID: "A1"}); err != nil {
+		t.Fatalf("Owner may not manage: %v", err)
+	}
+
+	s.SetPolicy(s.Policy().WithGrant(OpTake, []Role{RoleObserver}))
+	if err := auth.Check(s, OpTake, observer); err != nil {
+		t.Fatalf("Observer may not take when allowed: %v", err)
+	}
+	if err := auth.Check(s, OpTake, &slack.User{ID: "T1"}); err == nil {
+		t.Fatalf("TA may take when not allowed")
+	}
+}
+
+func TestAuthorizedCommand(t *testing.T) {
+	auth := testAuthorizer("A1")
+	s := TS(&MockUserLookup{}, nil)
+	s.SetLocale(DefaultLocale)
+	next := &recordingCommand{}
+	c := auth.Command(OpTake, next)
+
+	w := httptest.NewRecorder()
+	c.Handle(context.Background(), &slack.SlashCommand{UserID: "U1"}, s, w)
+	if next.handled || w.Body.Len() == 0 {
+		t.Fatalf("Denied command was handled, or denial not reported")
+	}
+
+	c.Handle(context.Background(), &slack.SlashCommand{UserID: "A1"}, s, httptest.NewRecorder())
+	if !next.handled {
+		t.Fatalf("Permitted command was not handled")
+	}
+}
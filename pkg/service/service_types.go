@@ -1,6 +1,8 @@
 package service
 
 import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
 	"github.com/slack-go/slack"
 
 	"time"
@@ -9,6 +11,7 @@ import (
 type EnqueueRequest struct {
 	User     *slack.User
 	Metadata string
+	Fields   []queue.Field
 }
 
 type EnqueueResponse struct {
@@ -22,13 +25,17 @@ type EnqueueResponse struct {
 type DequeueRequest struct {
 	Place int
 	Token int64
+	Admin string // ID of the user dequeueing
 }
 
 type DequeueResponse struct {
 	User      *slack.User
 	Metadata  string
+	Fields    []queue.Field
 	Timestamp time.Time
 	Token     int64
+	// Why no one was dequeued, e.g., the queue is empty or stale.
+	Err error
 }
 
 type ListRequest struct {
@@ -37,6 +44,7 @@ type ListRequest struct {
 type ListResponse struct {
 	Users    []*slack.User
 	Metadata []string
+	Fields   [][]queue.Field
 	Times    []time.Time
 	Token    int64
 }
@@ -44,6 +52,7 @@ type ListResponse struct {
 type RemoveRequest struct {
 	Pos   int
 	Token int64
+	Admin string // ID of the user removing
 }
 
 type RemoveResponse struct {
@@ -51,6 +60,39 @@ type RemoveResponse struct {
 	Token int64
 }
 
+type RemoveUserRequest struct {
+	Id string
+}
+
+type RemoveUserResponse struct {
+	Ok    bool
+	Pos   int
+	Token int64
+}
+
+type PositionRequest struct {
+	Id string
+}
+
+type PositionResponse struct {
+	Ok        bool
+	Pos       int
+	Size      int
+	Timestamp time.Time
+	Token     int64
+}
+
+type SummaryRequest struct {
+}
+
+type SummaryResponse struct {
+	Size          int
+	Oldest        time.Time
+	Estimated     bool
+	EstimatedWait time.Duration // for a user joining now
+	Token         int64
+}
+
 type MoveRequest struct {
 	Pos   int
 	NPos  int
@@ -61,3 +103,13 @@ type MoveResponse struct {
 	Ok    bool
 	Token int64
 }
+
+type NotifyRequest struct {
+	Id string
+}
+
+type NotifyResponse struct {
+	Queued  bool // whether the user is in the queue
+	Enabled bool // whether notifications are now enabled
+	At      int  // position at which the user is notified
+}
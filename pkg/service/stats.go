@@ -0,0 +1,201 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"github.com/slack-go/slack"
+
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Number of busiest hours reported.
+const busiestHoursShown = 3
+
+// Load on a queue over a period, computed from its history.
+type QueueStats struct {
+	Since time.Time
+	Until time.Time
+
+	Outcomes map[Outcome]int // visits ending in the period, by outcome
+
+	ActiveHours   int     // clock hours in which someone was served
+	ServedPerHour float64 // over active hours
+	MedianWait    time.Duration
+	P90Wait       time.Duration // of those served
+
+	PeakLength int // most users waiting at once
+	PeakTime   time.Time
+
+	BusiestHours []HourCount  // hours of the day most users joined in, busiest first
+	Sessions     []AdminCount // users served by each admin, most first
+}
+
+type HourCount struct {
+	Hour   int
+	Joined int
+}
+
+type AdminCount struct {
+	AdminID string
+	Served  int
+}
+
+// Returns the contents of the queue.
+func (s *QueueService) Snapshot() (els []queue.Element, seq int64) {
+	return s.q.List()
+}
+
+// Computes the stats of visits ending in [since, until). Entries must be
+// oldest first, and include all those ending at or after since; those ending
+// later, and the users still waiting, count only towards the peak length.
+func ComputeStats(entries []HistoryEntry, waiting []queue.Element, since time.Time, until time.Time) (st QueueStats) {
+	st.Since, st.Until = since, until
+	st.Outcomes = make(map[Outcome]int)
+	var waits []time.Duration
+	hours := make(map[time.Time]bool)
+	joined := make(map[int]int)
+	sessions := make(map[string]int)
+	type change struct {
+		t time.Time
+		d int
+	}
+	var changes []change
+	for _, e := range entries {
+		changes = append(changes, change{e.Enqueued, 1}, change{e.Dequeued, -1})
+		if e.Dequeued.Before(since) || !e.Dequeued.Before(until) {
+			continue
+		}
+		st.Outcomes[e.Outcome]++
+		joined[e.Enqueued.In(since.Location()).Hour()]++
+		if e.Outcome == OutcomeServed {
+			waits = append(waits, e.Wait())
+			hours[e.Dequeued.Truncate(time.Hour)] = true
+			if e.AdminID != "" {
+				sessions[e.AdminID]++
+			}
+		}
+	}
+	for _, el := range waiting {
+		changes = append(changes, change{el.QTime, 1})
+	}
+
+	st.ActiveHours = len(hours)
+	if st.ActiveHours > 0 {
+		st.ServedPerHour = float64(len(waits)) / float64(st.ActiveHours)
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	st.MedianWait = percentile(waits, 50)
+	st.P90Wait = percentile(waits, 90)
+
+	// Leaving before joining at the same instant keeps the peak honest.
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].t.Equal(changes[j].t) {
+			return changes[i].d < changes[j].d
+		}
+		return changes[i].t.Before(changes[j].t)
+	})
+	// Those who joined before the period and left during or after it were
+	// waiting when it began, so the peak is at least their number.
+	n, seeded := 0, false
+	seed := func() {
+		seeded = true
+		if n > 0 {
+			st.PeakLength, st.PeakTime = n, since
+		}
+	}
+	for _, c := range changes {
+		if !seeded && !c.t.Before(since) {
+			seed()
+		}
+		n += c.d
+		if n > st.PeakLength && !c.t.Before(since) && c.t.Before(until) {
+			st.PeakLength, st.PeakTime = n, c.t
+		}
+	}
+	if !seeded {
+		seed()
+	}
+
+	for h, n := range joined {
+		st.BusiestHours = append(st.BusiestHours, HourCount{h, n})
+	}
+	sort.Slice(st.BusiestHours, func(i, j int) bool {
+		a, b := st.BusiestHours[i], st.BusiestHours[j]
+		return a.Joined > b.Joined || (a.Joined == b.Joined && a.Hour < b.Hour)
+	})
+	if len(st.BusiestHours) > busiestHoursShown {
+		st.BusiestHours = st.BusiestHours[:busiestHoursShown]
+	}
+	for id, n := range sessions {
+		st.Sessions = append(st.Sessions, AdminCount{id, n})
+	}
+	sort.Slice(st.Sessions, func(i, j int) bool {
+		a, b := st.Sessions[i], st.Sessions[j]
+		return a.Served > b.Served || (a.Served == b.Served && a.AdminID < b.AdminID)
+	})
+	return
+}
+
+// Nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p + 99) / 100
+	if i < 1 {
+		i = 1
+	}
+	return sorted[i-1]
+}
+
+// Renders stats of the queue in channel, for the period described by desc,
+// e.g., "today".
+func StatsBlocks(s *QueueService, locale string, channel string, desc string, st QueueStats) []slack.Block {
+	header := slack.NewTextBlockObject("plain_text", s.Msg(locale, MsgStatsHeader, Args{"Period": desc}), false, false)
+	blocks := []slack.Block{slack.NewHeaderBlock(header)}
+	context := mrkdwn(s.Msg(locale, MsgStatsRange, Args{"Channel": channel,
+		"Since": st.Since.Format("Mon Jan 2 15:04"), "Until": st.Until.Format("Mon Jan 2 15:04 MST")}))
+	blocks = append(blocks, slack.NewContextBlock("", context))
+
+	total := 0
+	for _, n := range st.Outcomes {
+		total += n
+	}
+	if total == 0 {
+		return append(blocks, slack.NewSectionBlock(mrkdwn(s.Msg(locale, MsgStatsEmpty, nil)), nil, nil))
+	}
+
+	fields := []*slack.TextBlockObject{
+		mrkdwn(s.Msg(locale, MsgStatsVisits, Args{"Total": total, "Served": st.Outcomes[OutcomeServed],
+			"Removed": st.Outcomes[OutcomeRemoved], "Left": st.Outcomes[OutcomeLeft], "Expired": st.Outcomes[OutcomeExpired]})),
+		mrkdwn(s.Msg(locale, MsgStatsThroughput, Args{"Rate": fmt.Sprintf("%.1f", st.ServedPerHour), "Hours": st.ActiveHours})),
+		mrkdwn(s.Msg(locale, MsgStatsWait, Args{"Median": st.MedianWait.Round(time.Minute).String(), "P90": st.P90Wait.Round(time.Minute).String()})),
+	}
+	if st.PeakLength > 0 {
+		fields = append(fields, mrkdwn(s.Msg(locale, MsgStatsPeak, Args{"Size": st.PeakLength,
+			"Time": st.PeakTime.In(st.Since.Location()).Format("Mon 15:04")})))
+	}
+	var hours []string
+	for _, h := range st.BusiestHours {
+		hours = append(hours, s.Msg(locale, MsgStatsHour, Args{"Hour": fmt.Sprintf("%02d:00", h.Hour), "Joined": h.Joined}))
+	}
+	fields = append(fields, mrkdwn(s.Msg(locale, MsgStatsHours, Args{"Hours": strings.Join(hours, ", ")})))
+	blocks = append(blocks, slack.NewSectionBlock(nil, fields, nil))
+
+	if len(st.Sessions) > 0 {
+		var lines []string
+		for _, a := range st.Sessions {
+			lines = append(lines, fmt.Sprintf("<@%s>: %d", a.AdminID, a.Served))
+		}
+		sessions := mrkdwn(s.Msg(locale, MsgStatsSessions, Args{"Sessions": strings.Join(lines, "\n")}))
+		blocks = append(blocks, slack.NewSectionBlock(sessions, nil, nil))
+	}
+	return blocks
+}
+
+func mrkdwn(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject("mrkdwn", text, false, false)
+}
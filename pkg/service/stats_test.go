@@ -0,0 +1,67 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/queue"
+
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	since := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return since.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	entries := []HistoryEntry{
+		// Before the period; counts only towards the peak.
+		{UserID: "U0", Enqueued: at(-2, 0), Dequeued: at(-1, 0), Outcome: OutcomeServed, AdminID: "A1"},
+		{UserID: "U1", Enqueued: at(14, 0), Dequeued: at(14, 10), Outcome: OutcomeServed, AdminID: "A1"},
+		{UserID: "U2", Enqueued: at(14, 5), Dequeued: at(14, 25), Outcome: OutcomeServed, AdminID: "A2"},
+		{UserID: "U3", Enqueued: at(14, 6), Dequeued: at(14, 30), Outcome: OutcomeLeft},
+		{UserID: "U4", Enqueued: at(15, 0), Dequeued: at(15, 40), Outcome: OutcomeServed, AdminID: "A1"},
+	}
+	waiting := []queue.Element{{Id: "U5", QTime: at(14, 20)}}
+	st := ComputeStats(entries, waiting, since, at(24, 0))
+
+	if st.Outcomes[OutcomeServed] != 3 || st.Outcomes[OutcomeLeft] != 1 {
+		t.Fatalf("Unexpected outcomes: %v", st.Outcomes)
+	}
+	if st.MedianWait != 20*time.Minute || st.P90Wait != 40*time.Minute {
+		t.Fatalf("Unexpected waits: median %v, p90 %v", st.MedianWait, st.P90Wait)
+	}
+	if st.ActiveHours != 2 || st.ServedPerHour != 1.5 {
+		t.Fatalf("Unexpected throughput: %v over %d hours", st.ServedPerHour, st.ActiveHours)
+	}
+	if st.PeakLength != 3 || !st.PeakTime.Equal(at(14, 6)) {
+		t.Fatalf("Unexpected peak: %d at %v", st.PeakLength, st.PeakTime)
+	}
+	if len(st.BusiestHours) != 2 || st.BusiestHours[0] != (HourCount{14, 3}) {
+		t.Fatalf("Unexpected busiest hours: %v", st.BusiestHours)
+	}
+	if len(st.Sessions) != 2 || st.Sessions[0] != (AdminCount{"A1", 2}) {
+		t.Fatalf("Unexpected sessions: %v", st.Sessions)
+	}
+	if blocks := StatsBlocks(TS(&MockUserLookup{}, nil), English, "C1", "today", st); len(blocks) != 4 {
+		t.Fatalf("Expected header, context, stats and sessions, got %d blocks", len(blocks))
+	}
+}
+
+func TestComputeStatsPeakAtStart(t *testing.T) {
+	since := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return since.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	// Two waiting overnight, then one at a time.
+	entries := []HistoryEntry{
+		{UserID: "U1", Enqueued: at(-1, 0), Dequeued: at(9, 0), Outcome: OutcomeServed},
+		{UserID: "U2", Enqueued: at(-1, 10), Dequeued: at(9, 30), Outcome: OutcomeServed},
+		{UserID: "U3", Enqueued: at(10, 0), Dequeued: at(10, 5), Outcome: OutcomeServed},
+	}
+	waiting := []queue.Element{{Id: "U4", QTime: at(-2, 0)}}
+	st := ComputeStats(entries, waiting, since, at(24, 0))
+	if st.PeakLength != 3 || !st.PeakTime.Equal(since) {
+		t.Fatalf("Expected a peak of 3 at the start, got %d at %v", st.PeakLength, st.PeakTime)
+	}
+
+	// Everyone joined before the period and is still waiting.
+	st = ComputeStats(nil, waiting, since, at(24, 0))
+	if st.PeakLength != 1 || !st.PeakTime.Equal(since) {
+		t.Fatalf("Expected a peak of 1 at the start, got %d at %v", st.PeakLength, st.PeakTime)
+	}
+}
@@ -1,20 +1,29 @@
 package service
 
 import (
-	"github.com/golang/glog"
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/queue"
+
 	"github.com/slack-go/slack"
 
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 )
 
-func sendMatchDM(user *slack.User, admin *slack.User, msg string, api *slack.Client) (err error) {
-	txt := fmt.Sprintf(
-		"Hello %s! You've been matched with %s. Would you like to start a Zoom call?",
-		user.RealName, admin.RealName)
-	if msg != "" {
-		txt = fmt.Sprintf("%s Topic: %s", txt, msg)
+// Introduces a student to the admin who dequeued them, in the student's
+// locale.
+func sendMatchDM(s *QueueService, user *slack.User, admin *slack.User, msg string, fields []queue.Field, link string, api *slack.Client) (err error) {
+	locale := s.UserLocale(user)
+	txt := s.Msg(locale, MsgMatch, Args{"Student": user.RealName, "Admin": admin.RealName})
+	if link != "" {
+		txt = fmt.Sprintf("%s %s", txt, s.Msg(locale, MsgMatchLink, Args{"Link": link}))
+	}
+	if len(fields) > 0 {
+		txt = fmt.Sprintf("%s\n%s", txt, formatFields(fields))
+	} else if msg != "" {
+		txt = fmt.Sprintf("%s %s", txt, s.Msg(locale, MsgTopic, Args{"Topic": msg}))
 	}
 	params := &slack.OpenConversationParameters{Users: []string{user.ID, admin.ID}}
 	c, _, _, err := api.OpenConversation(params)
@@ -24,23 +33,18 @@ func sendMatchDM(user *slack.User, admin *slack.User, msg string, api *slack.Cli
 	if msg != "" {
 		_, err = api.SetTopicOfConversation(c.ID, msg)
 	}
-	_, _, err = api.PostMessage(c.ID, slack.MsgOptionText(txt, false))
+	opts := []slack.MsgOption{slack.MsgOptionText(txt, false)}
+	if link != "" {
+		section := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", txt, false, false), nil, nil)
+		opts = append(opts, slack.MsgOptionBlocks(section, meetingButton(s.Msg(locale, MsgMeetingButton, nil), link)))
+	}
+	_, _, err = api.PostMessage(c.ID, opts...)
 	return
 }
 
-func (a *TakeAction) Handle(action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
+func (a *TakeAction) Handle(ctx context.Context, action *slack.InteractionCallback, s *QueueService, w http.ResponseWriter) {
 	user := &action.User
-	ok, err := a.perms.IsAdmin(user)
-	if err != nil {
-		glog.Errorf("Error checking admin status of %v (%v): %v", user.ID, user.Name, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !ok {
-		glog.Errorf("Permission denied to user %v (%v)", user.ID, user.Name)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	var err error
 
 	var pos int
 	var token int64
@@ -51,57 +55,63 @@ func (a *TakeAction) Handle(action *slack.InteractionCallback, s *QueueService,
 			pos, token, err = ParseActionValue(act.Value)
 			found = true
 			if err != nil {
-				glog.Error("Error parsing action value %v: %v", act.Value, err)
-				w.WriteHeader(http.StatusInternalServerError)
+				ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), fmt.Errorf("parsing action value %v: %w", act.Value, err))
 				return
 			}
 			break
 		}
 	}
 
+	log := logging.FromContext(ctx)
 	if !found {
-		glog.Errorf("Take action not found for remove callback!")
+		log.Errorf("Take action not found in callback")
 	}
 
-	glog.Infof("Dequeuing position %d with token %d", pos, token)
+	log.With(logging.Seq, token).Infof("Dequeuing position %d", pos)
 
-	req := &DequeueRequest{}
+	req := &DequeueRequest{Token: token, Admin: user.ID}
 	resp := &DequeueResponse{}
 
 	req.Place = pos
 
-	err = s.Dequeue(req, resp)
+	err = s.Dequeue(ctx, req, resp)
 	if err != nil {
-		glog.Errorf("Error dequeueing a request from %v (%v): %v", user.ID, user.Name, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), NewError(ErrUpstream, fmt.Errorf("dequeueing: %w", err)))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-
-	// Replace list with updated state.
-	updateListInUI(action, s, a.api)
-
-	// If no user was dequeued, stop.
 	if resp.User == nil {
+		// Stale list, or the user left.
+		err = resp.Err
+		if _, stale := err.(queue.VersionError); !stale {
+			err = NewError(ErrNotFound, err)
+		}
+		ReplyActionError(ctx, w, a.api, action, s.LocaleOf(a.ul, user.ID), err)
+		updateListInUI(action, s, a.api, s.LocaleOf(a.ul, user.ID))
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+
+	// Replace list with updated state.
+	updateListInUI(action, s, a.api, s.LocaleOf(a.ul, user.ID))
+
 	fu, err := a.ul.Lookup(user.ID)
 	if err == nil {
 		user = fu
 	}
 
-	err = sendMatchDM(resp.User, user, resp.Metadata, a.api)
+	link := a.profiles.MeetingLink(action.Team.ID, user, resp.User, resp.Metadata)
+	err = sendMatchDM(s, resp.User, user, resp.Metadata, resp.Fields, link, a.api)
 	if err != nil {
-		glog.Errorf("Error sending match message: %+v", err)
+		log.Errorf("Error sending match message to %v: %v", resp.User.ID, err)
 	}
 
 	wt := time.Now().Sub(resp.Timestamp)
-	str := fmt.Sprintf("%s dequeued %s (wait time %v)", userToLink(user), userToLink(resp.User), wt)
+	str := s.Msg(s.Locale(), MsgDequeued, Args{"Admin": userToLink(user), "User": userToLink(resp.User), "Wait": wt})
 	cerr := a.perms.SendAdminMessage(str)
 	if cerr != nil {
-		glog.Errorf("Error sending admin message for dequeue of %v by %v: %v", resp.User.Name, action.User.Name, cerr)
+		log.Errorf("Error sending admin message for dequeue of %v: %v", resp.User.ID, cerr)
 	}
 
 	return
@@ -1,28 +1,26 @@
 package service
 
 import (
+	"github.com/ml8/slack-queue/pkg/logging"
+
 	"github.com/golang/glog"
 	"github.com/slack-go/slack"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 )
 
-func dequeueAsBlock(cmd *slack.SlashCommand, resp *DequeueResponse) (b []byte) {
-	var userstr string
-	var timestr string
-	if resp.User == nil {
-		userstr = "*Queue is empty.*"
-		timestr = ""
-	} else {
-		userstr = fmt.Sprintf("Ok! Up next is %s.", userToLink(resp.User))
-		if resp.Metadata != "" {
-			userstr = fmt.Sprintf("%s Topic: %s", userstr, resp.Metadata)
-		}
-		timestr = fmt.Sprintf("Time spent in queue: %v", (time.Now().Sub(resp.Timestamp)))
+func dequeueAsBlock(cmd *slack.SlashCommand, s *QueueService, locale string, resp *DequeueResponse, link string) (b []byte) {
+	userstr := s.Msg(locale, MsgUpNext, Args{"User": userToLink(resp.User)})
+	if len(resp.Fields) > 0 {
+		userstr = fmt.Sprintf("%s\n%s", userstr, formatFields(resp.Fields))
+	} else if resp.Metadata != "" {
+		userstr = fmt.Sprintf("%s %s", userstr, s.Msg(locale, MsgTopic, Args{"Topic": resp.Metadata}))
 	}
+	timestr := s.Msg(locale, MsgTimeInQueue, Args{"Wait": time.Now().Sub(resp.Timestamp)})
 
 	fields := make([]*slack.TextBlockObject, 2)
 	fields[0] = slack.NewTextBlockObject("mrkdwn", userstr, false, false)
@@ -30,6 +28,9 @@ func dequeueAsBlock(cmd *slack.SlashCommand, resp *DequeueResponse) (b []byte) {
 	section := slack.NewSectionBlock(nil, fields, nil)
 
 	msg := slack.NewBlockMessage(section)
+	if link != "" {
+		msg = slack.NewBlockMessage(section, meetingButton(s.Msg(locale, MsgMeetingButton, nil), link))
+	}
 	b, err := json.MarshalIndent(msg, "", "  ")
 	if err != nil {
 		glog.Fatalf("Error marshalling json: %v", err)
@@ -37,57 +38,46 @@ func dequeueAsBlock(cmd *slack.SlashCommand, resp *DequeueResponse) (b []byte) {
 	return
 }
 
-func (c *TakeCommand) Handle(cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
-	// Check permission to list queue.
+func (c *TakeCommand) Handle(ctx context.Context, cmd *slack.SlashCommand, s *QueueService, w http.ResponseWriter) (err error) {
 	user := &slack.User{ID: cmd.UserID, Name: cmd.UserName, TeamID: cmd.TeamID}
-	ok, err := c.perms.IsAdmin(user)
-	if err != nil {
-		glog.Errorf("Error checking admin status of %v (%v): %v", cmd.UserID, cmd.UserName, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !ok {
-		glog.Errorf("Permission denied to user %v (%v)", cmd.UserID, cmd.UserName)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
 
-	req := &DequeueRequest{}
+	req := &DequeueRequest{Admin: cmd.UserID}
 	resp := &DequeueResponse{}
 
 	req.Place = 0
 
-	err = s.Dequeue(req, resp)
+	err = s.Dequeue(ctx, req, resp)
 	if err != nil {
-		glog.Errorf("Error dequeueing a request from %v (%v): %v", cmd.UserID, cmd.UserName, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		ReplyCommandError(ctx, w, cmd, s.LocaleOf(c.ul, cmd.UserID), NewError(ErrUpstream, fmt.Errorf("dequeueing: %w", err)))
 		return
 	}
-
-	b := dequeueAsBlock(cmd, resp)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
-
 	if resp.User == nil {
 		// No one was dequeued. Stop.
+		err = NewError(ErrQueueEmpty, resp.Err)
+		ReplyCommandError(ctx, w, cmd, s.LocaleOf(c.ul, cmd.UserID), err)
 		return
 	}
 
+	link := c.profiles.MeetingLink(cmd.TeamID, user, resp.User, resp.Metadata)
+	b := dequeueAsBlock(cmd, s, s.LocaleOf(c.ul, cmd.UserID), resp, link)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+
 	fu, err := c.ul.Lookup(user.ID)
 	if err == nil {
 		user = fu
 	}
 
 	wt := time.Now().Sub(resp.Timestamp)
-	str := fmt.Sprintf("%s dequeued %s (wait time %v)", userToLink(user), userToLink(resp.User), wt)
+	str := s.Msg(s.Locale(), MsgDequeued, Args{"Admin": userToLink(user), "User": userToLink(resp.User), "Wait": wt})
 	cerr := c.perms.SendAdminMessage(str)
 	if cerr != nil {
-		glog.Errorf("Error sending admin message for dequeue of %v by %v: %v", resp.User.Name, cmd.UserName, cerr)
+		logging.FromContext(ctx).Errorf("Error sending admin message for dequeue of %v: %v", resp.User.ID, cerr)
 	}
 
-	err = sendMatchDM(resp.User, user, resp.Metadata, c.api)
+	err = sendMatchDM(s, resp.User, user, resp.Metadata, resp.Fields, link, c.api)
 	if err != nil {
-		glog.Errorf("Error sending match message: %+v", err)
+		logging.FromContext(ctx).Errorf("Error sending match message to %v: %v", resp.User.ID, err)
 	}
 
 	return
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"sync"
+	"time"
+)
+
+const (
+	// Profiles are refetched after this long.
+	DefaultUserTTL = 15 * time.Minute
+	// Failed lookups aren't retried for this long.
+	DefaultNegativeUserTTL = time.Minute
+	// Profiles cached per team.
+	DefaultMaxUsers = 10000
+	// Users looked up at once when prefetching.
+	userFetchParallelism = 4
+)
+
+// Implemented by UserLookups that can look up many users at once, ahead of
+// looking them up individually.
+type UserPrefetcher interface {
+	Prefetch(ids []string)
+}
+
+// A UserLookup that caches profiles and failures. Concurrent lookups of a
+// user that isn't cached share one fetch. When full, the cache evicts
+// expired entries, then arbitrary ones.
+//
+// Thread safe.
+type CachingUserLookup struct {
+	fetch       func(id string) (*slack.User, error)
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxUsers    int
+
+	mu      sync.Mutex
+	entries map[string]userEntry
+	calls   map[string]*userCall
+}
+
+type userEntry struct {
+	user    *slack.User
+	err     error
+	expires time.Time
+}
+
+type userCall struct {
+	done  chan struct{}
+	entry userEntry
+}
+
+// Caches users looked up with fetch, e.g., a SlackClient's GetUserInfo, which
+// calls users.info.
+func MakeCachingUserLookup(fetch func(id string) (*slack.User, error), ttl time.Duration, negativeTTL time.Duration) *CachingUserLookup {
+	return &CachingUserLookup{
+		fetch:       fetch,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxUsers:    DefaultMaxUsers,
+		entries:     make(map[string]userEntry),
+		calls:       make(map[string]*userCall)}
+}
+
+func (ul *CachingUserLookup) Lookup(id string) (user *slack.User, err error) {
+	e := ul.entry(id)
+	if e.err != nil {
+		return nil, e.err
+	}
+	// Callers may modify their copy.
+	u := *e.user
+	return &u, nil
+}
+
+// Looks up the users that aren't cached, a few at a time.
+func (ul *CachingUserLookup) Prefetch(ids []string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, userFetchParallelism)
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, ok := ul.cached(id, time.Now()); ok {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			ul.entry(id)
+			<-sem
+		}(id)
+	}
+	wg.Wait()
+}
+
+// Returns the entry for id, fetching it if it isn't cached, or waiting for
+// the fetch in progress.
+func (ul *CachingUserLookup) entry(id string) userEntry {
+	ul.mu.Lock()
+	if e, ok := ul.entries[id]; ok && !time.Now().After(e.expires) {
+		ul.mu.Unlock()
+		return e
+	}
+	call, ok := ul.calls[id]
+	if ok {
+		ul.mu.Unlock()
+		<-call.done
+		return call.entry
+	}
+	call = &userCall{done: make(chan struct{})}
+	ul.calls[id] = call
+	ul.mu.Unlock()
+
+	ul.fetchUser(id, call)
+	return call.entry
+}
+
+func (ul *CachingUserLookup) fetchUser(id string, call *userCall) {
+	user, err := ul.fetch(id)
+	now := time.Now()
+	if err != nil {
+		glog.Errorf("Error looking up user %v: %v", id, err)
+		call.entry = userEntry{err: err, expires: now.Add(ul.negativeTTL)}
+	} else {
+		glog.V(2).Infof("Looked up user %v", id)
+		call.entry = userEntry{user: user, expires: now.Add(ul.ttl)}
+	}
+
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	delete(ul.calls, id)
+	ul.addLocked(id, call.entry, now)
+	close(call.done)
+}
+
+// Must hold lock. Caches e for id, making room if the cache is full: expired
+// entries are evicted first and, if that isn't enough, arbitrary ones, down
+// to three quarters of the limit so that evictions are infrequent.
+func (ul *CachingUserLookup) addLocked(id string, e userEntry, now time.Time) {
+	if _, ok := ul.entries[id]; !ok && len(ul.entries) >= ul.maxUsers {
+		for k, old := range ul.entries {
+			if now.After(old.expires) {
+				delete(ul.entries, k)
+			}
+		}
+		for k := range ul.entries {
+			if len(ul.entries) < ul.maxUsers-ul.maxUsers/4 {
+				break
+			}
+			delete(ul.entries, k)
+		}
+		glog.V(1).Infof("Evicted users from cache, %d left", len(ul.entries))
+	}
+	ul.entries[id] = e
+}
+
+// Returns the cache entry for id, if it has one that hasn't expired by now.
+func (ul *CachingUserLookup) cached(id string, now time.Time) (e userEntry, ok bool) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	e, ok = ul.entries[id]
+	if ok && now.After(e.expires) {
+		ok = false
+	}
+	return
+}
+
+// A stand-in for a user whose profile couldn't be looked up; rendered as a
+// mention, which Slack resolves.
+func fallbackUser(id string) *slack.User {
+	return &slack.User{ID: id}
+}
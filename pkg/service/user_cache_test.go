@@ -0,0 +1,163 @@
+package service
+
+import (
+	"github.com/slack-go/slack"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Looks up users whose IDs start with "U", counting lookups of each.
+type fakeUsersInfo struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	err     error
+	release chan struct{} // if not nil, lookups wait until it's closed
+}
+
+func (f *fakeUsersInfo) fetch(id string) (*slack.User, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[id]++
+	err, release := f.err, f.release
+	f.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+	if err != nil {
+		return nil, err
+	}
+	if id[0] != 'U' {
+		return nil, errors.New("user_not_found")
+	}
+	return &slack.User{ID: id, Name: "name-" + id}, nil
+}
+
+func (f *fakeUsersInfo) total() (n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		n += c
+	}
+	return
+}
+
+func cachingLookup(f *fakeUsersInfo, ttl time.Duration) *CachingUserLookup {
+	return MakeCachingUserLookup(f.fetch, ttl, ttl)
+}
+
+func TestCachingUserLookupPrefetch(t *testing.T) {
+	f := &fakeUsersInfo{}
+	ul := cachingLookup(f, time.Hour)
+	var ids []string
+	for i := 0; i < 10; i++ {
+		ids = append(ids, fmt.Sprintf("U%d", i))
+	}
+	ul.Prefetch(append(ids, ids...))
+	if n := f.total(); n != len(ids) {
+		t.Fatalf("Expected %d lookups, got %v", len(ids), f.calls)
+	}
+	for _, id := range ids {
+		if user, err := ul.Lookup(id); err != nil || user.Name != "name-"+id {
+			t.Fatalf("Unexpected lookup of %v: %+v (%v)", id, user, err)
+		}
+	}
+	if n := f.total(); n != len(ids) {
+		t.Fatalf("Cached users looked up again: %v", f.calls)
+	}
+}
+
+func TestCachingUserLookupConcurrentMisses(t *testing.T) {
+	f := &fakeUsersInfo{release: make(chan struct{})}
+	ul := cachingLookup(f, time.Hour)
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ul.Lookup("U1"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	// Let the lookups pile up behind the first.
+	time.Sleep(10 * time.Millisecond)
+	close(f.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if n := f.total(); n != 1 {
+		t.Fatalf("Expected one lookup, got %d", n)
+	}
+}
+
+func TestCachingUserLookupEvicts(t *testing.T) {
+	f := &fakeUsersInfo{}
+	ul := cachingLookup(f, time.Hour)
+	ul.maxUsers = 4
+	for i := 0; i < 20; i++ {
+		if _, err := ul.Lookup(fmt.Sprintf("U%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(ul.entries); n > ul.maxUsers {
+			t.Fatalf("Cache grew to %d entries", n)
+		}
+	}
+	// The latest lookup is always kept.
+	if _, ok := ul.cached("U19", time.Now()); !ok {
+		t.Fatalf("Latest user evicted")
+	}
+}
+
+func TestCachingUserLookupNegative(t *testing.T) {
+	f := &fakeUsersInfo{}
+	ul := cachingLookup(f, time.Hour)
+	if _, err := ul.Lookup("B1"); err == nil {
+		t.Fatalf("Expected error for unknown user")
+	}
+	if _, err := ul.Lookup("B1"); err == nil || f.total() != 1 {
+		t.Fatalf("Failure not cached: %v", f.calls)
+	}
+
+	f.err = errors.New("ratelimited")
+	ul = cachingLookup(f, time.Nanosecond)
+	if _, err := ul.Lookup("U1"); err == nil {
+		t.Fatalf("Expected error")
+	}
+	f.err = nil
+	time.Sleep(time.Millisecond)
+	if _, err := ul.Lookup("U1"); err != nil {
+		t.Fatalf("Failure not retried after expiry: %v", err)
+	}
+}
+
+func TestListShowsUnknownUsers(t *testing.T) {
+	f := &fakeUsersInfo{}
+	s := TS(cachingLookup(f, time.Hour), nil)
+	for _, id := range []string{"U1", "B1", "U2"} {
+		s.Enqueue(context.Background(), &EnqueueRequest{User: &slack.User{ID: id}}, &EnqueueResponse{})
+	}
+
+	resp := &ListResponse{}
+	if err := s.List(&ListRequest{}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 3 || resp.Users[1].ID != "B1" || resp.Users[2].ID != "U2" {
+		t.Fatalf("Expected all users in order, got %+v", resp.Users)
+	}
+	if link := userToLink(resp.Users[1]); link != "<@B1>" {
+		t.Fatalf("Unexpected fallback: %v", link)
+	}
+	if n := f.total(); n != 3 {
+		t.Fatalf("Expected each user looked up once, got %v", f.calls)
+	}
+}
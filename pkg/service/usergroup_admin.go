@@ -0,0 +1,56 @@
+package service
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+
+	"github.com/golang/glog"
+	"github.com/slack-go/slack"
+
+	"fmt"
+)
+
+// Admins are the members of a Slack user group. Admin messages are sent to a
+// channel, if one is given.
+//
+// Membership is cached in a MembershipCache, so is refreshed in the
+// background once loaded, and the last known membership is used while Slack
+// is unreachable.
+type UsergroupAdminInterface struct {
+	api     *SlackClient
+	members *MembershipCache
+	group   string
+	channel string
+	fetch   func(group string) ([]string, error)
+}
+
+func MakeUsergroupAdminInterface(api *SlackClient, members *MembershipCache, group string, channel string) *UsergroupAdminInterface {
+	p := &UsergroupAdminInterface{api: api, members: members, group: group, channel: channel}
+	p.fetch = func(group string) ([]string, error) {
+		return p.api.GetUserGroupMembers(group)
+	}
+	return p
+}
+
+func (p *UsergroupAdminInterface) IsAdmin(user *slack.User) (ok bool, err error) {
+	return p.members.Contains(UsergroupMembersKey(p.group), user.ID, func() ([]string, error) {
+		return p.fetch(p.group)
+	})
+}
+
+func (p *UsergroupAdminInterface) AdminChannelID() (id string, err error) {
+	if p.channel == "" {
+		err = fmt.Errorf("user group %v has no admin channel", p.group)
+	}
+	id = p.channel
+	return
+}
+
+func (p *UsergroupAdminInterface) SendAdminMessage(msg string) (err error) {
+	if p.channel == "" {
+		glog.V(1).Infof("No admin channel for user group %v, not sending: %v", p.group, logging.Body(msg))
+		return
+	}
+	return p.api.PostBackground(p.channel,
+		slack.MsgOptionText(msg, false),
+		slack.MsgOptionAsUser(true))
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"github.com/slack-go/slack"
+
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdminInterfaceFromChannel(t *testing.T) {
+	api := NewSlackClient("", DefaultRetryPolicy, nil)
+	defer api.Close()
+	members := MakeMembershipCache(DefaultMembershipTTL)
+	if _, ok := AdminInterfaceFromChannel(api, members, "").(NoopAdminInterface); !ok {
+		t.Fatalf("Expected no-op admins without a channel")
+	}
+	p, ok := AdminInterfaceFromChannel(api, members, "<!subteam^S0123ABC|@tas>,<#C0123ABC|tas>").(*UsergroupAdminInterface)
+	if !ok || p.group != "S0123ABC" || p.channel != "C0123ABC" {
+		t.Fatalf("Expected user group admins, got %+v", p)
+	}
+	c, ok := AdminInterfaceFromChannel(api, members, "C0123ABC").(*ChannelAdminInterface)
+	if !ok || !c.byID || c.chanId != "C0123ABC" {
+		t.Fatalf("Expected channel admins by ID, got %+v", c)
+	}
+	c, ok = AdminInterfaceFromChannel(api, members, "cs101-tas").(*ChannelAdminInterface)
+	if !ok || c.byID {
+		t.Fatalf("Expected channel admins by name, got %+v", c)
+	}
+}
+
+func TestUsergroupAdmins(t *testing.T) {
+	members := MakeMembershipCache(DefaultMembershipTTL)
+	group := []string{"A1"}
+	var fetchErr error
+	p := MakeUsergroupAdminInterface(nil, members, "S1", "")
+	p.fetch = func(id string) ([]string, error) {
+		return group, fetchErr
+	}
+
+	// Nothing to fall back on.
+	fetchErr = errors.New("timeout")
+	if _, err := p.IsAdmin(&slack.User{ID: "A1"}); err == nil {
+		t.Fatalf("Expected error without membership")
+	}
+
+	fetchErr = nil
+	members.entries[UsergroupMembersKey("S1")].retryTime = time.Time{}
+	if ok, err := p.IsAdmin(&slack.User{ID: "A1"}); !ok || err != nil {
+		t.Fatalf("Expected admin, got %v (%v)", ok, err)
+	}
+	if ok, _ := p.IsAdmin(&slack.User{ID: "U1"}); ok {
+		t.Fatalf("Non-member is admin")
+	}
+
+	// Slack is unreachable; the last known membership is used.
+	fetchErr = errors.New("timeout")
+	members.Invalidate(UsergroupMembersKey("S1"))
+	if ok, err := p.IsAdmin(&slack.User{ID: "A1"}); !ok || err != nil {
+		t.Fatalf("Expected last known membership, got %v (%v)", ok, err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	if d := retryBackoff(1); d != minRetryBackoff {
+		t.Fatalf("Expected %v, got %v", minRetryBackoff, d)
+	}
+	if d := retryBackoff(100); d != maxRetryBackoff {
+		t.Fatalf("Expected %v, got %v", maxRetryBackoff, d)
+	}
+}
@@ -0,0 +1,315 @@
+package socket
+
+import (
+	"github.com/ml8/slack-queue/pkg/logging"
+	"github.com/ml8/slack-queue/pkg/server"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/slack-go/slack"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Envelope types sent by Slack over a Socket Mode connection.
+const (
+	HelloType         = "hello"
+	DisconnectType    = "disconnect"
+	SlashCommandsType = "slash_commands"
+	InteractiveType   = "interactive"
+	EventsAPIType     = "events_api"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Receives commands, interactions and events delivered over Socket Mode.
+// Handlers respond exactly as they would to an HTTP request; whatever is
+// written to the ResponseWriter is returned to Slack as the envelope's response
+// payload.
+type Handler interface {
+	Command(ctx context.Context, cmd *slack.SlashCommand, w http.ResponseWriter)
+	Action(ctx context.Context, cb *slack.InteractionCallback, w http.ResponseWriter)
+	Event(ctx context.Context, ev *server.Event)
+}
+
+type Envelope struct {
+	EnvelopeID             string          `json:"envelope_id"`
+	Type                   string          `json:"type"`
+	Payload                json.RawMessage `json:"payload"`
+	AcceptsResponsePayload bool            `json:"accepts_response_payload"`
+	RetryAttempt           int             `json:"retry_attempt"`
+	RetryReason            string          `json:"retry_reason"`
+	Reason                 string          `json:"reason"`
+}
+
+type ack struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// Maintains a Socket Mode connection to Slack, reconnecting when the
+// connection is dropped or Slack asks us to disconnect.
+type Runner struct {
+	apiURL   string
+	appToken string
+	handler  Handler
+	dedupe   *server.Dedupe
+	client   *http.Client
+	dialer   *websocket.Dialer
+	drain    time.Duration // how long handlers may run once ctx is done
+
+	inflight sync.WaitGroup
+	count    int64 // handlers in flight
+}
+
+// apiURL is the Slack Web API root (e.g., slack.APIURL); appToken is an
+// app-level token with the connections:write scope. On shutdown, handlers
+// in flight may run for up to drain.
+func MakeRunner(apiURL string, appToken string, handler Handler, dedupe *server.Dedupe, drain time.Duration) *Runner {
+	return &Runner{
+		apiURL:   apiURL,
+		appToken: appToken,
+		handler:  handler,
+		dedupe:   dedupe,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		dialer:   websocket.DefaultDialer,
+		drain:    drain}
+}
+
+type connectionsOpenResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+	URL   string `json:"url"`
+}
+
+// Requests a websocket URL from apps.connections.open.
+func (r *Runner) open(ctx context.Context) (url string, err error) {
+	req, err := http.NewRequest(http.MethodPost, r.apiURL+"apps.connections.open", nil)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+r.appToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var cresp connectionsOpenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&cresp); err != nil {
+		return
+	}
+	if !cresp.Ok {
+		err = fmt.Errorf("apps.connections.open failed: %s", cresp.Error)
+		return
+	}
+	url = cresp.URL
+	return
+}
+
+// Runs until ctx is cancelled, then waits for handlers in flight. Returns
+// ctx's error once they finish, or a server.DrainError if they don't within
+// the drain timeout.
+func (r *Runner) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		err := r.connect(ctx)
+		if ctx.Err() != nil {
+			return r.wait(ctx.Err())
+		}
+		if err == nil {
+			// Clean disconnect requested by Slack; reconnect immediately.
+			backoff = minBackoff
+			continue
+		}
+		glog.Errorf("Socket Mode connection failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return r.wait(ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Handles a single connection. Returns nil if Slack asked us to reconnect.
+func (r *Runner) connect(ctx context.Context) (err error) {
+	url, err := r.open(ctx)
+	if err != nil {
+		return
+	}
+	glog.Infof("Opening Socket Mode connection...")
+	conn, _, err := r.dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return
+	}
+	// Handlers ack their envelopes over the connection once they finish, so
+	// it's only closed then: Slack redelivers envelopes that weren't acked,
+	// possibly after a restart, when they're no longer deduplicated.
+	var handlers sync.WaitGroup
+	defer func() {
+		go func() {
+			handlers.Wait()
+			conn.Close()
+		}()
+	}()
+
+	// Unblock the read loop on shutdown, leaving the connection open for acks.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var wmu sync.Mutex
+	for {
+		var env Envelope
+		if err = conn.ReadJSON(&env); err != nil {
+			return
+		}
+		switch env.Type {
+		case HelloType:
+			glog.Infof("Socket Mode connection established.")
+		case DisconnectType:
+			glog.Infof("Socket Mode disconnect requested (%s), reconnecting.", env.Reason)
+			return nil
+		default:
+			handlers.Add(1)
+			r.inflight.Add(1)
+			atomic.AddInt64(&r.count, 1)
+			go func(env Envelope) {
+				defer handlers.Done()
+				defer r.inflight.Done()
+				defer atomic.AddInt64(&r.count, -1)
+				payload := r.dispatch(&env)
+				a := ack{EnvelopeID: env.EnvelopeID}
+				if env.AcceptsResponsePayload {
+					a.Payload = payload
+				}
+				wmu.Lock()
+				defer wmu.Unlock()
+				if err := conn.WriteJSON(a); err != nil {
+					glog.Errorf("Error acknowledging envelope %v: %v", env.EnvelopeID, err)
+				}
+			}(env)
+		}
+	}
+}
+
+// Waits up to the drain timeout for handlers in flight, returning err if they
+// finish.
+func (r *Runner) wait(err error) error {
+	glog.Infof("Draining %d in-flight requests (deadline %v)...", atomic.LoadInt64(&r.count), r.drain)
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		r.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		glog.Infof("Drained in-flight requests in %v.", time.Since(start))
+		return err
+	case <-time.After(r.drain):
+		n := atomic.LoadInt64(&r.count)
+		glog.Errorf("Drain incomplete after %v, %d requests in flight", time.Since(start), n)
+		return server.DrainError{InFlight: n}
+	}
+}
+
+// Forwards an envelope to the handler and returns the response payload, if
+// the handler produced one.
+func (r *Runner) dispatch(env *Envelope) (payload json.RawMessage) {
+	if env.RetryAttempt > 0 {
+		glog.Infof("Slack retry %d (%s) of envelope %v", env.RetryAttempt, env.RetryReason, env.EnvelopeID)
+	}
+	w := MakeResponseWriter()
+	switch env.Type {
+	case SlashCommandsType:
+		var cmd slack.SlashCommand
+		if err := json.Unmarshal(env.Payload, &cmd); err != nil {
+			glog.Errorf("Error unmarshalling slash command: %v", err)
+			return
+		}
+		ctx := server.CommandContext(context.Background(), &cmd)
+		logging.FromContext(ctx).Infof("Received command %v in envelope %v", cmd.Command, env.EnvelopeID)
+		r.dedupe.Do(server.RequestKey(cmd.TriggerID, env.Payload), w, func(w http.ResponseWriter) {
+			r.handler.Command(ctx, &cmd, w)
+		})
+	case InteractiveType:
+		var cb slack.InteractionCallback
+		if err := json.Unmarshal(env.Payload, &cb); err != nil {
+			glog.Errorf("Error unmarshalling callback: %v", err)
+			return
+		}
+		ctx := server.ActionContext(context.Background(), &cb)
+		logging.FromContext(ctx).Infof("Received interaction %v in envelope %v", cb.Type, env.EnvelopeID)
+		r.dedupe.Do(server.RequestKey(cb.TriggerID, env.Payload), w, func(w http.ResponseWriter) {
+			r.handler.Action(ctx, &cb, w)
+		})
+	case EventsAPIType:
+		ev, err := server.ParseEvent(env.Payload)
+		if err != nil {
+			glog.Errorf("Error unmarshalling event: %v", err)
+			return
+		}
+		ctx := server.EventContext(context.Background(), ev)
+		logging.FromContext(ctx).Infof("Received event in envelope %v", env.EnvelopeID)
+		r.dedupe.Do(server.RequestKey(ev.EventID, env.Payload), w, func(w http.ResponseWriter) {
+			r.handler.Event(ctx, ev)
+		})
+	default:
+		glog.V(1).Infof("Ignoring envelope of type %v", env.Type)
+		return
+	}
+	if w.Status != http.StatusOK {
+		glog.Errorf("Handler for envelope %v returned status %d", env.EnvelopeID, w.Status)
+		return
+	}
+	if w.Body.Len() > 0 && json.Valid(w.Body.Bytes()) {
+		payload = w.Body.Bytes()
+	}
+	return
+}
+
+// Collects a handler's response so it can be sent back as an envelope
+// payload.
+type ResponseWriter struct {
+	Status int
+	Body   bytes.Buffer
+	header http.Header
+}
+
+func MakeResponseWriter() *ResponseWriter {
+	return &ResponseWriter{Status: http.StatusOK, header: make(http.Header)}
+}
+
+func (w *ResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	w.Status = status
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	return w.Body.Write(b)
+}
@@ -0,0 +1,240 @@
+package socket
+
+import (
+	"github.com/ml8/slack-queue/pkg/server"
+
+	"github.com/gorilla/websocket"
+	"github.com/slack-go/slack"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingHandler struct {
+	mu       sync.Mutex
+	commands []*slack.SlashCommand
+	actions  []*slack.InteractionCallback
+	events   []*server.Event
+}
+
+func (h *recordingHandler) Command(ctx context.Context, cmd *slack.SlashCommand, w http.ResponseWriter) {
+	h.mu.Lock()
+	h.commands = append(h.commands, cmd)
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"text":"queued"}`))
+}
+
+func (h *recordingHandler) Action(ctx context.Context, cb *slack.InteractionCallback, w http.ResponseWriter) {
+	h.mu.Lock()
+	h.actions = append(h.actions, cb)
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *recordingHandler) Event(ctx context.Context, ev *server.Event) {
+	h.mu.Lock()
+	h.events = append(h.events, ev)
+	h.mu.Unlock()
+}
+
+// Local stand-in for Slack's Socket Mode endpoints. Sends each of envelopes
+// on connection and collects the acks.
+type fakeSlack struct {
+	t         *testing.T
+	srv       *httptest.Server
+	envelopes []Envelope
+	acks      chan ack
+}
+
+func makeFakeSlack(t *testing.T, envelopes []Envelope) *fakeSlack {
+	f := &fakeSlack{t: t, envelopes: envelopes, acks: make(chan ack, len(envelopes))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xapp-test" {
+			w.Write([]byte(`{"ok":false,"error":"invalid_auth"}`))
+			return
+		}
+		url := "ws" + strings.TrimPrefix(f.srv.URL, "http") + "/link"
+		fmt.Fprintf(w, `{"ok":true,"url":%q}`, url)
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(Envelope{Type: HelloType})
+		for _, env := range f.envelopes {
+			conn.WriteJSON(env)
+		}
+		for {
+			var a ack
+			if err := conn.ReadJSON(&a); err != nil {
+				return
+			}
+			f.acks <- a
+		}
+	})
+	f.srv = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeSlack) ack(t *testing.T) ack {
+	select {
+	case a := <-f.acks:
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for ack.")
+	}
+	return ack{}
+}
+
+func TestRunnerDispatches(t *testing.T) {
+	cmd, _ := json.Marshal(slack.SlashCommand{Command: "/enqueue", ChannelID: "C1", UserID: "U1", TriggerID: "t1"})
+	cb, _ := json.Marshal(slack.InteractionCallback{Type: slack.InteractionTypeBlockActions, TriggerID: "t2"})
+	f := makeFakeSlack(t, []Envelope{
+		{EnvelopeID: "e1", Type: SlashCommandsType, Payload: cmd, AcceptsResponsePayload: true},
+		{EnvelopeID: "e2", Type: InteractiveType, Payload: cb},
+		{EnvelopeID: "e3", Type: EventsAPIType, Payload: json.RawMessage(`{"type":"event_callback","event_id":"Ev1","event":{"type":"channel_archive","channel":"C1"}}`)},
+	})
+	defer f.srv.Close()
+
+	h := &recordingHandler{}
+	r := MakeRunner(f.srv.URL+"/api/", "xapp-test", h, server.MakeDedupe(time.Minute), time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	acks := map[string]ack{}
+	for i := 0; i < 3; i++ {
+		a := f.ack(t)
+		acks[a.EnvelopeID] = a
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Unexpected error from Run: %v", err)
+	}
+
+	if string(acks["e1"].Payload) != `{"text":"queued"}` {
+		t.Fatalf("Incorrect command response payload: %s", acks["e1"].Payload)
+	}
+	if len(acks["e2"].Payload) != 0 {
+		t.Fatalf("Unexpected interaction payload: %s", acks["e2"].Payload)
+	}
+	if len(h.commands) != 1 || h.commands[0].Command != "/enqueue" || h.commands[0].UserID != "U1" {
+		t.Fatalf("Command not dispatched: %+v", h.commands)
+	}
+	if len(h.actions) != 1 || h.actions[0].TriggerID != "t2" {
+		t.Fatalf("Action not dispatched: %+v", h.actions)
+	}
+	if len(h.events) != 1 || h.events[0].EventID != "Ev1" {
+		t.Fatalf("Event not dispatched: %+v", h.events)
+	}
+}
+
+func TestRunnerDedupesRetries(t *testing.T) {
+	cmd, _ := json.Marshal(slack.SlashCommand{Command: "/dequeue", TriggerID: "t1"})
+	f := makeFakeSlack(t, []Envelope{
+		{EnvelopeID: "e1", Type: SlashCommandsType, Payload: cmd, AcceptsResponsePayload: true},
+		{EnvelopeID: "e2", Type: SlashCommandsType, Payload: cmd, AcceptsResponsePayload: true, RetryAttempt: 1},
+	})
+	defer f.srv.Close()
+
+	h := &recordingHandler{}
+	r := MakeRunner(f.srv.URL+"/api/", "xapp-test", h, server.MakeDedupe(time.Minute), time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	for i := 0; i < 2; i++ {
+		a := f.ack(t)
+		if string(a.Payload) != `{"text":"queued"}` {
+			t.Fatalf("Incorrect payload for %v: %s", a.EnvelopeID, a.Payload)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.commands) != 1 {
+		t.Fatalf("Retried command handled %d times", len(h.commands))
+	}
+}
+
+func TestOpenFailure(t *testing.T) {
+	f := makeFakeSlack(t, nil)
+	defer f.srv.Close()
+
+	r := MakeRunner(f.srv.URL+"/api/", "wrong", &recordingHandler{}, server.MakeDedupe(time.Minute), time.Second)
+	_, err := r.open(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Fatalf("Expected invalid_auth error, got %v", err)
+	}
+}
+
+// Blocks commands until release is closed.
+type blockingHandler struct {
+	recordingHandler
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) Command(ctx context.Context, cmd *slack.SlashCommand, w http.ResponseWriter) {
+	close(h.started)
+	<-h.release
+}
+
+func TestRunnerDrainDeadline(t *testing.T) {
+	cmd, _ := json.Marshal(slack.SlashCommand{Command: "/enqueue", TriggerID: "t1"})
+	f := makeFakeSlack(t, []Envelope{{EnvelopeID: "e1", Type: SlashCommandsType, Payload: cmd}})
+	defer f.srv.Close()
+
+	h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(h.release)
+	r := MakeRunner(f.srv.URL+"/api/", "xapp-test", h, server.MakeDedupe(time.Minute), 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	<-h.started
+	cancel()
+	select {
+	case err := <-done:
+		if d, ok := err.(server.DrainError); !ok || d.InFlight != 1 {
+			t.Fatalf("Expected a drain error with a request in flight, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run blocked on a stuck handler.")
+	}
+}
+
+func TestRunnerAcksAfterShutdown(t *testing.T) {
+	cmd, _ := json.Marshal(slack.SlashCommand{Command: "/enqueue", TriggerID: "t1"})
+	f := makeFakeSlack(t, []Envelope{{EnvelopeID: "e1", Type: SlashCommandsType, Payload: cmd}})
+	defer f.srv.Close()
+
+	h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	r := MakeRunner(f.srv.URL+"/api/", "xapp-test", h, server.MakeDedupe(time.Minute), 5*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	<-h.started
+	cancel()
+	// The connection stays open for the handler in flight to ack.
+	time.Sleep(20 * time.Millisecond)
+	close(h.release)
+	if a := f.ack(t); a.EnvelopeID != "e1" {
+		t.Fatalf("Unexpected ack %+v", a)
+	}
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected a clean drain, got %v", err)
+	}
+}